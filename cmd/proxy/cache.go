@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/urfave/cli/v2"
+)
+
+var snapshotFileFlag = &cli.StringFlag{
+	Name:     "file",
+	Aliases:  []string{"f"},
+	Required: true,
+	Usage:    "Snapshot file. JSON lines, gzip compressed for .gz extension",
+}
+
+func cacheCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "cache",
+		Usage: "Manage the configured cache storage",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "export",
+				Usage:  "Dump cache entries to a snapshot file",
+				Flags:  []cli.Flag{snapshotFileFlag},
+				Action: cacheExportCommand,
+			},
+			{
+				Name:   "import",
+				Usage:  "Load cache entries from a snapshot file",
+				Flags:  []cli.Flag{snapshotFileFlag},
+				Action: cacheImportCommand,
+			},
+			{
+				Name:   "stats",
+				Usage:  "Print number of entries and hits by method",
+				Action: cacheStatsCommand,
+			},
+			{
+				Name:  "purge",
+				Usage: "Delete entries of the methods",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:     "method",
+						Aliases:  []string{"m"},
+						Required: true,
+						Usage:    "Method name or glob pattern",
+					},
+				},
+				Action: cachePurgeCommand,
+			},
+		},
+	}
+}
+
+// withCache runs f with the cache storage from config
+func withCache(c *cli.Context, f func(conf *config.Config, cacheImpl cache.Cache) error) error {
+	conf, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	return withConfigCache(conf, f)
+}
+
+func withConfigCache(conf *config.Config, f func(conf *config.Config, cacheImpl cache.Cache) error) error {
+	cacheImpl, err := cache.FromConfig(context.Background(), conf)
+	if err != nil {
+		return err
+	}
+	defer cacheImpl.Close()
+	return f(conf, cacheImpl)
+}
+
+// withSharedCache runs f with the cache storage shared with running proxies
+func withSharedCache(c *cli.Context, f func(conf *config.Config, cacheImpl cache.Cache) error) error {
+	conf, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	if conf.CacheSettings.Storage.IsMemory() {
+		return fmt.Errorf("memory cache belongs to the running proxy. Use cache_settings.snapshot to save it on shutdown")
+	}
+	return withConfigCache(conf, f)
+}
+
+func cacheExportCommand(c *cli.Context) error {
+	return withSharedCache(c, func(conf *config.Config, cacheImpl cache.Cache) error {
+		count, err := cache.ExportFile(cacheImpl, c.String("file"))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Exported %d cache entries to %s\n", count, c.String("file"))
+		return nil
+	})
+}
+
+func cacheImportCommand(c *cli.Context) error {
+	return withCache(c, func(_ *config.Config, cacheImpl cache.Cache) error {
+		count, err := cache.ImportFile(cacheImpl, c.String("file"))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d cache entries from %s\n", count, c.String("file"))
+		return nil
+	})
+}
+
+type methodStats struct {
+	entries int
+	hits    int64
+}
+
+func cacheStatsCommand(c *cli.Context) error {
+	return withSharedCache(c, func(_ *config.Config, cacheImpl cache.Cache) error {
+		entries, err := cacheImpl.Entries()
+		if err != nil {
+			return err
+		}
+		stats := map[string]*methodStats{}
+		var methods []string
+		for _, entry := range entries {
+			method := entry.Request.Method
+			if _, ok := stats[method]; !ok {
+				stats[method] = &methodStats{}
+				methods = append(methods, method)
+			}
+			stats[method].entries++
+			stats[method].hits += entry.Hits
+		}
+		sort.Strings(methods)
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(writer, "METHOD\tENTRIES\tHITS")
+		for _, method := range methods {
+			_, _ = fmt.Fprintf(writer, "%s\t%d\t%d\n", method, stats[method].entries, stats[method].hits)
+		}
+		_, _ = fmt.Fprintf(writer, "TOTAL\t%d\t\n", len(entries))
+		return writer.Flush()
+	})
+}
+
+func cachePurgeCommand(c *cli.Context) error {
+	return withSharedCache(c, func(_ *config.Config, cacheImpl cache.Cache) error {
+		count, err := purgeMethods(c.Context, cacheImpl, c.StringSlice("method"))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %d cache entries\n", count)
+		return nil
+	})
+}
+
+// purgeMethods deletes entries of methods matching any of the patterns and returns the number of deleted entries
+func purgeMethods(ctx context.Context, cacheImpl cache.Cache, patterns []string) (int, error) {
+	entries, err := cacheImpl.Entries()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, entry := range entries {
+		if !matchesAny(patterns, entry.Request.Method) {
+			continue
+		}
+		if err := cacheImpl.Delete(ctx, entry.Key); err != nil {
+			return count, fmt.Errorf("cannot delete %s: %w", entry.Key, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+func matchesAny(patterns []string, method string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, method); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/stretchr/testify/require"
+)
+
+// writeConfig writes the memory cache config for the upstream url and returns the file name
+func writeConfig(t *testing.T, url string, extra string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "config.yaml")
+	data := fmt.Sprintf("proxy_url: %s\njwt_secret: secret\nlog_level: ERROR\n%s", url, extra)
+	require.NoError(t, ioutil.WriteFile(name, []byte(data), 0600))
+	return name
+}
+
+// runApp runs the command with the config file and returns its output
+func runApp(t *testing.T, configFile string, args ...string) (string, error) {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = writer
+	output := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(reader)
+		output <- string(data)
+	}()
+	err = prepareCliApp().Run(append([]string{"proxy", "-c", configFile}, args...))
+	os.Stdout = stdout
+	require.NoError(t, writer.Close())
+	return <-output, err
+}
+
+func cacheEntry(method string, params ...interface{}) (requests.RPCRequest, requests.RPCResponse) {
+	return requests.RPCRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: params},
+		requests.RPCResponse{JSONRPC: "2.0", ID: 1, Result: method}
+}
+
+func TestCacheImportCommand(t *testing.T) {
+	source := cache.NewMemoryCacheDefault()
+	for idx, method := range []string{"Filecoin.ChainHead", "Filecoin.StateGetActor"} {
+		req, resp := cacheEntry(method, idx)
+		require.NoError(t, source.Set(context.Background(), method, req, resp, time.Hour))
+	}
+	snapshot := filepath.Join(t.TempDir(), "cache.jsonl.gz")
+	count, err := cache.ExportFile(source, snapshot)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	output, err := runApp(t, writeConfig(t, "http://127.0.0.1:1", ""), "cache", "import", "-f", snapshot)
+	require.NoError(t, err)
+	require.Contains(t, output, "Imported 2 cache entries")
+
+	_, err = runApp(t, writeConfig(t, "http://127.0.0.1:1", ""), "cache", "export", "-f", snapshot)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "memory cache belongs to the running proxy")
+}
+
+func TestCachePurgeMethods(t *testing.T) {
+	cacheImpl := cache.NewMemoryCacheDefault()
+	for idx, method := range []string{"Filecoin.ChainGetTipSetByHeight", "Filecoin.ChainGetBlock", "Filecoin.StateGetActor"} {
+		req, resp := cacheEntry(method, idx)
+		require.NoError(t, cacheImpl.Set(context.Background(), method, req, resp, time.Hour))
+	}
+
+	count, err := purgeMethods(context.Background(), cacheImpl, []string{"Filecoin.ChainGet*"})
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	entries, err := cacheImpl.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "Filecoin.StateGetActor", entries[0].Request.Method)
+
+	_, err = runApp(t, writeConfig(t, "http://127.0.0.1:1", ""), "cache", "purge", "-m", "Filecoin.ChainGet*")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "memory cache belongs to the running proxy")
+}
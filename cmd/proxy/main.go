@@ -7,16 +7,19 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/chain"
 	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/leader"
 	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
-	"github.com/protofire/filecoin-rpc-proxy/internal/matcher"
 	"github.com/protofire/filecoin-rpc-proxy/internal/metrics"
 	"github.com/protofire/filecoin-rpc-proxy/internal/proxy"
 	"github.com/protofire/filecoin-rpc-proxy/internal/updater"
+	"github.com/protofire/filecoin-rpc-proxy/internal/upstream"
 	"github.com/protofire/filecoin-rpc-proxy/internal/utils"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
@@ -43,21 +46,23 @@ func getConfigExample() (string, error) {
 	return string(c), nil
 }
 
-func startCommand(c *cli.Context) error {
+// loadConfig reads config file with command line overrides
+func loadConfig(c *cli.Context) (*config.Config, error) {
 	configFile := c.String("config")
 	if configFile == "" {
 		configFile = getDefaultConfigFilePath()
 	}
 	if !utils.FileExists(configFile) {
-		return fmt.Errorf("cannot find conf file file: %s", configFile)
+		return nil, fmt.Errorf("cannot find conf file file: %s", configFile)
 	}
 	conf, err := config.FromFile(configFile, config.CmdLineParams{
-		JWTSecret: c.String("jwt-secret"),
-		ProxyURL:  c.String("proxy-url"),
-		RedisURI:  c.String("redis-uri"),
+		JWTSecret:     c.String("jwt-secret"),
+		ProxyURL:      c.String("proxy-url"),
+		RedisURI:      c.String("redis-uri"),
+		UpstreamToken: c.String("upstream-token"),
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	jwtSecret := c.String("jwt-secret")
 	proxyURL := c.String("proxy-url")
@@ -67,6 +72,14 @@ func startCommand(c *cli.Context) error {
 	if proxyURL != "" {
 		conf.ProxyURL = proxyURL
 	}
+	return conf, nil
+}
+
+func startCommand(c *cli.Context) error {
+	conf, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
 	log := logger.InitLogger(conf.LogLevel, conf.LogPrettyPrint)
 
 	stop := make(chan os.Signal, 1)
@@ -79,18 +92,53 @@ func startCommand(c *cli.Context) error {
 		done()
 		return err
 	}
+	if snapshot := conf.CacheSettings.Snapshot; snapshot != "" && utils.FileExists(snapshot) {
+		count, err := cache.ImportFile(cacheImpl, snapshot)
+		if err != nil {
+			log.Errorf("Cannot load cache snapshot %s: %v", snapshot, err)
+		} else {
+			log.Infof("Loaded %d cache entries from %s", count, snapshot)
+		}
+	}
 
-	cacher := proxy.NewResponseCache(
-		cacheImpl,
-		matcher.FromConfig(conf),
-	)
-	transportImp := proxy.NewTransport(cacher, log, conf.DebugHTTPRequest, conf.DebugHTTPResponse)
+	// one upstream client shares the connection pool between the proxy, the updater and the checks
+	client, err := upstream.FromConfig(conf)
+	if err != nil {
+		done()
+		return fmt.Errorf("cannot initialize upstream client: %w", err)
+	}
+	cacher, err := proxy.NewResponseCacheFromConfig(conf, cacheImpl, client, log)
+	if err != nil {
+		done()
+		return err
+	}
+	head, err := chain.FromConfig(conf, client, log)
+	if err != nil {
+		done()
+		return err
+	}
+	cacher.SetHeadSource(head)
+	transportImp, err := proxy.TransportFromConfig(conf, cacher, client, log)
+	if err != nil {
+		done()
+		return err
+	}
 
-	updaterImp, err := updater.FromConfig(conf, cacher, log)
+	updaterImp, err := updater.FromConfig(conf, cacher, client, log)
+	if err != nil {
+		done()
+		return err
+	}
+	updaterImp.SetHeadSource(head)
+	elector, err := leader.FromConfig(ctx, conf, log)
 	if err != nil {
 		done()
 		return err
 	}
+	updaterImp.SetElector(elector)
+	if err := head.Update(ctx); err != nil {
+		log.Errorf("Cannot get chain head: %v", err)
+	}
 
 	server, err := proxy.FromConfigWithTransport(conf, log, transportImp)
 	if err != nil {
@@ -98,34 +146,36 @@ func startCommand(c *cli.Context) error {
 		return err
 	}
 
-	defer func() {
-		done()
-		_ = server.Close()
-	}()
+	server.SetUpdater(updaterImp)
+	server.SetChainHead(head)
+
+	defer done()
 
 	metrics.Register()
 
 	handler := proxy.PrepareRoutes(conf, log, server)
 	s := server.StartHTTPServer(handler)
 
+	// background writers are waited for before the cache is closed
+	var background sync.WaitGroup
+	runBackground := func(run func(context.Context)) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			run(ctx)
+		}()
+	}
+	runBackground(head.Start)
+	runBackground(elector.Run)
+	go server.WatchCertificates(ctx)
+	go server.KeyStore().Watch(ctx, time.Duration(conf.APIKeys.ReloadPeriod)*time.Second)
 	go updaterImp.StartMethodUpdater(ctx, conf.UpdateCustomCachePeriod)
 	go updaterImp.StartCacheUpdater(ctx, conf.UpdateUserCachePeriod)
+	go updaterImp.StartScheduledUpdater(ctx)
 
 	sig := <-stop
 	log.Infof("Caught sig: %+v. Waiting process is being stopped...", sig)
 	done()
-	if err := cacheImpl.Close(); err != nil {
-		log.Error(err)
-	}
-
-	ctxUpdater, cancelUpdater := context.WithTimeout(context.Background(), time.Duration(conf.ShutdownTimeout)*time.Second)
-	defer cancelUpdater()
-
-	if updaterImp.StopWithTimeout(ctxUpdater, 2) {
-		log.Info("Shut down server gracefully")
-	} else {
-		log.Info("Shut down server forcibly")
-	}
 
 	stopTimeout := 2
 	ctxServer, cancelServer := context.WithTimeout(context.Background(), time.Duration(stopTimeout)*time.Second)
@@ -136,9 +186,46 @@ func startCommand(c *cli.Context) error {
 		log.Info("Server has been stopped successfully")
 	}
 
+	ctxShutdown, cancelShutdown := context.WithTimeout(context.Background(), time.Duration(conf.ShutdownTimeout)*time.Second)
+	defer cancelShutdown()
+	if updaterImp.StopWithTimeout(ctxShutdown, 3) && waitGroup(ctxShutdown, &background) {
+		log.Info("Shut down server gracefully")
+	} else {
+		log.Info("Shut down server forcibly")
+	}
+	if !cacher.Shutdown(ctxShutdown) {
+		log.Warn("Canceled checks of responses with mirrors")
+	}
+	if snapshot := conf.CacheSettings.Snapshot; snapshot != "" && conf.CacheSettings.Storage.IsMemory() {
+		count, err := cache.ExportFile(cacheImpl, snapshot)
+		if err != nil {
+			log.Errorf("Cannot save cache snapshot %s: %v", snapshot, err)
+		} else {
+			log.Infof("Saved %d cache entries to %s", count, snapshot)
+		}
+	}
+	if err := cacheImpl.Close(); err != nil {
+		log.Error(err)
+	}
+
 	return err
 }
 
+// waitGroup waits for the group. Returns false when the context is done first
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) bool {
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 func prepareCliApp() *cli.App {
 	configExample, err := getConfigExample()
 	if err != nil {
@@ -155,6 +242,12 @@ func prepareCliApp() *cli.App {
 	app.Usage = "JSON PRC cached proxy"
 	app.EnableBashCompletion = true
 	app.Action = startCommand
+	app.Commands = []*cli.Command{
+		configCommand(),
+		tokenCommand(),
+		cacheCommand(),
+		methodsCommand(),
+	}
 	app.Description = fmt.Sprintf(`
 Default config file is: ~/config.yaml
 Config file example:
@@ -193,6 +286,12 @@ Config file example:
 			Required: false,
 			Usage:    "Redis URI",
 		},
+		&cli.StringFlag{
+			Name:     "upstream-token",
+			EnvVars:  []string{"PROXY_UPSTREAM_TOKEN"},
+			Required: false,
+			Usage:    "Lotus token injected into forwarded requests",
+		},
 	}
 
 	return app
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/upstream"
+	"github.com/urfave/cli/v2"
+)
+
+func methodsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "methods",
+		Usage: "Inspect configured cache methods",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "check",
+				Usage:  "Check that upstream provides every configured method",
+				Action: methodsCheckCommand,
+			},
+		},
+	}
+}
+
+func methodsCheckCommand(c *cli.Context) error {
+	conf, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	log := logger.InitLogger(conf.LogLevel, conf.LogPrettyPrint)
+	tokenSource, err := auth.ProxyTokenFromConfig(conf)
+	if err != nil {
+		return err
+	}
+	token, err := tokenSource.Token()
+	if err != nil {
+		return err
+	}
+	client, err := upstream.FromConfig(conf)
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "METHOD\tSTATUS")
+	missing := 0
+	for _, method := range conf.CacheMethods {
+		status := "ok"
+		switch {
+		case !method.Enabled:
+			status = "skipped: disabled"
+		case method.NameMatch != config.ExactMatch:
+			status = "skipped: pattern"
+		default:
+			// params are not needed to tell an unknown method from invalid params
+			responses, _, err := requests.RequestWithClient(c.Context, client, conf.ProxyURL, token, log, false, false, requests.RPCRequests{{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  method.Name,
+				Params:  []interface{}{},
+			}})
+			switch {
+			case err != nil:
+				return fmt.Errorf("cannot request upstream: %w", err)
+			case len(responses) == 1 && responses[0].IsMethodNotFound():
+				status = "missing"
+				missing++
+			}
+		}
+		_, _ = fmt.Fprintf(writer, "%s\t%s\n", method.Name, status)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if missing > 0 {
+		return fmt.Errorf("upstream does not provide %d configured methods", missing)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMethodsCheckCommand(t *testing.T) {
+	var checked []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs, err := requests.ParseRequests(r)
+		require.NoError(t, err)
+		require.Len(t, reqs, 1)
+		checked = append(checked, reqs[0].Method)
+		var response interface{} = requests.RPCResponse{JSONRPC: "2.0", ID: reqs[0].ID, Result: 1}
+		if reqs[0].Method == "Filecoin.StateMissing" {
+			response = map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      reqs[0].ID,
+				"error":   map[string]interface{}{"code": -32601, "message": "method 'Filecoin.StateMissing' not found"},
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(response))
+	}))
+	defer backend.Close()
+
+	conf := writeConfig(t, backend.URL, `cache_methods:
+  - name: Filecoin.ChainHead
+    enabled: true
+  - name: Filecoin.StateMissing
+    enabled: true
+  - name: Filecoin.ChainGetTipSetByHeight
+    enabled: false
+`)
+	output, err := runApp(t, conf, "methods", "check")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "upstream does not provide 1 configured methods")
+	require.Regexp(t, `Filecoin.ChainHead\s+ok`, output)
+	require.Regexp(t, `Filecoin.StateMissing\s+missing`, output)
+	require.Regexp(t, `Filecoin.ChainGetTipSetByHeight\s+skipped: disabled`, output)
+	require.Equal(t, []string{"Filecoin.ChainHead", "Filecoin.StateMissing"}, checked)
+}
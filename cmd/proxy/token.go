@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
+	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/urfave/cli/v2"
+)
+
+func tokenCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "token",
+		Usage: "Manage client JWT tokens",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "create",
+				Usage: "Create a token signed with the configured secret",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "subject",
+						Aliases: []string{"s"},
+						Usage:   "Client name",
+					},
+					&cli.StringSliceFlag{
+						Name:    "permission",
+						Aliases: []string{"p"},
+						Usage:   "Token permission. Default is jwt_permissions from config",
+					},
+					&cli.StringFlag{
+						Name:  "tier",
+						Usage: "Rate limit tier of the client",
+					},
+					&cli.StringSliceFlag{
+						Name:    "method",
+						Aliases: []string{"m"},
+						Usage:   "Allowed method name or glob pattern. Default allows all methods",
+					},
+					&cli.DurationFlag{
+						Name:    "expiry",
+						Aliases: []string{"e"},
+						Usage:   "Token lifetime, e.g. 720h. Zero means the token never expires",
+					},
+				},
+				Action: tokenCreateCommand,
+			},
+			{
+				Name:  "revoke",
+				Usage: "Add a token to the redis revocation list. Use tokens.revoked for the memory revocation list",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "id",
+						Usage: "Token ID (jti claim). The token is revoked forever",
+					},
+					&cli.StringFlag{
+						Name:    "token",
+						Aliases: []string{"t"},
+						Usage:   "Token. The token is revoked until it expires",
+					},
+				},
+				Action: tokenRevokeCommand,
+			},
+		},
+	}
+}
+
+func tokenCreateCommand(c *cli.Context) error {
+	conf, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	permissions := c.StringSlice("permission")
+	if len(permissions) == 0 {
+		permissions = conf.JWTPermissions
+	}
+	if tier := c.String("tier"); tier != "" && !hasTier(conf.RateLimitTiers, tier) {
+		return fmt.Errorf("unknown rate limit tier: %s", tier)
+	}
+	claims, err := auth.NewClaims(c.String("subject"), permissions, c.Duration("expiry"))
+	if err != nil {
+		return err
+	}
+	claims.Tier = c.String("tier")
+	claims.Methods = c.StringSlice("method")
+	token, err := auth.NewToken(conf.JWT(), conf.JWTAlgorithm, claims)
+	if err != nil {
+		return fmt.Errorf("cannot create token: %w", err)
+	}
+	fmt.Println(string(token))
+	return nil
+}
+
+func hasTier(tiers []config.RateLimitTier, name string) bool {
+	for _, tier := range tiers {
+		if tier.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func tokenRevokeCommand(c *cli.Context) error {
+	conf, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	if !conf.Tokens.RevocationStorage.IsRedis() {
+		return fmt.Errorf("memory revocation list belongs to the running proxy. Add the token ID to tokens.revoked")
+	}
+	id, expiresAt, err := revokedToken(conf, c.String("id"), c.String("token"))
+	if err != nil {
+		return err
+	}
+	return withConfigCache(conf, func(conf *config.Config, cacheImpl cache.Cache) error {
+		revocations, err := auth.RevocationsFromConfig(conf, cacheImpl)
+		if err != nil {
+			return err
+		}
+		if err := revocations.Revoke(id, expiresAt); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked token %s\n", id)
+		return nil
+	})
+}
+
+// revokedToken returns the ID and the expiry of the token to revoke. The token takes precedence over the ID
+func revokedToken(conf *config.Config, id string, token string) (string, time.Time, error) {
+	if token == "" {
+		if id == "" {
+			return "", time.Time{}, fmt.Errorf("either --id or --token should be set")
+		}
+		return id, time.Time{}, nil
+	}
+	claims, err := auth.ParseToken(conf.JWT(), conf.JWTAlgorithm, token)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid token: %w", err)
+	}
+	if claims.ID == "" {
+		return "", time.Time{}, fmt.Errorf("token has no ID and cannot be revoked. Rotate jwt_secret instead")
+	}
+	var expiresAt time.Time
+	if claims.ExpiresAt > 0 {
+		expiresAt = time.Unix(claims.ExpiresAt, 0)
+	}
+	return claims.ID, expiresAt, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenCommands(t *testing.T) {
+	file := writeConfig(t, "http://127.0.0.1:1", `rate_limit_tiers:
+  - name: basic
+    requests_per_second: 10
+    burst: 20
+`)
+	conf, err := config.FromFile(file, config.CmdLineParams{})
+	require.NoError(t, err)
+
+	output, err := runApp(t, file, "token", "create", "--subject", "script", "--permission", "read",
+		"--tier", "basic", "--method", "Filecoin.Chain*", "--expiry", "1h")
+	require.NoError(t, err)
+	token := strings.TrimSpace(output)
+	claims, err := auth.ParseToken(conf.JWT(), conf.JWTAlgorithm, token)
+	require.NoError(t, err)
+	require.Equal(t, "script", claims.Subject)
+	require.Equal(t, []string{"read"}, claims.Allow)
+	require.Equal(t, "basic", claims.Tier)
+	require.Equal(t, []string{"Filecoin.Chain*"}, claims.Methods)
+	require.NotEmpty(t, claims.ID)
+	require.NotZero(t, claims.ExpiresAt)
+
+	_, err = runApp(t, file, "token", "create", "--tier", "premium")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown rate limit tier")
+
+	id, expiresAt, err := revokedToken(conf, "", token)
+	require.NoError(t, err)
+	require.Equal(t, claims.ID, id)
+	require.Equal(t, claims.ExpiresAt, expiresAt.Unix())
+
+	id, expiresAt, err = revokedToken(conf, "token-id", "")
+	require.NoError(t, err)
+	require.Equal(t, "token-id", id)
+	require.True(t, expiresAt.IsZero())
+
+	noID, err := auth.NewJWT(conf.JWT(), conf.JWTAlgorithm, conf.JWTPermissions)
+	require.NoError(t, err)
+	_, _, err = revokedToken(conf, "", string(noID))
+	require.Error(t, err)
+	_, _, err = revokedToken(conf, "", "")
+	require.Error(t, err)
+
+	_, err = runApp(t, file, "token", "revoke", "--id", "token-id")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "memory revocation list belongs to the running proxy")
+}
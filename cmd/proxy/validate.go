@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+const redacted = "REDACTED"
+
+func configCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "config",
+		Usage: "Inspect the config file",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "validate",
+				Usage:  "Validate the config and print it with defaults applied. Secrets are redacted",
+				Action: configValidateCommand,
+			},
+		},
+	}
+}
+
+// redactConfig hides secrets of the config copy
+func redactConfig(c config.Config) config.Config {
+	for _, secret := range []*string{&c.JWTSecret, &c.JWTSecretBase64, &c.UpstreamToken} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	if uri, err := url.Parse(c.CacheSettings.Redis.URI); err == nil {
+		c.CacheSettings.Redis.URI = uri.Redacted()
+	}
+	return c
+}
+
+func configValidateCommand(c *cli.Context) error {
+	conf, err := loadConfig(c)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	data, err := yaml.Marshal(redactConfig(*conf))
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(data))
+	return nil
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// HashAPIKey returns hex encoded sha256 digest of the raw key as it is stored in the config
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// KeyStore keeps hashed API keys. Keys from the keys file are reloaded when the file changes
+type KeyStore struct {
+	lock        sync.RWMutex
+	keys        map[string]config.APIKey
+	staticKeys  []config.APIKey
+	file        string
+	modTime     time.Time
+	permissions []string
+	tiers       []config.RateLimitTier
+	logger      *logrus.Entry
+}
+
+// NewKeyStore initializes key store with static keys and the optional keys file
+func NewKeyStore(keys []config.APIKey, file string, permissions []string, tiers []config.RateLimitTier, logger *logrus.Entry) (*KeyStore, error) {
+	s := &KeyStore{
+		staticKeys:  keys,
+		file:        file,
+		permissions: permissions,
+		tiers:       tiers,
+		logger:      logger,
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// KeyStoreFromConfig initializes key store from config
+func KeyStoreFromConfig(c *config.Config, logger *logrus.Entry) (*KeyStore, error) {
+	return NewKeyStore(c.APIKeys.Keys, c.APIKeys.File, c.JWTPermissions, c.RateLimitTiers, logger)
+}
+
+// Enabled reports whether the store has any keys
+func (s *KeyStore) Enabled() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.keys) > 0 || s.file != ""
+}
+
+// Lookup finds API key by the raw key value
+func (s *KeyStore) Lookup(key string) (config.APIKey, bool) {
+	if key == "" {
+		return config.APIKey{}, false
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	apiKey, ok := s.keys[HashAPIKey(key)]
+	return apiKey, ok
+}
+
+// Reload rereads the keys file. Static keys have precedence over keys from the file
+func (s *KeyStore) Reload() error {
+	keys := make(map[string]config.APIKey, len(s.staticKeys))
+	var modTime time.Time
+	if s.file != "" {
+		stat, err := os.Stat(s.file)
+		if err != nil {
+			return err
+		}
+		modTime = stat.ModTime()
+		fileKeys, err := config.APIKeysFromFile(s.file, s.permissions)
+		if err != nil {
+			return err
+		}
+		if err := config.ValidateAPIKeys(fileKeys, s.tiers); err != nil {
+			return err
+		}
+		for _, key := range fileKeys {
+			keys[key.Hash] = key
+		}
+	}
+	for _, key := range s.staticKeys {
+		keys[key.Hash] = key
+	}
+	s.lock.Lock()
+	s.keys = keys
+	s.modTime = modTime
+	s.lock.Unlock()
+	return nil
+}
+
+func (s *KeyStore) changed() bool {
+	stat, err := os.Stat(s.file)
+	if err != nil {
+		s.logger.Errorf("Cannot stat api keys file: %v", err)
+		return false
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return !stat.ModTime().Equal(s.modTime)
+}
+
+// Watch reloads the keys file every period when it has been changed. Keeps the previous keys on failure
+func (s *KeyStore) Watch(ctx context.Context, period time.Duration) {
+	if s.file == "" {
+		return
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.changed() {
+				continue
+			}
+			if err := s.Reload(); err != nil {
+				s.logger.Errorf("Cannot reload api keys: %v", err)
+				continue
+			}
+			s.logger.Info("API keys have been reloaded")
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
 	"github.com/gbrlsnchs/jwt/v3"
 	"github.com/go-chi/jwtauth"
 )
@@ -9,8 +13,37 @@ func JWTSecret(secret []byte, alg string) *jwtauth.JWTAuth {
 	return jwtauth.New(alg, secret, nil)
 }
 
-type jwtPayload struct {
+// Claims of the proxy tokens
+type Claims struct {
 	Allow []string
+	// Subject is the client name
+	Subject   string `json:"sub,omitempty"`
+	ID        string `json:"jti,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	// Tier is the rate limit tier of the client
+	Tier string `json:"tier,omitempty"`
+	// Methods the client is allowed to call. Glob patterns are supported. Empty list allows all methods
+	Methods []string `json:"methods,omitempty"`
+}
+
+// NewClaims returns claims with a random ID issued now. Zero ttl means the token never expires
+func NewClaims(subject string, perms []string, ttl time.Duration) (Claims, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Claims{}, err
+	}
+	now := time.Now()
+	claims := Claims{
+		Allow:    perms,
+		Subject:  subject,
+		ID:       hex.EncodeToString(id),
+		IssuedAt: now.Unix(),
+	}
+	if ttl > 0 {
+		claims.ExpiresAt = now.Add(ttl).Unix()
+	}
+	return claims, nil
 }
 
 func getJWTAlgorithm(alg string, secret []byte) *jwt.HMACSHA {
@@ -26,9 +59,19 @@ func getJWTAlgorithm(alg string, secret []byte) *jwt.HMACSHA {
 	return algFunc(secret)
 }
 
+// NewToken signs the claims
+func NewToken(secret []byte, alg string, claims Claims) ([]byte, error) {
+	return jwt.Sign(&claims, getJWTAlgorithm(alg, secret))
+}
+
+// ParseToken verifies the token signature and returns its claims
+func ParseToken(secret []byte, alg string, token string) (Claims, error) {
+	claims := Claims{}
+	_, err := jwt.Verify([]byte(token), getJWTAlgorithm(alg, secret), &claims)
+	return claims, err
+}
+
+// NewJWT creates a token with permissions only. The token never expires
 func NewJWT(secret []byte, alg string, perms []string) ([]byte, error) {
-	p := jwtPayload{
-		Allow: perms,
-	}
-	return jwt.Sign(&p, getJWTAlgorithm(alg, secret))
+	return NewToken(secret, alg, Claims{Allow: perms})
 }
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var secret = []byte("secret")
+
+func TestTokenClaims(t *testing.T) {
+	claims, err := NewClaims("script", []string{"read"}, time.Hour)
+	require.NoError(t, err)
+	claims.Tier = "basic"
+	claims.Methods = []string{"Filecoin.Chain*"}
+	require.NotEmpty(t, claims.ID)
+	require.Equal(t, claims.IssuedAt+3600, claims.ExpiresAt)
+
+	token, err := NewToken(secret, "HS256", claims)
+	require.NoError(t, err)
+	parsed, err := ParseToken(secret, "HS256", string(token))
+	require.NoError(t, err)
+	require.Equal(t, claims, parsed)
+	_, err = ParseToken([]byte("other"), "HS256", string(token))
+	require.Error(t, err)
+
+	identity := IdentityFromClaims(map[string]interface{}{
+		"Allow":   []interface{}{"read"},
+		"sub":     "script",
+		"jti":     claims.ID,
+		"tier":    "basic",
+		"methods": []interface{}{"Filecoin.Chain*"},
+	})
+	require.Equal(t, Identity{
+		Name:        "script",
+		Permissions: []string{"read"},
+		Tier:        "basic",
+		Methods:     []string{"Filecoin.Chain*"},
+		TokenID:     claims.ID,
+	}, identity)
+}
+
+func TestMintedToken(t *testing.T) {
+	source := NewMintedToken(secret, "HS256", []string{"read"}, time.Hour)
+	token1, err := source.Token()
+	require.NoError(t, err)
+	token2, err := source.Token()
+	require.NoError(t, err)
+	require.Equal(t, token1, token2)
+
+	claims, err := ParseToken(secret, "HS256", token1)
+	require.NoError(t, err)
+	require.Equal(t, upstreamSubject, claims.Subject)
+	require.NotZero(t, claims.ExpiresAt)
+
+	// re-minted when the token is about to expire
+	source.expiresAt = time.Now().Add(time.Minute)
+	token3, err := source.Token()
+	require.NoError(t, err)
+	require.NotEqual(t, token1, token3)
+}
+
+func TestMemoryRevocations(t *testing.T) {
+	revocations := NewMemoryRevocations()
+	require.NoError(t, revocations.Revoke("forever", time.Time{}))
+	require.NoError(t, revocations.Revoke("expiring", time.Now().Add(50*time.Millisecond)))
+	require.NoError(t, revocations.Revoke("expired", time.Now().Add(-time.Minute)))
+
+	for id, expected := range map[string]bool{"forever": true, "expiring": true, "expired": false, "unknown": false} {
+		revoked, err := revocations.IsRevoked(id)
+		require.NoError(t, err)
+		require.Equal(t, expected, revoked, id)
+	}
+	time.Sleep(100 * time.Millisecond)
+	revoked, err := revocations.IsRevoked("expiring")
+	require.NoError(t, err)
+	require.False(t, revoked)
+}
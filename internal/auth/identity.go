@@ -0,0 +1,64 @@
+package auth
+
+import "context"
+
+type identityKey struct{}
+
+// Identity describes an authenticated proxy client
+type Identity struct {
+	Name        string
+	Permissions []string
+	Tier        string
+	// Methods the client is allowed to call. Empty list allows all methods
+	Methods []string
+	// TokenID is the ID of the client token. Empty for API keys and tokens without ID
+	TokenID string
+	// KeyHash is the hex encoded sha256 digest of the client API key or token
+	KeyHash string
+}
+
+// ClientKey identifies the client. Clients without a name are told apart by the token ID or the key hash
+func (i Identity) ClientKey() string {
+	switch {
+	case i.Name != "":
+		return "name/" + i.Name
+	case i.TokenID != "":
+		return "jti/" + i.TokenID
+	default:
+		return "key/" + i.KeyHash
+	}
+}
+
+// WithIdentity stores the client identity in the context
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the client identity stored in the context
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// IdentityFromClaims builds the client identity from JWT claims
+func IdentityFromClaims(claims map[string]interface{}) Identity {
+	identity := Identity{
+		Permissions: stringsClaim(claims["Allow"]),
+		Methods:     stringsClaim(claims["methods"]),
+	}
+	identity.Name, _ = claims["sub"].(string)
+	identity.Tier, _ = claims["tier"].(string)
+	identity.TokenID, _ = claims["jti"].(string)
+	return identity
+}
+
+func stringsClaim(claim interface{}) []string {
+	values, _ := claim.([]interface{})
+	var res []string
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			res = append(res, s)
+		}
+	}
+	return res
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// upstreamSubject is the subject of tokens minted for upstream requests
+const upstreamSubject = "filecoin-rpc-proxy"
+
+// MintedToken is a token source minting short-lived tokens signed with the proxy secret.
+// The token is re-minted when less than a fifth of its lifetime is left
+type MintedToken struct {
+	lock      sync.Mutex
+	secret    []byte
+	alg       string
+	perms     []string
+	ttl       time.Duration
+	token     string
+	expiresAt time.Time
+}
+
+// NewMintedToken initializes minted token source
+func NewMintedToken(secret []byte, alg string, perms []string, ttl time.Duration) *MintedToken {
+	return &MintedToken{
+		secret: secret,
+		alg:    alg,
+		perms:  perms,
+		ttl:    ttl,
+	}
+}
+
+// Token implements TokenSource interface
+func (t *MintedToken) Token() (string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.token != "" && time.Until(t.expiresAt) > t.ttl/5 {
+		return t.token, nil
+	}
+	claims, err := NewClaims(upstreamSubject, t.perms, t.ttl)
+	if err != nil {
+		return "", err
+	}
+	token, err := NewToken(t.secret, t.alg, claims)
+	if err != nil {
+		return "", err
+	}
+	t.token = string(token)
+	t.expiresAt = time.Unix(claims.ExpiresAt, 0)
+	return t.token, nil
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"path"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+)
+
+// readPermission is required by methods without a rule
+const readPermission = "read"
+
+// defaultMethodPermissions follow permissions of the lotus API. The first matching rule wins
+var defaultMethodPermissions = []config.MethodPermission{
+	{Name: "Filecoin.AuthNew", Permission: "admin"},
+	{Name: "Filecoin.Shutdown", Permission: "admin"},
+	{Name: "Filecoin.CreateBackup", Permission: "admin"},
+	{Name: "Filecoin.WalletExport", Permission: "admin"},
+	{Name: "Filecoin.WalletImport", Permission: "admin"},
+	{Name: "Filecoin.WalletDelete", Permission: "admin"},
+	{Name: "Filecoin.MpoolSetConfig", Permission: "admin"},
+	{Name: "Filecoin.NetBlock*", Permission: "admin"},
+	{Name: "Filecoin.NetSetLimit", Permission: "admin"},
+	{Name: "Filecoin.ChainSetHead", Permission: "admin"},
+	{Name: "Filecoin.ChainHotGC", Permission: "admin"},
+	{Name: "Filecoin.ChainPrune", Permission: "admin"},
+	{Name: "Filecoin.ChainPutObj", Permission: "admin"},
+	{Name: "Filecoin.ChainDeleteObj", Permission: "admin"},
+	{Name: "Filecoin.Sync*Bad", Permission: "admin"},
+	{Name: "Filecoin.SyncCheckpoint", Permission: "admin"},
+	{Name: "Filecoin.ClientImport", Permission: "admin"},
+	{Name: "Filecoin.ClientRemoveImport", Permission: "admin"},
+	{Name: "Filecoin.WalletSign*", Permission: "sign"},
+	{Name: "Filecoin.MpoolPushMessage", Permission: "sign"},
+	{Name: "Filecoin.MpoolBatchPushMessage", Permission: "sign"},
+	{Name: "Filecoin.MsigGet*", Permission: "read"},
+	{Name: "Filecoin.Msig*", Permission: "sign"},
+	{Name: "Filecoin.MarketGetReserved", Permission: "read"},
+	{Name: "Filecoin.Market*", Permission: "sign"},
+	{Name: "Filecoin.PaychAvailableFunds*", Permission: "read"},
+	{Name: "Filecoin.PaychStatus", Permission: "read"},
+	{Name: "Filecoin.PaychList", Permission: "read"},
+	{Name: "Filecoin.PaychVoucherCheck*", Permission: "read"},
+	{Name: "Filecoin.PaychVoucherList", Permission: "read"},
+	{Name: "Filecoin.Paych*", Permission: "sign"},
+	{Name: "Filecoin.MpoolPush*", Permission: "write"},
+	{Name: "Filecoin.MpoolBatchPush*", Permission: "write"},
+	{Name: "Filecoin.MpoolClear", Permission: "write"},
+	{Name: "Filecoin.WalletNew", Permission: "write"},
+	{Name: "Filecoin.WalletSetDefault", Permission: "write"},
+	{Name: "Filecoin.NetConnect", Permission: "write"},
+	{Name: "Filecoin.NetDisconnect", Permission: "write"},
+	{Name: "Filecoin.NetProtectAdd", Permission: "admin"},
+	{Name: "Filecoin.NetProtectRemove", Permission: "admin"},
+	{Name: "Filecoin.LogSetLevel", Permission: "write"},
+	{Name: "Filecoin.SyncSubmitBlock", Permission: "write"},
+	{Name: "Filecoin.MinerCreateBlock", Permission: "write"},
+	{Name: "Filecoin.ClientQueryAsk", Permission: "read"},
+	{Name: "Filecoin.ClientGetDealInfo", Permission: "read"},
+	{Name: "Filecoin.Client*", Permission: "write"},
+}
+
+// MethodPermissions maps methods to the permissions required to call them.
+// Methods without a rule require the read permission
+type MethodPermissions struct {
+	rules []config.MethodPermission
+}
+
+// NewMethodPermissions initializes method permissions. The rules take precedence over lotus defaults
+func NewMethodPermissions(rules []config.MethodPermission) *MethodPermissions {
+	return &MethodPermissions{
+		rules: append(append([]config.MethodPermission{}, rules...), defaultMethodPermissions...),
+	}
+}
+
+// MethodPermissionsFromConfig initializes method permissions from config
+func MethodPermissionsFromConfig(c *config.Config) *MethodPermissions {
+	return NewMethodPermissions(c.MethodPermissions)
+}
+
+// Required returns the permission required to call the method
+func (p *MethodPermissions) Required(method string) string {
+	for _, rule := range p.rules {
+		if ok, _ := path.Match(rule.Name, method); ok {
+			return rule.Permission
+		}
+	}
+	return readPermission
+}
+
+// Allowed reports whether the permissions allow calling the method. As in lotus, permissions are not hierarchical
+func (p *MethodPermissions) Allowed(permissions []string, method string) bool {
+	required := p.Required(method)
+	for _, permission := range permissions {
+		if permission == required {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter rejects requests with methods the client permissions do not allow.
+// Requests with prepared responses are skipped. Returns positions of the rejected requests
+func (p *MethodPermissions) Filter(permissions []string, reqs requests.RPCRequests, responses requests.RPCResponses) []int {
+	var rejected []int
+	for idx, req := range reqs {
+		if !responses[idx].IsEmpty() || p.Allowed(permissions, req.Method) {
+			continue
+		}
+		responses[idx] = requests.PermissionDeniedResponse(req.ID, req.Method, p.Required(req.Method))
+		rejected = append(rejected, idx)
+	}
+	return rejected
+}
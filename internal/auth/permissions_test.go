@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMethodPermissions(t *testing.T) {
+	p := NewMethodPermissions([]config.MethodPermission{{Name: "Filecoin.StateCompute", Permission: "write"}})
+	require.Equal(t, "read", p.Required("Filecoin.ChainHead"))
+	require.Equal(t, "write", p.Required("Filecoin.StateCompute"))
+	require.Equal(t, "sign", p.Required("Filecoin.WalletSignMessage"))
+	require.Equal(t, "read", p.Required("Filecoin.MsigGetVested"))
+	require.Equal(t, "sign", p.Required("Filecoin.MsigPropose"))
+	require.Equal(t, "admin", p.Required("Filecoin.AuthNew"))
+	require.True(t, p.Allowed([]string{"read"}, "Filecoin.ChainHead"))
+	require.False(t, p.Allowed([]string{"read"}, "Filecoin.MpoolPush"))
+	require.False(t, p.Allowed([]string{"admin"}, "Filecoin.ChainHead"))
+
+	reqs := requests.RPCRequests{
+		{JSONRPC: "2.0", ID: 1, Method: "Filecoin.ChainHead"},
+		{JSONRPC: "2.0", ID: 2, Method: "Filecoin.WalletExport"},
+	}
+	responses := make(requests.RPCResponses, len(reqs))
+	require.Equal(t, []int{1}, p.Filter([]string{"read", "write"}, reqs, responses))
+	require.True(t, responses[0].IsEmpty())
+	require.Equal(t, 2, responses[1].ID)
+	require.NotNil(t, responses[1].Error)
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	gocache "github.com/patrickmn/go-cache"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+)
+
+const revokedKeyPrefix = "filecoin-rpc-proxy:revoked:"
+
+// Revocations is a list of revoked token IDs
+type Revocations interface {
+	// Revoke adds the token ID to the list until the token expires. Zero time keeps the ID forever
+	Revoke(id string, expiresAt time.Time) error
+	IsRevoked(id string) (bool, error)
+}
+
+// MemoryRevocations keeps revoked token IDs in memory
+type MemoryRevocations struct {
+	ids *gocache.Cache
+}
+
+// NewMemoryRevocations initializes in memory revocation list
+func NewMemoryRevocations() *MemoryRevocations {
+	return &MemoryRevocations{ids: gocache.New(gocache.NoExpiration, time.Hour)}
+}
+
+// Revoke implements Revocations interface
+func (r *MemoryRevocations) Revoke(id string, expiresAt time.Time) error {
+	ttl := gocache.NoExpiration
+	if !expiresAt.IsZero() {
+		ttl = time.Until(expiresAt)
+		if ttl <= 0 {
+			return nil
+		}
+	}
+	r.ids.Set(id, struct{}{}, ttl)
+	return nil
+}
+
+// IsRevoked implements Revocations interface
+func (r *MemoryRevocations) IsRevoked(id string) (bool, error) {
+	_, ok := r.ids.Get(id)
+	return ok, nil
+}
+
+// RedisRevocations keeps revoked token IDs in redis shared by proxy replicas
+type RedisRevocations struct {
+	client *redis.Client
+}
+
+// NewRedisRevocations initializes redis revocation list
+func NewRedisRevocations(client *redis.Client) *RedisRevocations {
+	return &RedisRevocations{client: client}
+}
+
+// Revoke implements Revocations interface
+func (r *RedisRevocations) Revoke(id string, expiresAt time.Time) error {
+	var ttl time.Duration
+	if !expiresAt.IsZero() {
+		ttl = time.Until(expiresAt)
+		if ttl <= 0 {
+			return nil
+		}
+	}
+	if err := r.client.Set(r.client.Context(), revokedKeyPrefix+id, time.Now().Unix(), ttl).Err(); err != nil {
+		return fmt.Errorf("cannot revoke token %s: %w", id, err)
+	}
+	return nil
+}
+
+// IsRevoked implements Revocations interface
+func (r *RedisRevocations) IsRevoked(id string) (bool, error) {
+	count, err := r.client.Exists(r.client.Context(), revokedKeyPrefix+id).Result()
+	if err != nil {
+		return false, fmt.Errorf("cannot check token %s: %w", id, err)
+	}
+	return count > 0, nil
+}
+
+// RevocationsFromConfig initializes revocation list with token IDs revoked in config.
+// Redis revocation list uses the redis client of the cache
+func RevocationsFromConfig(c *config.Config, cacheImpl cache.Cache) (Revocations, error) {
+	var revocations Revocations
+	switch c.Tokens.RevocationStorage {
+	case config.RedisCacheStorage:
+		client, ok := cacheImpl.(*cache.Client)
+		if !ok {
+			return nil, fmt.Errorf("redis token revocation storage requires redis cache storage")
+		}
+		revocations = NewRedisRevocations(client.Client)
+	default:
+		revocations = NewMemoryRevocations()
+	}
+	for _, id := range c.Tokens.Revoked {
+		if err := revocations.Revoke(id, time.Time{}); err != nil {
+			return nil, err
+		}
+	}
+	return revocations, nil
+}
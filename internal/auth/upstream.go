@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+)
+
+// TokenSource provides a token for upstream requests
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// StaticToken is a token source with the constant token
+type StaticToken string
+
+// Token implements TokenSource interface
+func (t StaticToken) Token() (string, error) {
+	return string(t), nil
+}
+
+// FileToken is a token source reading the token from the file. The file is reread when it has been changed
+type FileToken struct {
+	lock    sync.Mutex
+	file    string
+	token   string
+	modTime time.Time
+}
+
+// NewFileToken initializes the file token source and reads the token
+func NewFileToken(file string) (*FileToken, error) {
+	t := &FileToken{file: file}
+	if _, err := t.Token(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Token implements TokenSource interface
+func (t *FileToken) Token() (string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	stat, err := os.Stat(t.file)
+	if err != nil {
+		if t.token != "" {
+			return t.token, nil
+		}
+		return "", err
+	}
+	if t.token != "" && stat.ModTime().Equal(t.modTime) {
+		return t.token, nil
+	}
+	data, err := ioutil.ReadFile(t.file)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("upstream token file %s is empty", t.file)
+	}
+	t.token = token
+	t.modTime = stat.ModTime()
+	return t.token, nil
+}
+
+// UpstreamTokenFromConfig initializes upstream token source from config.
+// Returns nil when the upstream token is not configured
+func UpstreamTokenFromConfig(c *config.Config) (TokenSource, error) {
+	if c.UpstreamToken != "" {
+		return StaticToken(c.UpstreamToken), nil
+	}
+	if c.UpstreamTokenFile != "" {
+		return NewFileToken(c.UpstreamTokenFile)
+	}
+	return nil, nil
+}
+
+// ProxyTokenFromConfig returns the upstream token source from config.
+// Without the upstream token the proxy uses short-lived tokens signed with its own secret
+func ProxyTokenFromConfig(c *config.Config) (TokenSource, error) {
+	token, err := UpstreamTokenFromConfig(c)
+	if err != nil || token != nil {
+		return token, err
+	}
+	return NewMintedToken(c.JWT(), c.JWTAlgorithm, c.JWTPermissions, time.Duration(c.Tokens.UpstreamTTL)*time.Second), nil
+}
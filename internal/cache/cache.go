@@ -3,6 +3,8 @@ package cache
 import (
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
@@ -25,47 +27,208 @@ func (e Error) Error() string {
 type cacheValue struct {
 	Request  requests.RPCRequest
 	Response requests.RPCResponse
+	// ExpiresAt is unix time in nanoseconds. Zero means the value does not expire
+	ExpiresAt int64
+	// Encoded keeps the response result compressed by encodings
+	Encoded map[string][]byte `bson:"encoded,omitempty"`
+}
+
+func newCacheValue(request requests.RPCRequest, response requests.RPCResponse, ttl time.Duration, encoded map[string][]byte) cacheValue {
+	value := cacheValue{
+		Request:  request,
+		Response: response,
+		Encoded:  encoded,
+	}
+	if ttl > 0 {
+		value.ExpiresAt = time.Now().Add(ttl).UnixNano()
+	}
+	return value
+}
+
+func (v cacheValue) expiresAt() time.Time {
+	if v.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, v.ExpiresAt)
+}
+
+func (v cacheValue) expired() bool {
+	return v.ExpiresAt > 0 && time.Now().UnixNano() > v.ExpiresAt
+}
+
+// Entry is a cached request with its access statistics
+type Entry struct {
+	Key      string
+	Request  requests.RPCRequest
+	Response requests.RPCResponse
+	// ExpiresAt is zero when the entry does not expire
+	ExpiresAt  time.Time
+	Created    time.Time
+	LastAccess time.Time
+	Hits       int64
+}
+
+// HitRate returns number of hits per hour since the entry was created
+func (e Entry) HitRate(now time.Time) float64 {
+	age := now.Sub(e.Created).Hours()
+	if age < 1 {
+		age = 1
+	}
+	return float64(e.Hits) / age
 }
 
 // Cache ...
 type Cache interface {
-	Set(key string, request requests.RPCRequest, response requests.RPCResponse) error
-	Get(key string) (requests.RPCResponse, error)
+	// Set stores the response. Zero ttl means the storage default expiration
+	Set(ctx context.Context, key string, request requests.RPCRequest, response requests.RPCResponse, ttl time.Duration) error
+	// SetEncoded stores the response along with its result compressed by encodings
+	SetEncoded(ctx context.Context, key string, request requests.RPCRequest, response requests.RPCResponse, ttl time.Duration, encoded map[string][]byte) error
+	// Get returns the response and updates access statistics of the key
+	Get(ctx context.Context, key string) (requests.RPCResponse, error)
+	// GetEncoded returns the response along with its result compressed by the encoding if it is stored
+	GetEncoded(ctx context.Context, key string, encoding string) (requests.RPCResponse, []byte, error)
+	// Delete removes the key along with its access statistics
+	Delete(ctx context.Context, key string) error
+	// EvictExpired removes expired entries along with their access statistics.
+	// Returns the number of removed entries
+	EvictExpired(ctx context.Context) (int, error)
 	Requests() ([]requests.RPCRequest, error)
+	Entries() ([]Entry, error)
+	// Ping checks the storage is available
+	Ping(ctx context.Context) error
 	Close() error
 	Clean() error
 }
 
+// memoryItem keeps cached value along with access statistics updated atomically.
+// The value of a refreshed key is replaced in place to keep its statistics
+type memoryItem struct {
+	lock       sync.RWMutex
+	value      cacheValue
+	created    int64
+	lastAccess int64
+	hits       int64
+}
+
+func (i *memoryItem) load() cacheValue {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	return i.value
+}
+
+func (i *memoryItem) store(value cacheValue) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.value = value
+}
+
+func (i *memoryItem) entry(key string) Entry {
+	value := i.load()
+	return Entry{
+		Key:        key,
+		Request:    value.Request,
+		Response:   value.Response,
+		ExpiresAt:  value.expiresAt(),
+		Created:    time.Unix(0, atomic.LoadInt64(&i.created)),
+		LastAccess: time.Unix(0, atomic.LoadInt64(&i.lastAccess)),
+		Hits:       atomic.LoadInt64(&i.hits),
+	}
+}
+
 // MemoryCache ...
 type MemoryCache struct {
 	*cache.Cache
+	// lock serializes writes so a refreshed key keeps its item
+	lock sync.Mutex
 }
 
 func (m *MemoryCache) Requests() ([]requests.RPCRequest, error) {
-	res := make([]requests.RPCRequest, m.Cache.ItemCount())
-	for _, item := range m.Cache.Items() {
-		res = append(res, item.Object.(cacheValue).Request)
+	items := m.Cache.Items()
+	res := make([]requests.RPCRequest, 0, len(items))
+	for _, item := range items {
+		res = append(res, item.Object.(*memoryItem).load().Request)
+	}
+	return res, nil
+}
+
+// Entries ...
+func (m *MemoryCache) Entries() ([]Entry, error) {
+	items := m.Cache.Items()
+	res := make([]Entry, 0, len(items))
+	for key, item := range items {
+		res = append(res, item.Object.(*memoryItem).entry(key))
 	}
 	return res, nil
 }
 
 // Set ...
-func (m *MemoryCache) Set(key string, request requests.RPCRequest, response requests.RPCResponse) error {
-	m.Cache.Set(key, cacheValue{
-		Request:  request,
-		Response: response,
-	}, 0)
+func (m *MemoryCache) Set(ctx context.Context, key string, request requests.RPCRequest, response requests.RPCResponse, ttl time.Duration) error {
+	return m.SetEncoded(ctx, key, request, response, ttl, nil)
+}
+
+// SetEncoded ...
+func (m *MemoryCache) SetEncoded(_ context.Context, key string, request requests.RPCRequest, response requests.RPCResponse, ttl time.Duration, encoded map[string][]byte) error {
+	value := newCacheValue(request, response, ttl, encoded)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	// keep statistics of the refreshed entry
+	if val, ok := m.Cache.Get(key); ok {
+		item := val.(*memoryItem)
+		item.store(value)
+		m.Cache.Set(key, item, ttl)
+		return nil
+	}
+	now := time.Now().UnixNano()
+	m.Cache.Set(key, &memoryItem{
+		value:      value,
+		created:    now,
+		lastAccess: now,
+	}, ttl)
 	metrics.SetCacheSize(int64(m.Cache.ItemCount()))
 	return nil
 }
 
 // Get ...
-func (m *MemoryCache) Get(key string) (requests.RPCResponse, error) {
+func (m *MemoryCache) Get(ctx context.Context, key string) (requests.RPCResponse, error) {
+	response, _, err := m.GetEncoded(ctx, key, "")
+	return response, err
+}
+
+// GetEncoded ...
+func (m *MemoryCache) GetEncoded(_ context.Context, key string, encoding string) (requests.RPCResponse, []byte, error) {
 	val, ok := m.Cache.Get(key)
 	if ok {
-		return val.(cacheValue).Response, nil
+		item := val.(*memoryItem)
+		atomic.StoreInt64(&item.lastAccess, time.Now().UnixNano())
+		atomic.AddInt64(&item.hits, 1)
+		value := item.load()
+		return value.Response, value.Encoded[encoding], nil
+	}
+	return requests.RPCResponse{}, nil, nil
+}
+
+// Delete ...
+func (m *MemoryCache) Delete(_ context.Context, key string) error {
+	m.Cache.Delete(key)
+	metrics.SetCacheSize(int64(m.Cache.ItemCount()))
+	return nil
+}
+
+// EvictExpired ...
+func (m *MemoryCache) EvictExpired(_ context.Context) (int, error) {
+	count := m.Cache.ItemCount()
+	m.Cache.DeleteExpired()
+	evicted := count - m.Cache.ItemCount()
+	metrics.SetCacheSize(int64(m.Cache.ItemCount()))
+	return evicted, nil
+}
+
+// Ping ...
+func (m *MemoryCache) Ping(_ context.Context) error {
+	if m.Cache == nil {
+		return Error{message: "memory cache is closed"}
 	}
-	return requests.RPCResponse{}, nil
+	return nil
 }
 
 // Close ...
@@ -83,7 +246,7 @@ func (m *MemoryCache) Clean() error {
 // NewMemoryCache initializes memory cache
 func NewMemoryCache(defaultExpiration, cleanupInterval time.Duration) *MemoryCache {
 	return &MemoryCache{
-		cache.New(defaultExpiration, cleanupInterval),
+		Cache: cache.New(defaultExpiration, cleanupInterval),
 	}
 }
 
@@ -98,7 +261,7 @@ func NewMemoryCacheDefault() *MemoryCache {
 // NewMemoryCacheFromConfig initializes memory cache from config
 func NewMemoryCacheFromConfig(config config.MemoryCacheSettings) *MemoryCache {
 	return &MemoryCache{
-		cache.New(
+		Cache: cache.New(
 			time.Duration(config.DefaultExpiration)*time.Second,
 			time.Duration(config.CleanupInterval)*time.Second,
 		),
@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"context"
+	"sync"
 	"testing"
 	"time"
 
@@ -23,9 +25,9 @@ func TestNewMemoryCacheDefault(t *testing.T) {
 		Result:  nil,
 		Error:   nil,
 	}
-	err := cache.Set("1", expectedRequest, expectedResponse)
+	err := cache.Set(context.Background(), "1", expectedRequest, expectedResponse, 0)
 	require.NoError(t, err)
-	value, err := cache.Get("1")
+	value, err := cache.Get(context.Background(), "1")
 	require.NoError(t, err)
 	require.Equal(t, expectedResponse, value)
 }
@@ -45,10 +47,123 @@ func TestNewMemoryCacheExpired(t *testing.T) {
 		Result:  nil,
 		Error:   nil,
 	}
-	err := cache.Set("1", expectedRequest, expectedResponse)
+	err := cache.Set(context.Background(), "1", expectedRequest, expectedResponse, 0)
 	require.NoError(t, err)
 	time.Sleep(d)
-	value, err := cache.Get("1")
+	value, err := cache.Get(context.Background(), "1")
 	require.NoError(t, err)
 	require.True(t, value.IsEmpty())
 }
+
+func TestMemoryCacheItemTTL(t *testing.T) {
+	cache := NewMemoryCacheDefault()
+	request := requests.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "test"}
+	response := requests.RPCResponse{JSONRPC: "2.0", ID: 1, Result: "result"}
+	err := cache.Set(context.Background(), "1", request, response, 100*time.Millisecond)
+	require.NoError(t, err)
+	value, err := cache.Get(context.Background(), "1")
+	require.NoError(t, err)
+	require.Equal(t, response, value)
+	time.Sleep(150 * time.Millisecond)
+	value, err = cache.Get(context.Background(), "1")
+	require.NoError(t, err)
+	require.True(t, value.IsEmpty())
+}
+
+func TestMemoryCacheEntries(t *testing.T) {
+	cache := NewMemoryCacheDefault()
+	request := requests.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "test"}
+	response := requests.RPCResponse{JSONRPC: "2.0", ID: 1, Result: "result"}
+	require.NoError(t, cache.Set(context.Background(), "1", request, response, 0))
+	require.NoError(t, cache.Set(context.Background(), "2", request, response, 0))
+
+	for i := 0; i < 3; i++ {
+		_, err := cache.Get(context.Background(), "1")
+		require.NoError(t, err)
+	}
+	// refresh keeps access statistics
+	require.NoError(t, cache.Set(context.Background(), "1", request, response, 0))
+
+	entries, err := cache.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	hits := map[string]int64{}
+	for _, entry := range entries {
+		hits[entry.Key] = entry.Hits
+		require.Equal(t, request, entry.Request)
+		require.False(t, entry.LastAccess.Before(entry.Created))
+	}
+	require.Equal(t, map[string]int64{"1": 3, "2": 0}, hits)
+
+	reqs, err := cache.Requests()
+	require.NoError(t, err)
+	require.Equal(t, []requests.RPCRequest{request, request}, reqs)
+
+	require.NoError(t, cache.Delete(context.Background(), "1"))
+	entries, err = cache.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "2", entries[0].Key)
+}
+
+func TestMemoryCacheEncoded(t *testing.T) {
+	cache := NewMemoryCacheDefault()
+	request := requests.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "test"}
+	response := requests.RPCResponse{JSONRPC: "2.0", ID: 1, Result: "result"}
+	require.NoError(t, cache.SetEncoded(context.Background(), "1", request, response, 0, map[string][]byte{"gzip": []byte("encoded")}))
+
+	value, encoded, err := cache.GetEncoded(context.Background(), "1", "gzip")
+	require.NoError(t, err)
+	require.Equal(t, response, value)
+	require.Equal(t, []byte("encoded"), encoded)
+
+	_, encoded, err = cache.GetEncoded(context.Background(), "1", "zstd")
+	require.NoError(t, err)
+	require.Nil(t, encoded)
+
+	// refreshed value drops stale encodings
+	require.NoError(t, cache.Set(context.Background(), "1", request, response, 0))
+	_, encoded, err = cache.GetEncoded(context.Background(), "1", "gzip")
+	require.NoError(t, err)
+	require.Nil(t, encoded)
+}
+
+func TestMemoryCacheRefreshKeepsConcurrentHits(t *testing.T) {
+	cache := NewMemoryCacheDefault()
+	request := requests.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "test"}
+	response := requests.RPCResponse{JSONRPC: "2.0", ID: 1, Result: "result"}
+	require.NoError(t, cache.Set(context.Background(), "1", request, response, 0))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, _ = cache.Get(context.Background(), "1")
+		}()
+		go func() {
+			defer wg.Done()
+			_ = cache.Set(context.Background(), "1", request, response, 0)
+		}()
+	}
+	wg.Wait()
+
+	entries, err := cache.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, int64(100), entries[0].Hits)
+}
+
+func TestMemoryCacheEvictExpired(t *testing.T) {
+	cache := NewMemoryCacheDefault()
+	request := requests.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "test"}
+	response := requests.RPCResponse{JSONRPC: "2.0", ID: 1, Result: "result"}
+	require.NoError(t, cache.Set(context.Background(), "1", request, response, 50*time.Millisecond))
+	require.NoError(t, cache.Set(context.Background(), "2", request, response, 0))
+	time.Sleep(100 * time.Millisecond)
+
+	evicted, err := cache.EvictExpired(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, evicted)
+	require.Equal(t, 1, cache.ItemCount())
+}
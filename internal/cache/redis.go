@@ -4,7 +4,9 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
 
@@ -15,7 +17,13 @@ import (
 	"github.com/go-redis/redis/v8"
 )
 
-const hashMapName = "filecoin"
+const (
+	hashMapName = "filecoin"
+	// hashes with access statistics of the keys
+	createdHashMapName    = hashMapName + ":created"
+	lastAccessHashMapName = hashMapName + ":access"
+	hitsHashMapName       = hashMapName + ":hits"
+)
 
 // Client represents redis client
 type Client struct {
@@ -48,28 +56,105 @@ func NewRedisClient(ctx context.Context, config config.RedisCacheSettings) (*Cli
 	}, nil
 }
 
-func (client *Client) Get(key string) (requests.RPCResponse, error) {
+func (client *Client) Get(ctx context.Context, key string) (requests.RPCResponse, error) {
+	response, _, err := client.GetEncoded(ctx, key, "")
+	return response, err
+}
+
+// GetEncoded returns the response along with its result compressed by the encoding
+func (client *Client) GetEncoded(ctx context.Context, key string, encoding string) (requests.RPCResponse, []byte, error) {
 	val := cacheValue{}
-	data, err := client.Client.HGet(client.Context(), hashMapName, key).Bytes()
+	data, err := client.Client.HGet(ctx, hashMapName, key).Bytes()
 	if err != nil {
-		return val.Response, err
+		return val.Response, nil, err
 	}
 	if err := bson.Unmarshal(data, &val); err != nil {
-		return val.Response, err
+		return val.Response, nil, err
+	}
+	if val.expired() {
+		return requests.RPCResponse{}, nil, client.Delete(ctx, key)
+	}
+	pipe := client.Client.Pipeline()
+	pipe.HSet(ctx, lastAccessHashMapName, key, time.Now().UnixNano())
+	pipe.HIncrBy(ctx, hitsHashMapName, key, 1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return val.Response, nil, err
+	}
+	return val.Response, val.Encoded[encoding], nil
+}
+
+// Delete removes the key along with its access statistics
+func (client *Client) Delete(ctx context.Context, key string) error {
+	pipe := client.Client.Pipeline()
+	for _, name := range []string{hashMapName, createdHashMapName, lastAccessHashMapName, hitsHashMapName} {
+		pipe.HDel(ctx, name, key)
 	}
-	return val.Response, nil
+	_, err := pipe.Exec(ctx)
+	return err
 }
 
-func (client *Client) Set(key string, request requests.RPCRequest, response requests.RPCResponse) error {
-	item := cacheValue{
-		Request:  request,
-		Response: response,
+// EvictExpired removes expired entries along with their access statistics.
+// Statistics of keys missing in the cache are removed as well
+func (client *Client) EvictExpired(ctx context.Context) (int, error) {
+	data, err := client.Client.HGetAll(ctx, hashMapName).Result()
+	if err != nil {
+		return 0, err
+	}
+	var expired []string
+	expiredKeys := make(map[string]bool)
+	for key, value := range data {
+		item := cacheValue{}
+		if err := bson.Unmarshal([]byte(value), &item); err != nil {
+			return 0, err
+		}
+		if item.expired() {
+			expired = append(expired, key)
+			expiredKeys[key] = true
+		}
+	}
+	pipe := client.Client.Pipeline()
+	if len(expired) > 0 {
+		pipe.HDel(ctx, hashMapName, expired...)
 	}
+	for _, name := range []string{createdHashMapName, lastAccessHashMapName, hitsHashMapName} {
+		keys, err := client.Client.HKeys(ctx, name).Result()
+		if err != nil {
+			return 0, err
+		}
+		var stale []string
+		for _, key := range keys {
+			if _, ok := data[key]; !ok || expiredKeys[key] {
+				stale = append(stale, key)
+			}
+		}
+		if len(stale) > 0 {
+			pipe.HDel(ctx, name, stale...)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return len(expired), nil
+}
+
+func (client *Client) Set(ctx context.Context, key string, request requests.RPCRequest, response requests.RPCResponse, ttl time.Duration) error {
+	return client.SetEncoded(ctx, key, request, response, ttl, nil)
+}
+
+// SetEncoded stores the response along with its result compressed by encodings
+func (client *Client) SetEncoded(ctx context.Context, key string, request requests.RPCRequest, response requests.RPCResponse, ttl time.Duration, encoded map[string][]byte) error {
+	item := newCacheValue(request, response, ttl, encoded)
 	data, err := bson.Marshal(item)
 	if err != nil {
 		return err
 	}
-	return client.Client.HSet(client.Context(), hashMapName, key, data).Err()
+	now := time.Now().UnixNano()
+	pipe := client.Client.Pipeline()
+	pipe.HSet(ctx, hashMapName, key, data)
+	pipe.HSetNX(ctx, createdHashMapName, key, now)
+	pipe.HSetNX(ctx, lastAccessHashMapName, key, now)
+	_, err = pipe.Exec(ctx)
+	return err
 }
 
 func (client *Client) Requests() ([]requests.RPCRequest, error) {
@@ -77,13 +162,72 @@ func (client *Client) Requests() ([]requests.RPCRequest, error) {
 	if err != nil {
 		return nil, err
 	}
-	res := make([]requests.RPCRequest, len(data))
-	for idx, value := range data {
+	res := make([]requests.RPCRequest, 0, len(data))
+	for _, value := range data {
+		item := cacheValue{}
+		if err := bson.Unmarshal([]byte(value), &item); err != nil {
+			return nil, err
+		}
+		if item.expired() {
+			continue
+		}
+		res = append(res, item.Request)
+	}
+	return res, nil
+}
+
+// Entries returns cached requests with their access statistics
+func (client *Client) Entries() ([]Entry, error) {
+	data, err := client.Client.HGetAll(client.Context(), hashMapName).Result()
+	if err != nil {
+		return nil, err
+	}
+	created, err := client.int64Hash(createdHashMapName)
+	if err != nil {
+		return nil, err
+	}
+	lastAccess, err := client.int64Hash(lastAccessHashMapName)
+	if err != nil {
+		return nil, err
+	}
+	hits, err := client.int64Hash(hitsHashMapName)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Entry, 0, len(data))
+	for key, value := range data {
 		item := cacheValue{}
 		if err := bson.Unmarshal([]byte(value), &item); err != nil {
 			return nil, err
 		}
-		res[idx] = item.Request
+		if item.expired() {
+			continue
+		}
+		res = append(res, Entry{
+			Key:        key,
+			Request:    item.Request,
+			Response:   item.Response,
+			ExpiresAt:  item.expiresAt(),
+			Created:    time.Unix(0, created[key]),
+			LastAccess: time.Unix(0, lastAccess[key]),
+			Hits:       hits[key],
+		})
+	}
+	return res, nil
+}
+
+func (client *Client) int64Hash(name string) (map[string]int64, error) {
+	data, err := client.Client.HGetAll(client.Context(), name).Result()
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]int64, len(data))
+	for key, value := range data {
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value for key %s: %w", name, key, err)
+		}
+		res[key] = v
 	}
 	return res, nil
 }
@@ -96,6 +240,14 @@ func (client *Client) Close() error {
 	return nil
 }
 
+// Ping checks redis connection
+func (client *Client) Ping(ctx context.Context) error {
+	if err := client.Client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("cannot ping redis: %w", err)
+	}
+	return nil
+}
+
 // Clean cleans all cache
 func (client *Client) Clean() error {
 	if err := client.Client.FlushAll(client.Context()).Err(); err != nil {
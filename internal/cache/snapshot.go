@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+)
+
+// SnapshotRecord is a cache entry in a snapshot.
+// Keys are kept as is, so a snapshot should be loaded by the proxy with the same cache methods
+type SnapshotRecord struct {
+	Key      string               `json:"key"`
+	Request  requests.RPCRequest  `json:"request"`
+	Response requests.RPCResponse `json:"response"`
+	// ExpiresAt is unix time in seconds. Zero means the storage default expiration
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// Export writes cache entries to w as JSON lines. Returns the number of written entries
+func Export(c Cache, w io.Writer) (int, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return 0, fmt.Errorf("cannot get cache entries: %w", err)
+	}
+	encoder := json.NewEncoder(w)
+	for idx, entry := range entries {
+		record := SnapshotRecord{
+			Key:      entry.Key,
+			Request:  entry.Request,
+			Response: entry.Response,
+		}
+		if !entry.ExpiresAt.IsZero() {
+			record.ExpiresAt = entry.ExpiresAt.Unix()
+		}
+		if err := encoder.Encode(record); err != nil {
+			return idx, fmt.Errorf("cannot write cache entry %s: %w", entry.Key, err)
+		}
+	}
+	return len(entries), nil
+}
+
+// Import loads JSON lines written by Export into the cache. Expired entries are skipped.
+// Returns the number of loaded entries
+func Import(c Cache, r io.Reader) (int, error) {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	count := 0
+	for {
+		record := SnapshotRecord{}
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("cannot read cache entry: %w", err)
+		}
+		var ttl time.Duration
+		if record.ExpiresAt > 0 {
+			ttl = time.Until(time.Unix(record.ExpiresAt, 0))
+			if ttl <= 0 {
+				continue
+			}
+		}
+		if err := c.Set(context.Background(), record.Key, record.Request, record.Response, ttl); err != nil {
+			return count, fmt.Errorf("cannot store cache entry %s: %w", record.Key, err)
+		}
+		count++
+	}
+}
+
+// ExportFile writes cache entries to the file. Files with .gz extension are compressed
+func ExportFile(c Cache, name string) (count int, err error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	if !strings.HasSuffix(name, ".gz") {
+		return Export(c, file)
+	}
+	writer := gzip.NewWriter(file)
+	count, err = Export(c, writer)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	return count, err
+}
+
+// ImportFile loads cache entries from the file written by ExportFile
+func ImportFile(c Cache, name string) (int, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if !strings.HasSuffix(name, ".gz") {
+		return Import(c, file)
+	}
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read compressed snapshot: %w", err)
+	}
+	defer reader.Close()
+	return Import(c, reader)
+}
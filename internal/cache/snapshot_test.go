@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotExportImport(t *testing.T) {
+	cache := NewMemoryCacheDefault()
+	request := requests.RPCRequest{JSONRPC: "2.0", ID: float64(1), Method: "test", Params: []interface{}{"a"}}
+	response := requests.RPCResponse{JSONRPC: "2.0", ID: float64(1), Result: map[string]interface{}{"Height": float64(10)}}
+	require.NoError(t, cache.Set(context.Background(), "1", request, response, 0))
+	require.NoError(t, cache.Set(context.Background(), "2", request, response, time.Hour))
+
+	for _, name := range []string{"cache.jsonl", "cache.jsonl.gz"} {
+		file := filepath.Join(t.TempDir(), name)
+		count, err := ExportFile(cache, file)
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+
+		imported := NewMemoryCacheDefault()
+		count, err = ImportFile(imported, file)
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+		for _, key := range []string{"1", "2"} {
+			value, err := imported.Get(context.Background(), key)
+			require.NoError(t, err)
+			require.Equal(t, response, value)
+		}
+		entries, err := imported.Entries()
+		require.NoError(t, err)
+		for _, entry := range entries {
+			require.Equal(t, request, entry.Request)
+			require.Equal(t, entry.Key == "2", !entry.ExpiresAt.IsZero())
+		}
+	}
+}
+
+func TestSnapshotImportSkipsExpired(t *testing.T) {
+	cache := NewMemoryCacheDefault()
+	snapshot := `{"key":"1","request":{"jsonrpc":"2.0","method":"test"},"response":{"jsonrpc":"2.0","result":1},"expires_at":1}
+{"key":"2","request":{"jsonrpc":"2.0","method":"test"},"response":{"jsonrpc":"2.0","result":2}}
+`
+	count, err := Import(cache, strings.NewReader(snapshot))
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	value, err := cache.Get(context.Background(), "1")
+	require.NoError(t, err)
+	require.True(t, value.IsEmpty())
+
+	_, err = Import(cache, strings.NewReader("{"))
+	require.Error(t, err)
+}
@@ -0,0 +1,183 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// clientAuthTypes of the listener. Required client certificates are checked by the RPC routes
+// so that probes and metrics are served without them
+var clientAuthTypes = map[config.ClientAuthType]tls.ClientAuthType{
+	config.NoClientAuth:       tls.NoClientCert,
+	config.OptionalClientAuth: tls.VerifyClientCertIfGiven,
+	config.RequireClientAuth:  tls.VerifyClientCertIfGiven,
+}
+
+// Reloader keeps the server certificate and reloads it when the files have been changed
+type Reloader struct {
+	certFile string
+	keyFile  string
+	logger   *logrus.Entry
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+// NewReloader initializes the reloader and loads the certificate
+func NewReloader(certFile, keyFile string, logger *logrus.Entry) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logger,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// filesModTime returns the latest modification time of the certificate files
+func (r *Reloader) filesModTime() (time.Time, error) {
+	var modTime time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		stat, err := os.Stat(file)
+		if err != nil {
+			return modTime, err
+		}
+		if stat.ModTime().After(modTime) {
+			modTime = stat.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+// Reload loads the certificate from the files
+func (r *Reloader) Reload() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load tls certificate: %w", err)
+	}
+	r.lock.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.lock.Unlock()
+	return nil
+}
+
+func (r *Reloader) changed() bool {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		r.logger.Errorf("Cannot stat tls certificate files: %v", err)
+		return false
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return !modTime.Equal(r.modTime)
+}
+
+// GetCertificate implements tls.Config GetCertificate callback
+func (r *Reloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+// Watch reloads the certificate every period when it has been changed. Keeps the previous certificate on failure
+func (r *Reloader) Watch(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				r.logger.Errorf("Cannot reload tls certificate: %v", err)
+				continue
+			}
+			r.logger.Info("TLS certificate has been reloaded")
+		}
+	}
+}
+
+// ServerConfig builds server TLS config along with the certificate reloader
+func ServerConfig(settings config.TLSSettings, logger *logrus.Entry) (*tls.Config, *Reloader, error) {
+	reloader, err := NewReloader(settings.CertFile, settings.KeyFile, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tlsVersions[settings.MinVersion],
+		GetCertificate: reloader.GetCertificate,
+		ClientAuth:     clientAuthTypes[settings.ClientAuth],
+	}
+	if settings.ClientCAFile != "" {
+		pool, err := certPool(settings.ClientCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot load client ca file: %w", err)
+		}
+		tlsConfig.ClientCAs = pool
+	}
+	return tlsConfig, reloader, nil
+}
+
+// ClientConfig builds TLS config of upstream requests. Returns nil when neither CA nor client certificate is set
+func ClientConfig(settings config.UpstreamSettings) (*tls.Config, error) {
+	if settings.CAFile == "" && settings.CertFile == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if settings.CAFile != "" {
+		pool, err := certPool(settings.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load upstream ca file: %w", err)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if settings.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load upstream client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func certPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,63 @@
+package certs
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+)
+
+func TestReloaderWatch(t *testing.T) {
+	logger.InitDefaultLogger()
+	dir := t.TempDir()
+	_, err := testhelpers.GenerateCertificates(dir, "client")
+	require.NoError(t, err)
+
+	tlsConfig, reloader, err := ServerConfig(config.TLSSettings{
+		CertFile:     dir + "/cert.pem",
+		KeyFile:      dir + "/key.pem",
+		ClientCAFile: dir + "/ca.pem",
+		ClientAuth:   config.RequireClientAuth,
+		MinVersion:   "1.3",
+	}, logger.Log)
+	require.NoError(t, err)
+	require.NotNil(t, tlsConfig.ClientCAs)
+	cert1, err := tlsConfig.GetCertificate(nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx, 10*time.Millisecond)
+
+	_, err = testhelpers.GenerateCertificates(dir, "client")
+	require.NoError(t, err)
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(dir+"/cert.pem", modTime, modTime))
+
+	require.Eventually(t, func() bool {
+		cert2, err := tlsConfig.GetCertificate(nil)
+		return err == nil && cert2 != cert1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServerConfigInvalidFiles(t *testing.T) {
+	logger.InitDefaultLogger()
+	dir := t.TempDir()
+	_, _, err := ServerConfig(config.TLSSettings{CertFile: dir + "/cert.pem", KeyFile: dir + "/key.pem"}, logger.Log)
+	require.Error(t, err)
+
+	_, err = testhelpers.GenerateCertificates(dir, "client")
+	require.NoError(t, err)
+	_, _, err = ServerConfig(config.TLSSettings{
+		CertFile:     dir + "/cert.pem",
+		KeyFile:      dir + "/key.pem",
+		ClientCAFile: dir + "/key.pem",
+	}, logger.Log)
+	require.Error(t, err)
+}
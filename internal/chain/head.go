@@ -0,0 +1,171 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/upstream"
+)
+
+const chainHeadMethod = "Filecoin.ChainHead"
+
+// HeadTracker periodically polls upstream for the current chain head
+type HeadTracker struct {
+	url    string
+	token  auth.TokenSource
+	client *upstream.Client
+	logger *logrus.Entry
+	period time.Duration
+	lock   sync.RWMutex
+	height int64
+	// timestamp of the head tipset
+	timestamp time.Time
+	updated   time.Time
+	err       error
+}
+
+// NewHeadTracker initializes head tracker
+func NewHeadTracker(url string, token auth.TokenSource, logger *logrus.Entry, period time.Duration) *HeadTracker {
+	return &HeadTracker{
+		url:    url,
+		token:  token,
+		client: upstream.Default(),
+		logger: logger,
+		period: period,
+	}
+}
+
+// FromConfig initializes head tracker from config
+func FromConfig(c *config.Config, client *upstream.Client, logger *logrus.Entry) (*HeadTracker, error) {
+	token, err := auth.ProxyTokenFromConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	h := NewHeadTracker(c.ProxyURL, token, logger, time.Duration(c.ChainHeadPeriod)*time.Second)
+	h.client = client
+	return h, nil
+}
+
+// Height returns the last known chain head height. Zero means the head is unknown
+func (h *HeadTracker) Height() int64 {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.height
+}
+
+// Updated returns the time of the last successful head update
+func (h *HeadTracker) Updated() time.Time {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.updated
+}
+
+// Timestamp returns the timestamp of the last known chain head
+func (h *HeadTracker) Timestamp() time.Time {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.timestamp
+}
+
+// Err returns the error of the last head update
+func (h *HeadTracker) Err() error {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.err
+}
+
+func (h *HeadTracker) set(result interface{}, err error) error {
+	var height int64
+	if err == nil {
+		height, err = headHeight(result)
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.err = err
+	if err != nil {
+		return err
+	}
+	h.height = height
+	h.timestamp = headTimestamp(result)
+	h.updated = time.Now()
+	return nil
+}
+
+// Update requests chain head from upstream
+func (h *HeadTracker) Update(ctx context.Context) error {
+	return h.set(h.request(ctx))
+}
+
+func (h *HeadTracker) request(ctx context.Context) (interface{}, error) {
+	token, err := h.token.Token()
+	if err != nil {
+		return nil, err
+	}
+	responses, _, err := requests.RequestWithClient(ctx, h.client, h.url, token, h.logger, false, false, requests.RPCRequests{{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  chainHeadMethod,
+		Params:  []interface{}{},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	if len(responses) != 1 {
+		return nil, fmt.Errorf("unexpected number of chain head responses: %d", len(responses))
+	}
+	if responses[0].Error != nil {
+		return nil, responses[0].Error
+	}
+	return responses[0].Result, nil
+}
+
+func headHeight(result interface{}) (int64, error) {
+	head, ok := result.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("unexpected chain head result: %v", result)
+	}
+	height, ok := head["Height"].(float64)
+	if !ok {
+		return 0, fmt.Errorf("chain head has no height: %v", result)
+	}
+	return int64(height), nil
+}
+
+// headTimestamp returns the timestamp of the first head block. Zero time means it is unknown
+func headTimestamp(result interface{}) time.Time {
+	head, _ := result.(map[string]interface{})
+	blocks, _ := head["Blocks"].([]interface{})
+	if len(blocks) == 0 {
+		return time.Time{}
+	}
+	block, _ := blocks[0].(map[string]interface{})
+	timestamp, ok := block["Timestamp"].(float64)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(int64(timestamp), 0)
+}
+
+// Start polls chain head every period until the context is done
+func (h *HeadTracker) Start(ctx context.Context) {
+	defer h.logger.Info("Exiting chain head tracker...")
+	ticker := time.NewTicker(h.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.Update(ctx); err != nil {
+				h.logger.Errorf("Cannot update chain head: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encoding is a content coding of HTTP responses
+type Encoding string
+
+const (
+	Identity Encoding = "identity"
+	Gzip     Encoding = "gzip"
+	Brotli   Encoding = "br"
+	Zstd     Encoding = "zstd"
+)
+
+type encodingKey struct{}
+
+// Encodings converts encoding names from config
+func Encodings(names []string) []Encoding {
+	res := make([]Encoding, 0, len(names))
+	for _, name := range names {
+		res = append(res, Encoding(name))
+	}
+	return res
+}
+
+// WithEncoding returns the context with the encoding negotiated with the client
+func WithEncoding(ctx context.Context, encoding Encoding) context.Context {
+	return context.WithValue(ctx, encodingKey{}, encoding)
+}
+
+// EncodingFromContext returns the encoding negotiated with the client
+func EncodingFromContext(ctx context.Context) Encoding {
+	if encoding, ok := ctx.Value(encodingKey{}).(Encoding); ok {
+		return encoding
+	}
+	return Identity
+}
+
+// Negotiate picks the supported encoding with the highest quality in the Accept-Encoding header.
+// Supported encodings go in the order of preference
+func Negotiate(acceptEncoding string, supported []Encoding) Encoding {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+				quality = q
+			}
+		}
+		qualities[name] = quality
+	}
+	best, bestQuality := Identity, 0.0
+	for _, encoding := range supported {
+		quality, ok := qualities[string(encoding)]
+		if !ok {
+			quality = qualities["*"]
+		}
+		if quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+// NewWriter returns the writer compressing data to w
+func NewWriter(encoding Encoding, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Brotli:
+		return brotli.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	default:
+		return nil, fmt.Errorf("unsupported encoding: %s", encoding)
+	}
+}
+
+// NewReader returns the reader decompressing data from r
+func NewReader(encoding Encoding, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case Gzip:
+		return gzip.NewReader(r)
+	case Brotli:
+		return ioutil.NopCloser(brotli.NewReader(r)), nil
+	case Zstd:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding: %s", encoding)
+	}
+}
+
+// Encode compresses data
+func Encode(encoding Encoding, data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(encoding, buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decompresses data
+func Decode(encoding Encoding, data []byte) ([]byte, error) {
+	r, err := NewReader(encoding, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
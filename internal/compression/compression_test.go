@@ -0,0 +1,72 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	supported := []Encoding{Zstd, Brotli, Gzip}
+	require.Equal(t, Identity, Negotiate("", supported))
+	require.Equal(t, Identity, Negotiate("deflate", supported))
+	require.Equal(t, Gzip, Negotiate("gzip, deflate", supported))
+	require.Equal(t, Zstd, Negotiate("gzip, br, zstd", supported))
+	require.Equal(t, Brotli, Negotiate("gzip;q=0.5, br;q=0.8, zstd;q=0", supported))
+	require.Equal(t, Zstd, Negotiate("*", supported))
+	require.Equal(t, Brotli, Negotiate("*;q=0.1, br", supported))
+	require.Equal(t, Identity, Negotiate("gzip", nil))
+}
+
+func TestEncodeDecode(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"key":"value"}`), 100)
+	for _, encoding := range []Encoding{Gzip, Brotli, Zstd} {
+		encoded, err := Encode(encoding, data)
+		require.NoError(t, err, encoding)
+		require.Less(t, len(encoded), len(data), encoding)
+		decoded, err := Decode(encoding, encoded)
+		require.NoError(t, err, encoding)
+		require.Equal(t, data, decoded, encoding)
+	}
+	_, err := Encode(Identity, data)
+	require.Error(t, err)
+}
+
+func TestJoin(t *testing.T) {
+	result := bytes.Repeat([]byte(`{"deal":12345}`), 1000)
+	for _, encoding := range []Encoding{Gzip, Zstd} {
+		require.True(t, Partial(encoding))
+		part, err := EncodePart(encoding, result)
+		require.NoError(t, err, encoding)
+		joined, err := Join(encoding,
+			Chunk{Raw: []byte(`[{"id":1,"result":`)},
+			Chunk{Encoded: part},
+			Chunk{Raw: []byte(`},{"id":2,"result":`)},
+			Chunk{Encoded: part},
+			Chunk{Raw: []byte(`}]`)},
+		)
+		require.NoError(t, err, encoding)
+		decoded, err := Decode(encoding, joined)
+		require.NoError(t, err, encoding)
+		expected := []byte(`[{"id":1,"result":` + string(result) + `},{"id":2,"result":` + string(result) + `}]`)
+		require.Equal(t, expected, decoded, encoding)
+	}
+	require.False(t, Partial(Brotli))
+	_, err := EncodePart(Brotli, result)
+	require.Error(t, err)
+}
+
+func TestJoinGzipSingleMember(t *testing.T) {
+	joined, err := Join(Gzip, Chunk{Raw: []byte("hello ")}, Chunk{Raw: []byte("world")})
+	require.NoError(t, err)
+	r, err := gzip.NewReader(bytes.NewReader(joined))
+	require.NoError(t, err)
+	// clients not supporting multiple members read the whole stream
+	r.Multistream(false)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(data))
+}
@@ -0,0 +1,164 @@
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// gzip part trailer keeps CRC-32 and length of the uncompressed data
+const gzipPartTrailerSize = 12
+
+var (
+	gzipHeader = []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 0xff}
+	// final empty stored deflate block
+	deflateEnd = []byte{1, 0, 0, 0xff, 0xff}
+
+	zstdEncoder, _ = zstd.NewWriter(nil)
+)
+
+// Chunk is either raw data or data compressed with EncodePart
+type Chunk struct {
+	Raw     []byte
+	Encoded []byte
+}
+
+// Partial reports whether the encoding supports joining separately compressed parts
+func Partial(encoding Encoding) bool {
+	return encoding == Gzip || encoding == Zstd
+}
+
+// EncodePart compresses data to a part to be joined with other chunks by Join
+func EncodePart(encoding Encoding, data []byte) ([]byte, error) {
+	switch encoding {
+	case Gzip:
+		buf := &bytes.Buffer{}
+		w, err := flate.NewWriter(buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		// flush leaves the deflate stream open and byte aligned
+		if err := w.Flush(); err != nil {
+			return nil, err
+		}
+		trailer := make([]byte, gzipPartTrailerSize)
+		binary.LittleEndian.PutUint32(trailer, crc32.ChecksumIEEE(data))
+		binary.LittleEndian.PutUint64(trailer[4:], uint64(len(data)))
+		buf.Write(trailer)
+		return buf.Bytes(), nil
+	case Zstd:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	default:
+		return nil, fmt.Errorf("encoding %s does not support parts", encoding)
+	}
+}
+
+// Join compresses chunks into a single stream reusing encoded parts
+func Join(encoding Encoding, chunks ...Chunk) ([]byte, error) {
+	parts := make([][]byte, len(chunks))
+	for idx, chunk := range chunks {
+		if chunk.Encoded != nil {
+			parts[idx] = chunk.Encoded
+			continue
+		}
+		part, err := EncodePart(encoding, chunk.Raw)
+		if err != nil {
+			return nil, err
+		}
+		parts[idx] = part
+	}
+	switch encoding {
+	case Gzip:
+		return joinGzip(parts)
+	case Zstd:
+		// zstd stream is a sequence of frames
+		return bytes.Join(parts, nil), nil
+	default:
+		return nil, fmt.Errorf("encoding %s does not support parts", encoding)
+	}
+}
+
+// joinGzip builds a single gzip member from deflate parts combining their checksums
+func joinGzip(parts [][]byte) ([]byte, error) {
+	buf := bytes.NewBuffer(append([]byte{}, gzipHeader...))
+	var crc uint32
+	var size uint64
+	for _, part := range parts {
+		if len(part) < gzipPartTrailerSize {
+			return nil, fmt.Errorf("invalid gzip part")
+		}
+		trailer := part[len(part)-gzipPartTrailerSize:]
+		partSize := binary.LittleEndian.Uint64(trailer[4:])
+		crc = crc32Combine(crc, binary.LittleEndian.Uint32(trailer), partSize)
+		size += partSize
+		buf.Write(part[:len(part)-gzipPartTrailerSize])
+	}
+	buf.Write(deflateEnd)
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(trailer, crc)
+	binary.LittleEndian.PutUint32(trailer[4:], uint32(size))
+	buf.Write(trailer)
+	return buf.Bytes(), nil
+}
+
+func gf2MatrixTimes(mat []uint32, vec uint32) uint32 {
+	var sum uint32
+	for idx := 0; vec != 0; idx, vec = idx+1, vec>>1 {
+		if vec&1 != 0 {
+			sum ^= mat[idx]
+		}
+	}
+	return sum
+}
+
+func gf2MatrixSquare(square, mat []uint32) {
+	for idx := range square {
+		square[idx] = gf2MatrixTimes(mat, mat[idx])
+	}
+}
+
+// crc32Combine returns CRC-32 of two concatenated blocks given CRC-32 of each of them, as zlib crc32_combine does
+func crc32Combine(crc1, crc2 uint32, len2 uint64) uint32 {
+	if len2 == 0 {
+		return crc1
+	}
+	even := make([]uint32, 32)
+	odd := make([]uint32, 32)
+	// operator for one zero bit
+	odd[0] = crc32.IEEE
+	row := uint32(1)
+	for idx := 1; idx < 32; idx++ {
+		odd[idx] = row
+		row <<= 1
+	}
+	// operators for two and four zero bits
+	gf2MatrixSquare(even, odd)
+	gf2MatrixSquare(odd, even)
+	// apply len2 zero bytes to crc1
+	for {
+		gf2MatrixSquare(even, odd)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(even, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+		gf2MatrixSquare(odd, even)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(odd, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+	}
+	return crc1 ^ crc2
+}
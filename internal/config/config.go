@@ -1,36 +1,85 @@
 package config
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/expression"
+	"github.com/protofire/filecoin-rpc-proxy/internal/jsonpath"
+	"github.com/protofire/filecoin-rpc-proxy/internal/templates"
 
 	"gopkg.in/yaml.v2"
 )
 
 type MethodType string
 type CacheStorage string
+type MatchType string
+type LeaderElectionType string
+type ClientAuthType string
 
 const (
 	// in seconds
-	DefaultCacheCleanupInterval              = -1
-	DefaultCacheExpiration                   = 0
-	defaultLogLevel                          = "INFO"
-	defaultPort                              = 8080
-	defaultHost                              = "0.0.0.0"
-	defaultJWTAlgorithm                      = "HS256"
-	defaultSystemCachePeriod                 = 600
-	defaultUserCachePeriod                   = 3600
-	defaultRequestsBatchSize                 = 5
-	defaultRequestsConcurrency               = 10
-	defaultShutdownTimeout                   = 20
-	CustomMethod                MethodType   = "custom"
-	RegularMethod               MethodType   = "regular"
-	MemoryCacheStorage          CacheStorage = "memory"
-	RedisCacheStorage           CacheStorage = "redis"
-	RedisPoolSize               int          = 10
+	DefaultCacheCleanupInterval                      = -1
+	DefaultCacheExpiration                           = 0
+	defaultLogLevel                                  = "INFO"
+	defaultPort                                      = 8080
+	defaultHost                                      = "0.0.0.0"
+	defaultJWTAlgorithm                              = "HS256"
+	defaultSystemCachePeriod                         = 600
+	defaultUserCachePeriod                           = 3600
+	defaultRequestsBatchSize                         = 5
+	defaultRequestsConcurrency                       = 10
+	defaultShutdownTimeout                           = 20
+	defaultAPIKeyHeader                              = "X-API-Key"
+	defaultAPIKeyQueryParam                          = "token"
+	defaultAPIKeysReloadPeriod                       = 30
+	defaultChainHeadPeriod                           = 30
+	defaultLeaderKey                                 = "filecoin-rpc-proxy:leader"
+	defaultLeaderLease                               = 15
+	defaultUpdaterTimeout                            = 60
+	defaultUpdaterRetries                            = 3
+	defaultUpdaterRetryBackoff                       = 500
+	defaultUpdaterRetryMaxBackoff                    = 10000
+	defaultUpdaterMaxFailures                        = 5
+	defaultReadinessTimeout                          = 5
+	defaultUpstreamTokenTTL                          = 3600
+	defaultTLSMinVersion                             = "1.2"
+	defaultTLSReloadPeriod                           = 30
+	defaultCompressionMinSize                        = 1024
+	defaultUpstreamDialTimeout                       = 30
+	defaultUpstreamKeepAlive                         = 30
+	defaultUpstreamIdleTimeout                       = 90
+	defaultUpstreamMaxIdleConns                      = 100
+	defaultClientTimeoutHeader                       = "X-Request-Timeout"
+	defaultClientMaxTimeout                          = 60
+	defaultHedgingMinDelay                           = 10
+	defaultBatchConcurrency                          = 4
+	defaultMicroBatchMaxSize                         = 100
+	CustomMethod                  MethodType         = "custom"
+	RegularMethod                 MethodType         = "regular"
+	MemoryCacheStorage            CacheStorage       = "memory"
+	RedisCacheStorage             CacheStorage       = "redis"
+	NoLeaderElection              LeaderElectionType = "none"
+	RedisLeaderElection           LeaderElectionType = "redis"
+	FileLeaderElection            LeaderElectionType = "file"
+	ExactMatch                    MatchType          = "exact"
+	GlobMatch                     MatchType          = "glob"
+	RegexMatch                    MatchType          = "regex"
+	NoClientAuth                  ClientAuthType     = "none"
+	OptionalClientAuth            ClientAuthType     = "optional"
+	RequireClientAuth             ClientAuthType     = "require"
+	RedisPoolSize                 int                = 10
 )
 
 var (
@@ -71,6 +120,61 @@ func (c CacheStorage) Valid() error {
 	}
 }
 
+func (l LeaderElectionType) Valid() error {
+	switch l {
+	case NoLeaderElection, RedisLeaderElection, FileLeaderElection:
+		return nil
+	default:
+		return fmt.Errorf("unknown leader election type: %s", l)
+	}
+}
+
+func (a ClientAuthType) Valid() error {
+	switch a {
+	case NoClientAuth, OptionalClientAuth, RequireClientAuth:
+		return nil
+	default:
+		return fmt.Errorf("unknown client auth type: %s", a)
+	}
+}
+
+func (m MatchType) IsExact() bool {
+	return m == ExactMatch
+}
+
+func (m MatchType) Valid() error {
+	switch m {
+	case ExactMatch, GlobMatch, RegexMatch:
+		return nil
+	default:
+		return fmt.Errorf("unknown name match type: %s", m)
+	}
+}
+
+// Compile returns a function matching method names against the pattern
+func (m MatchType) Compile(pattern string) (func(string) bool, error) {
+	switch m {
+	case ExactMatch:
+		return func(name string) bool { return name == pattern }, nil
+	case GlobMatch:
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+		}
+		return func(name string) bool {
+			ok, _ := path.Match(pattern, name)
+			return ok
+		}, nil
+	case RegexMatch:
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern %q: %w", pattern, err)
+		}
+		return re.MatchString, nil
+	default:
+		return nil, m.Valid()
+	}
+}
+
 func (t *MethodType) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var buf string
 	if err := unmarshal(&buf); err != nil {
@@ -88,16 +192,56 @@ func (t MethodType) MarshalYAML() (interface{}, error) {
 	return string(t), nil
 }
 
+type CacheAdmission struct {
+	SkipNull         bool     `yaml:"skip_null,omitempty"`
+	SkipEmpty        bool     `yaml:"skip_empty,omitempty"`
+	RequirePaths     []string `yaml:"require_paths,omitempty"`
+	MinSize          int      `yaml:"min_size,omitempty"`
+	MaxSize          int      `yaml:"max_size,omitempty"`
+	RequireAgreement bool     `yaml:"require_agreement,omitempty"`
+}
+
 type CacheMethod struct {
-	Name                string      `yaml:"name"`
-	Enabled             bool        `yaml:"enabled,omitempty"`
-	CacheByParams       bool        `yaml:"cache_by_params,omitempty"`
-	NoStoreCache        bool        `yaml:"no_store_cache"`
-	NoUpdateCache       bool        `yaml:"no_update_cache"`
-	ParamsInCacheByID   []int       `yaml:"params_in_cache_by_id,omitempty"`
-	ParamsInCacheByName []string    `yaml:"params_in_cache_by_name,omitempty"`
-	Kind                *MethodType `yaml:"kind,omitempty"`
-	ParamsForRequest    interface{} `yaml:"params_for_request,omitempty"`
+	Name                string         `yaml:"name"`
+	Enabled             bool           `yaml:"enabled,omitempty"`
+	CacheByParams       bool           `yaml:"cache_by_params,omitempty"`
+	NoStoreCache        bool           `yaml:"no_store_cache"`
+	NoUpdateCache       bool           `yaml:"no_update_cache"`
+	ParamsInCacheByID   []int          `yaml:"params_in_cache_by_id,omitempty"`
+	ParamsInCacheByName []string       `yaml:"params_in_cache_by_name,omitempty"`
+	ParamsInCacheByPath []string       `yaml:"params_in_cache_by_path,omitempty"`
+	Kind                *MethodType    `yaml:"kind,omitempty"`
+	ParamsForRequest    interface{}    `yaml:"params_for_request,omitempty"`
+	Admission           CacheAdmission `yaml:"admission,omitempty"`
+	NameMatch           MatchType      `yaml:"name_match,omitempty"`
+	Priority            int            `yaml:"priority,omitempty"`
+	ReadIf              string         `yaml:"read_if,omitempty"`
+	WriteIf             string         `yaml:"write_if,omitempty"`
+	TTL                 int            `yaml:"ttl,omitempty"`
+	TTLExpr             string         `yaml:"ttl_expr,omitempty"`
+	RefreshPeriod       int            `yaml:"refresh_period,omitempty"`
+	RefreshCron         string         `yaml:"refresh_cron,omitempty"`
+	RefreshJitter       int            `yaml:"refresh_jitter,omitempty"`
+}
+
+// IsScheduled reports whether the method has its own refresh schedule
+func (c CacheMethod) IsScheduled() bool {
+	return c.RefreshPeriod > 0 || c.RefreshCron != ""
+}
+
+// Schedule returns the method refresh schedule
+func (c CacheMethod) Schedule() (cron.Schedule, error) {
+	if c.RefreshCron != "" {
+		schedule, err := cron.ParseStandard(c.RefreshCron)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse refresh_cron %q: %w", c.RefreshCron, err)
+		}
+		return schedule, nil
+	}
+	if c.RefreshPeriod > 0 {
+		return cron.Every(time.Duration(c.RefreshPeriod) * time.Second), nil
+	}
+	return nil, nil
 }
 
 func (c *CacheMethod) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -126,33 +270,447 @@ type CacheSettings struct {
 	Storage CacheStorage        `yaml:"storage,omitempty"`
 	Memory  MemoryCacheSettings `yaml:"memory,omitempty"`
 	Redis   RedisCacheSettings  `yaml:"redis,omitempty"`
+	// Snapshot is a cache export file loaded at startup when it exists.
+	// Memory cache is saved to the file on shutdown
+	Snapshot string `yaml:"snapshot,omitempty"`
+}
+
+type APIKey struct {
+	Name        string   `yaml:"name"`
+	Hash        string   `yaml:"hash"`
+	Permissions []string `yaml:"permissions,omitempty"`
+	Tier        string   `yaml:"tier,omitempty"`
+}
+
+type APIKeysSettings struct {
+	Header       string   `yaml:"header,omitempty"`
+	QueryParam   string   `yaml:"query_param,omitempty"`
+	File         string   `yaml:"file,omitempty"`
+	ReloadPeriod int      `yaml:"reload_period,omitempty"`
+	Keys         []APIKey `yaml:"keys,omitempty"`
+}
+
+// Enabled reports whether API keys are configured either inline or in a file
+func (a APIKeysSettings) Enabled() bool {
+	return len(a.Keys) > 0 || a.File != ""
+}
+
+// MethodPermission is the permission required to call methods matching the glob pattern
+type MethodPermission struct {
+	Name       string `yaml:"name"`
+	Permission string `yaml:"permission"`
+}
+
+type RateLimitTier struct {
+	Name              string  `yaml:"name"`
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+type MethodPolicy struct {
+	Name          string `yaml:"name"`
+	MaxParamsSize int    `yaml:"max_params_size,omitempty"`
+}
+
+type FirewallSettings struct {
+	Allow         []string       `yaml:"allow,omitempty"`
+	Deny          []string       `yaml:"deny,omitempty"`
+	MaxParamsSize int            `yaml:"max_params_size,omitempty"`
+	MaxBatchSize  int            `yaml:"max_batch_size,omitempty"`
+	Methods       []MethodPolicy `yaml:"methods,omitempty"`
+}
+
+func (f FirewallSettings) Validate() error {
+	patterns := append(append([]string{}, f.Allow...), f.Deny...)
+	for _, method := range f.Methods {
+		if method.Name == "" {
+			return fmt.Errorf("firewall method name is mandatory parameter")
+		}
+		patterns = append(patterns, method.Name)
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid firewall method pattern %q: %w", pattern, err)
+		}
+	}
+	if f.MaxParamsSize < 0 || f.MaxBatchSize < 0 {
+		return fmt.Errorf("firewall limits should not be negative")
+	}
+	return nil
+}
+
+type MethodSchema struct {
+	Name       string      `yaml:"name"`
+	Schema     interface{} `yaml:"schema,omitempty"`
+	SchemaFile string      `yaml:"schema_file,omitempty"`
+}
+
+type ParamsValidationSettings struct {
+	Methods []MethodSchema `yaml:"methods,omitempty"`
+}
+
+func (p ParamsValidationSettings) Validate() error {
+	names := map[string]bool{}
+	for _, method := range p.Methods {
+		if method.Name == "" {
+			return fmt.Errorf("params validation method name is mandatory parameter")
+		}
+		if names[method.Name] {
+			return fmt.Errorf("duplicated params validation method: %s", method.Name)
+		}
+		names[method.Name] = true
+		if (method.Schema == nil) == (method.SchemaFile == "") {
+			return fmt.Errorf("params validation method %s: one of schema and schema_file should be set", method.Name)
+		}
+	}
+	return nil
+}
+
+// CacheRefreshSettings limits refresh of cached user requests by their access statistics
+type CacheRefreshSettings struct {
+	// AccessWindow in seconds. Only entries accessed within the window are refreshed
+	AccessWindow int `yaml:"access_window,omitempty"`
+	// EvictAfter in seconds. Entries not accessed for the period are deleted
+	EvictAfter int `yaml:"evict_after,omitempty"`
+	// MaxEntries limits number of entries refreshed per cycle. The most requested entries go first
+	MaxEntries int `yaml:"max_entries,omitempty"`
+}
+
+// Validate checks cache refresh settings
+func (c CacheRefreshSettings) Validate() error {
+	if c.AccessWindow < 0 || c.EvictAfter < 0 || c.MaxEntries < 0 {
+		return fmt.Errorf("cache_refresh settings should not be negative")
+	}
+	if c.AccessWindow > 0 && c.EvictAfter > 0 && c.EvictAfter < c.AccessWindow {
+		return fmt.Errorf("cache_refresh evict_after should not be less than access_window")
+	}
+	return nil
+}
+
+// LeaderElectionSettings configures election of the replica running the updater
+type LeaderElectionSettings struct {
+	Type LeaderElectionType `yaml:"type,omitempty"`
+	// Key is a redis key holding the lock
+	Key string `yaml:"key,omitempty"`
+	// File is a lock file for single host setups
+	File string `yaml:"file,omitempty"`
+	// Lease in seconds. The lock is renewed every third of the lease
+	Lease int `yaml:"lease,omitempty"`
+}
+
+// UpdaterSettings configures refresh requests of the updater
+type UpdaterSettings struct {
+	// Timeout of a refresh request in seconds for methods without their own upstream timeout
+	Timeout int `yaml:"timeout,omitempty"`
+	// Retries of a failed batch. -1 disables retries
+	Retries int `yaml:"retries,omitempty"`
+	// RetryBackoff is the initial backoff in milliseconds. It doubles on every retry up to RetryMaxBackoff
+	RetryBackoff    int `yaml:"retry_backoff,omitempty"`
+	RetryMaxBackoff int `yaml:"retry_max_backoff,omitempty"`
+	// MaxFailures is a number of consecutive error responses after which the entry is removed from the cache.
+	// -1 disables removal
+	MaxFailures int `yaml:"max_failures,omitempty"`
+}
+
+// Validate checks updater settings
+func (u UpdaterSettings) Validate() error {
+	if u.Timeout < 0 || u.RetryBackoff < 0 || u.RetryMaxBackoff < 0 {
+		return fmt.Errorf("updater timeout and backoff should not be negative")
+	}
+	if u.Retries < -1 || u.MaxFailures < -1 {
+		return fmt.Errorf("updater retries and max_failures should not be less than -1")
+	}
+	if u.RetryBackoff > u.RetryMaxBackoff {
+		return fmt.Errorf("updater retry_backoff should not exceed retry_max_backoff")
+	}
+	return nil
+}
+
+// TLSSettings configures TLS termination on the proxy listener
+type TLSSettings struct {
+	// CertFile and KeyFile enable TLS. Files are reloaded when they have been changed
+	CertFile string `yaml:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
+	// ReloadPeriod is the certificate files check period in seconds
+	ReloadPeriod int `yaml:"reload_period,omitempty"`
+	// MinVersion is one of 1.0, 1.1, 1.2, 1.3
+	MinVersion string `yaml:"min_version,omitempty"`
+	// ClientCAFile verifies client certificates
+	ClientCAFile string         `yaml:"client_ca_file,omitempty"`
+	ClientAuth   ClientAuthType `yaml:"client_auth,omitempty"`
+	// ClientCertIdentity authenticates requests with verified client certificates without JWT.
+	// Common name of the certificate is the client name
+	ClientCertIdentity bool `yaml:"client_cert_identity,omitempty"`
+}
+
+// Enabled reports whether TLS is configured
+func (t TLSSettings) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// Validate checks TLS settings
+func (t TLSSettings) Validate() error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("tls cert_file and key_file should be set together")
+	}
+	if !t.Enabled() {
+		if t.ClientCAFile != "" {
+			return fmt.Errorf("tls client_ca_file requires cert_file and key_file")
+		}
+		return nil
+	}
+	switch t.MinVersion {
+	case "1.0", "1.1", "1.2", "1.3":
+	default:
+		return fmt.Errorf("unknown tls min_version: %s", t.MinVersion)
+	}
+	if err := t.ClientAuth.Valid(); err != nil {
+		return err
+	}
+	if t.ClientAuth != NoClientAuth && t.ClientCAFile == "" {
+		return fmt.Errorf("tls client_auth %s requires client_ca_file", t.ClientAuth)
+	}
+	if t.ClientCertIdentity && t.ClientAuth == NoClientAuth {
+		return fmt.Errorf("tls client_cert_identity requires client_auth")
+	}
+	if t.ReloadPeriod < 1 {
+		return fmt.Errorf("tls reload_period should be positive")
+	}
+	return nil
+}
+
+// TokensSettings configures client and upstream tokens
+type TokensSettings struct {
+	// RevocationStorage keeps revoked token IDs. redis uses the redis cache storage
+	RevocationStorage CacheStorage `yaml:"revocation_storage,omitempty"`
+	// Revoked token IDs (jti claim)
+	Revoked []string `yaml:"revoked,omitempty"`
+	// UpstreamTTL is the lifetime in seconds of tokens minted for upstream requests when upstream token is not set
+	UpstreamTTL int `yaml:"upstream_ttl,omitempty"`
+}
+
+// UpstreamMethod overrides the upstream request timeout for the method
+type UpstreamMethod struct {
+	Name string `yaml:"name"`
+	// Timeout in seconds. 0 disables the timeout
+	Timeout int `yaml:"timeout"`
+}
+
+// UpstreamSettings configures http client of requests to upstream nodes.
+// It is shared by the proxy transport, the updater and the chain head tracker
+type UpstreamSettings struct {
+	// DialTimeout, ResponseHeaderTimeout and Timeout are in seconds. 0 disables a timeout
+	DialTimeout           int `yaml:"dial_timeout,omitempty"`
+	ResponseHeaderTimeout int `yaml:"response_header_timeout,omitempty"`
+	// Timeout is the overall timeout of proxied requests
+	Timeout int              `yaml:"timeout,omitempty"`
+	Methods []UpstreamMethod `yaml:"methods,omitempty"`
+	// KeepAlive is the TCP keep-alive period in seconds. -1 disables TCP keep-alives
+	KeepAlive int `yaml:"keep_alive,omitempty"`
+	// DisableKeepAlives disables reuse of connections
+	DisableKeepAlives bool `yaml:"disable_keep_alives,omitempty"`
+	// IdleConnTimeout in seconds closes idle connections
+	IdleConnTimeout     int `yaml:"idle_conn_timeout,omitempty"`
+	MaxIdleConns        int `yaml:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost int `yaml:"max_idle_conns_per_host,omitempty"`
+	// MaxConnsPerHost limits connections to the upstream node. 0 means no limit
+	MaxConnsPerHost int `yaml:"max_conns_per_host,omitempty"`
+	// CAFile verifies upstream certificates instead of the system roots
+	CAFile string `yaml:"ca_file,omitempty"`
+	// CertFile and KeyFile is the client certificate
+	CertFile     string `yaml:"cert_file,omitempty"`
+	KeyFile      string `yaml:"key_file,omitempty"`
+	DisableHTTP2 bool   `yaml:"disable_http2,omitempty"`
+	// MaxBatchSize splits uncached entries of larger client batches into sub-batches. 0 disables splitting
+	MaxBatchSize int `yaml:"max_batch_size,omitempty"`
+	// BatchConcurrency is the number of sub-batches of a client batch sent concurrently
+	BatchConcurrency int `yaml:"batch_concurrency,omitempty"`
+}
+
+// Validate checks upstream settings
+func (u UpstreamSettings) Validate() error {
+	if u.DialTimeout < 0 || u.ResponseHeaderTimeout < 0 || u.Timeout < 0 || u.IdleConnTimeout < 0 {
+		return fmt.Errorf("upstream timeouts should not be negative")
+	}
+	if u.KeepAlive < -1 {
+		return fmt.Errorf("upstream keep_alive should not be less than -1")
+	}
+	if u.MaxIdleConns < 0 || u.MaxIdleConnsPerHost < 0 || u.MaxConnsPerHost < 0 {
+		return fmt.Errorf("upstream connection limits should not be negative")
+	}
+	if u.MaxBatchSize < 0 || u.BatchConcurrency < 1 {
+		return fmt.Errorf("upstream max_batch_size should not be negative and batch_concurrency should be positive")
+	}
+	if (u.CertFile == "") != (u.KeyFile == "") {
+		return fmt.Errorf("upstream cert_file and key_file should be set together")
+	}
+	for _, method := range u.Methods {
+		if method.Name == "" {
+			return fmt.Errorf("upstream method name is empty")
+		}
+		if method.Timeout < 0 {
+			return fmt.Errorf("upstream method %s timeout should not be negative", method.Name)
+		}
+	}
+	return nil
+}
+
+// CompressionSettings configures compression of responses negotiated with Accept-Encoding
+type CompressionSettings struct {
+	// Encodings in the order of preference: gzip, br, zstd. Empty list disables compression
+	Encodings []string `yaml:"encodings,omitempty"`
+	// MinSize is the min response size in bytes to compress
+	MinSize int `yaml:"min_size,omitempty"`
+	// CacheCompressed stores compressed results along with cached responses to serve them without recompressing.
+	// Supported for gzip and zstd
+	CacheCompressed bool `yaml:"cache_compressed,omitempty"`
+}
+
+// Enabled reports whether compression is configured
+func (c CompressionSettings) Enabled() bool {
+	return len(c.Encodings) > 0
+}
+
+// Validate checks compression settings
+func (c CompressionSettings) Validate() error {
+	for _, encoding := range c.Encodings {
+		switch encoding {
+		case "gzip", "br", "zstd":
+		default:
+			return fmt.Errorf("unknown compression encoding: %s", encoding)
+		}
+	}
+	if c.MinSize < 0 {
+		return fmt.Errorf("compression min_size should not be negative")
+	}
+	return nil
+}
+
+// ClientTimeoutSettings configures the timeout requested by clients with the header
+type ClientTimeoutSettings struct {
+	// Header carries the timeout in seconds or as a duration, e.g. 1.5 or 1500ms
+	Header string `yaml:"header,omitempty"`
+	// Max caps the requested timeout in seconds
+	Max int `yaml:"max,omitempty"`
+	// Default timeout in seconds of requests without the header. 0 means no timeout
+	Default int `yaml:"default,omitempty"`
+}
+
+// Validate checks client timeout settings
+func (c ClientTimeoutSettings) Validate() error {
+	if c.Max < 0 || c.Default < 0 {
+		return fmt.Errorf("client timeout max and default should not be negative")
+	}
+	if c.Default > c.Max {
+		return fmt.Errorf("client timeout default should not exceed max")
+	}
+	return nil
+}
+
+// HedgingSettings configures hedged duplicates of requests of cached methods sent to proxy_mirror_urls nodes
+type HedgingSettings struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Delay in milliseconds before the hedged request is sent. 0 means the observed p95 latency of upstream requests
+	Delay int `yaml:"delay,omitempty"`
+	// MinDelay in milliseconds limits the observed p95 delay
+	MinDelay int `yaml:"min_delay,omitempty"`
+}
+
+// Validate checks hedging settings
+func (h HedgingSettings) Validate() error {
+	if h.Delay < 0 || h.MinDelay < 0 {
+		return fmt.Errorf("hedging delay and min_delay should not be negative")
+	}
+	return nil
+}
+
+// MicroBatchingSettings configures collecting single client requests into upstream batches
+type MicroBatchingSettings struct {
+	// Window in milliseconds single requests are collected within. 0 disables micro-batching
+	Window int `yaml:"window,omitempty"`
+	// MaxSize of the upstream batch. The batch is sent once it is full.
+	// Defaults to upstream max_batch_size when it is less than the default
+	MaxSize int `yaml:"max_size,omitempty"`
+}
+
+// Enabled reports whether micro-batching is configured
+func (m MicroBatchingSettings) Enabled() bool {
+	return m.Window > 0
+}
+
+// Validate checks micro-batching settings
+func (m MicroBatchingSettings) Validate() error {
+	if m.Window < 0 || m.MaxSize < 1 {
+		return fmt.Errorf("micro_batching window should not be negative and max_size should be positive")
+	}
+	return nil
+}
+
+// ReadinessSettings configures checks of the readiness endpoint
+type ReadinessSettings struct {
+	// Timeout of the cache backend check in seconds
+	Timeout int `yaml:"timeout,omitempty"`
+	// MaxHeadAge is the max age of the upstream chain head in seconds. 0 disables the check
+	MaxHeadAge int `yaml:"max_head_age,omitempty"`
+	// RequireWarm reports not ready until the updater has cached custom methods
+	RequireWarm bool `yaml:"require_warm,omitempty"`
+}
+
+// Validate checks readiness settings
+func (r ReadinessSettings) Validate() error {
+	if r.Timeout < 0 || r.MaxHeadAge < 0 {
+		return fmt.Errorf("readiness timeout and max_head_age should not be negative")
+	}
+	return nil
 }
 
 type Config struct {
-	CacheMethods            []CacheMethod `yaml:"cache_methods,omitempty"`
-	JWTAlgorithm            string        `yaml:"jwt_alg"`
-	JWTSecret               string        `yaml:"jwt_secret"`
-	JWTSecretBase64         string        `yaml:"jwt_secret_base64"`
-	JWTPermissions          []string      `json:"jwt_permissions"`
-	Host                    string        `yaml:"host"`
-	Port                    int           `yaml:"port"`
-	UpdateCustomCachePeriod int           `yaml:"update_custom_cache_period"`
-	UpdateUserCachePeriod   int           `yaml:"update_user_cache_period"`
-	RequestsBatchSize       int           `yaml:"requests_batch_size"`
-	RequestsConcurrency     int           `yaml:"requests_concurrency"`
-	ShutdownTimeout         int           `yaml:"shutdown_timeout"`
-	ProxyURL                string        `yaml:"proxy_url"`
-	CacheSettings           CacheSettings `yaml:"cache_settings,omitempty"`
-	LogLevel                string        `yaml:"log_level"`
-	LogPrettyPrint          bool          `yaml:"log_pretty_print"`
-	DebugHTTPRequest        bool          `yaml:"debug_http_request,omitempty"`
-	DebugHTTPResponse       bool          `yaml:"debug_http_response,omitempty"`
+	CacheMethods            []CacheMethod            `yaml:"cache_methods,omitempty"`
+	JWTAlgorithm            string                   `yaml:"jwt_alg"`
+	JWTSecret               string                   `yaml:"jwt_secret"`
+	JWTSecretBase64         string                   `yaml:"jwt_secret_base64"`
+	JWTPermissions          []string                 `json:"jwt_permissions"`
+	Host                    string                   `yaml:"host"`
+	Port                    int                      `yaml:"port"`
+	UpdateCustomCachePeriod int                      `yaml:"update_custom_cache_period"`
+	UpdateUserCachePeriod   int                      `yaml:"update_user_cache_period"`
+	RequestsBatchSize       int                      `yaml:"requests_batch_size"`
+	RequestsConcurrency     int                      `yaml:"requests_concurrency"`
+	ShutdownTimeout         int                      `yaml:"shutdown_timeout"`
+	ProxyURL                string                   `yaml:"proxy_url"`
+	ProxyMirrorURLs         []string                 `yaml:"proxy_mirror_urls,omitempty"`
+	UpstreamToken           string                   `yaml:"upstream_token,omitempty"`
+	UpstreamTokenFile       string                   `yaml:"upstream_token_file,omitempty"`
+	CacheSettings           CacheSettings            `yaml:"cache_settings,omitempty"`
+	LogLevel                string                   `yaml:"log_level"`
+	LogPrettyPrint          bool                     `yaml:"log_pretty_print"`
+	DebugHTTPRequest        bool                     `yaml:"debug_http_request,omitempty"`
+	DebugHTTPResponse       bool                     `yaml:"debug_http_response,omitempty"`
+	APIKeys                 APIKeysSettings          `yaml:"api_keys,omitempty"`
+	RateLimitTiers          []RateLimitTier          `yaml:"rate_limit_tiers,omitempty"`
+	DefaultRateLimitTier    string                   `yaml:"default_rate_limit_tier,omitempty"`
+	MethodPermissions       []MethodPermission       `yaml:"method_permissions,omitempty"`
+	Firewall                FirewallSettings         `yaml:"firewall,omitempty"`
+	ParamsValidation        ParamsValidationSettings `yaml:"params_validation,omitempty"`
+	ChainHeadPeriod         int                      `yaml:"chain_head_period,omitempty"`
+	CacheRefresh            CacheRefreshSettings     `yaml:"cache_refresh,omitempty"`
+	LeaderElection          LeaderElectionSettings   `yaml:"leader_election,omitempty"`
+	Updater                 UpdaterSettings          `yaml:"updater,omitempty"`
+	Readiness               ReadinessSettings        `yaml:"readiness,omitempty"`
+	Tokens                  TokensSettings           `yaml:"tokens,omitempty"`
+	TLS                     TLSSettings              `yaml:"tls,omitempty"`
+	Compression             CompressionSettings      `yaml:"compression,omitempty"`
+	Upstream                UpstreamSettings         `yaml:"upstream,omitempty"`
+	ClientTimeout           ClientTimeoutSettings    `yaml:"client_timeout,omitempty"`
+	Hedging                 HedgingSettings          `yaml:"hedging,omitempty"`
+	MicroBatching           MicroBatchingSettings    `yaml:"micro_batching,omitempty"`
 }
 
 type CmdLineParams struct {
-	JWTSecret string
-	ProxyURL  string
-	RedisURI  string
+	JWTSecret     string
+	ProxyURL      string
+	RedisURI      string
+	UpstreamToken string
 }
 
 func (c *Config) SetParams(params CmdLineParams) {
@@ -165,6 +723,9 @@ func (c *Config) SetParams(params CmdLineParams) {
 	if params.RedisURI != "" {
 		c.CacheSettings.Redis.URI = params.RedisURI
 	}
+	if params.UpstreamToken != "" {
+		c.UpstreamToken = params.UpstreamToken
+	}
 }
 
 func (c *Config) JWT() []byte {
@@ -230,8 +791,109 @@ func (c *Config) Init() {
 	if c.CacheSettings.Memory.DefaultExpiration == 0 {
 		c.CacheSettings.Memory.DefaultExpiration = DefaultCacheExpiration
 	}
+	if c.APIKeys.Header == "" {
+		c.APIKeys.Header = defaultAPIKeyHeader
+	}
+	if c.APIKeys.QueryParam == "" {
+		c.APIKeys.QueryParam = defaultAPIKeyQueryParam
+	}
+	if c.Updater.Timeout == 0 {
+		c.Updater.Timeout = defaultUpdaterTimeout
+	}
+	if c.Updater.Retries == 0 {
+		c.Updater.Retries = defaultUpdaterRetries
+	}
+	if c.Updater.RetryBackoff == 0 {
+		c.Updater.RetryBackoff = defaultUpdaterRetryBackoff
+	}
+	if c.Updater.RetryMaxBackoff == 0 {
+		c.Updater.RetryMaxBackoff = defaultUpdaterRetryMaxBackoff
+	}
+	if c.Updater.MaxFailures == 0 {
+		c.Updater.MaxFailures = defaultUpdaterMaxFailures
+	}
+	if c.TLS.MinVersion == "" {
+		c.TLS.MinVersion = defaultTLSMinVersion
+	}
+	if c.TLS.ClientAuth == "" {
+		c.TLS.ClientAuth = NoClientAuth
+		if c.TLS.ClientCAFile != "" {
+			c.TLS.ClientAuth = RequireClientAuth
+		}
+	}
+	if c.TLS.ReloadPeriod == 0 {
+		c.TLS.ReloadPeriod = defaultTLSReloadPeriod
+	}
+	if c.Tokens.RevocationStorage == "" {
+		c.Tokens.RevocationStorage = MemoryCacheStorage
+	}
+	if c.Tokens.UpstreamTTL == 0 {
+		c.Tokens.UpstreamTTL = defaultUpstreamTokenTTL
+	}
+	if c.Readiness.Timeout == 0 {
+		c.Readiness.Timeout = defaultReadinessTimeout
+	}
+	if c.Compression.MinSize == 0 {
+		c.Compression.MinSize = defaultCompressionMinSize
+	}
+	if c.Upstream.DialTimeout == 0 {
+		c.Upstream.DialTimeout = defaultUpstreamDialTimeout
+	}
+	if c.Upstream.KeepAlive == 0 {
+		c.Upstream.KeepAlive = defaultUpstreamKeepAlive
+	}
+	if c.Upstream.IdleConnTimeout == 0 {
+		c.Upstream.IdleConnTimeout = defaultUpstreamIdleTimeout
+	}
+	if c.Upstream.MaxIdleConns == 0 {
+		c.Upstream.MaxIdleConns = defaultUpstreamMaxIdleConns
+	}
+	if c.Upstream.MaxIdleConnsPerHost == 0 {
+		c.Upstream.MaxIdleConnsPerHost = defaultUpstreamMaxIdleConns
+	}
+	if c.Upstream.BatchConcurrency == 0 {
+		c.Upstream.BatchConcurrency = defaultBatchConcurrency
+	}
+	if c.ClientTimeout.Header == "" {
+		c.ClientTimeout.Header = defaultClientTimeoutHeader
+	}
+	if c.ClientTimeout.Max == 0 {
+		c.ClientTimeout.Max = defaultClientMaxTimeout
+	}
+	if c.Hedging.MinDelay == 0 {
+		c.Hedging.MinDelay = defaultHedgingMinDelay
+	}
+	if c.MicroBatching.MaxSize == 0 {
+		c.MicroBatching.MaxSize = defaultMicroBatchMaxSize
+		if c.Upstream.MaxBatchSize > 0 && c.Upstream.MaxBatchSize < c.MicroBatching.MaxSize {
+			c.MicroBatching.MaxSize = c.Upstream.MaxBatchSize
+		}
+	}
+	if c.LeaderElection.Type == "" {
+		c.LeaderElection.Type = NoLeaderElection
+	}
+	if c.LeaderElection.Key == "" {
+		c.LeaderElection.Key = defaultLeaderKey
+	}
+	if c.LeaderElection.Lease == 0 {
+		c.LeaderElection.Lease = defaultLeaderLease
+	}
+	if c.ChainHeadPeriod == 0 {
+		c.ChainHeadPeriod = defaultChainHeadPeriod
+	}
+	if c.APIKeys.ReloadPeriod == 0 {
+		c.APIKeys.ReloadPeriod = defaultAPIKeysReloadPeriod
+	}
+	InitAPIKeys(c.APIKeys.Keys, c.JWTPermissions)
 	for idx := range c.CacheMethods {
 		method := c.CacheMethods[idx]
+		if method.NameMatch == "" {
+			method.NameMatch = ExactMatch
+			if strings.ContainsAny(method.Name, "*?[") {
+				method.NameMatch = GlobMatch
+			}
+			c.CacheMethods[idx] = method
+		}
 		if method.Kind == nil {
 			if method.ParamsForRequest == nil {
 				mt := RegularMethod
@@ -245,6 +907,56 @@ func (c *Config) Init() {
 	}
 }
 
+// validateParamsTemplates checks templates of custom methods params and their dependencies
+func (c *Config) validateParamsTemplates() error {
+	deps := make(map[string][]string)
+	for _, method := range c.CacheMethods {
+		if !method.Kind.IsCustom() || !method.Enabled {
+			continue
+		}
+		deps[method.Name] = nil
+		if !templates.IsTemplate(method.ParamsForRequest) {
+			continue
+		}
+		params, err := templates.Compile(method.ParamsForRequest)
+		if err != nil {
+			return fmt.Errorf("method %s: %w", method.Name, err)
+		}
+		deps[method.Name] = append(deps[method.Name], params.Dependencies()...)
+	}
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("method %s: cyclic params template dependency", name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, dep := range deps[name] {
+			if _, ok := deps[dep]; !ok {
+				return fmt.Errorf("method %s: params template depends on unknown custom method %s", name, dep)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for name := range deps {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *Config) Validate() error {
 	for _, method := range c.CacheMethods {
 		if err := method.Kind.Valid(); err != nil {
@@ -256,6 +968,116 @@ func (c *Config) Validate() error {
 		if method.Kind.IsRegular() && method.ParamsForRequest != nil {
 			return fmt.Errorf("regular method type should not have been set with params_for_request")
 		}
+		if _, err := method.NameMatch.Compile(method.Name); err != nil {
+			return fmt.Errorf("method %s: %w", method.Name, err)
+		}
+		if method.Kind.IsCustom() && !method.NameMatch.IsExact() {
+			return fmt.Errorf("method %s: custom method name should be exact", method.Name)
+		}
+		for _, idx := range method.ParamsInCacheByID {
+			if idx < 0 {
+				return fmt.Errorf("method %s: params_in_cache_by_id index %d should not be negative", method.Name, idx)
+			}
+		}
+		for _, p := range method.ParamsInCacheByPath {
+			if _, err := jsonpath.Parse(p); err != nil {
+				return fmt.Errorf("method %s: %w", method.Name, err)
+			}
+		}
+		for _, p := range method.Admission.RequirePaths {
+			if _, err := jsonpath.Parse(p); err != nil {
+				return fmt.Errorf("method %s: %w", method.Name, err)
+			}
+		}
+		if method.Admission.MaxSize > 0 && method.Admission.MinSize > method.Admission.MaxSize {
+			return fmt.Errorf("method %s: admission min_size should not exceed max_size", method.Name)
+		}
+		if method.Admission.RequireAgreement && len(c.ProxyMirrorURLs) == 0 {
+			return fmt.Errorf("method %s: admission require_agreement needs proxy_mirror_urls", method.Name)
+		}
+		for _, cond := range []string{method.ReadIf, method.WriteIf} {
+			if _, err := expression.CompileBool(cond); err != nil {
+				return fmt.Errorf("method %s: %w", method.Name, err)
+			}
+		}
+		if _, err := expression.CompileNumber(method.TTLExpr); err != nil {
+			return fmt.Errorf("method %s: %w", method.Name, err)
+		}
+		if method.RefreshPeriod < 0 || method.RefreshJitter < 0 {
+			return fmt.Errorf("method %s: refresh_period and refresh_jitter should not be negative", method.Name)
+		}
+		if method.RefreshPeriod > 0 && method.RefreshCron != "" {
+			return fmt.Errorf("method %s: only one of refresh_period and refresh_cron should be set", method.Name)
+		}
+		if _, err := method.Schedule(); err != nil {
+			return fmt.Errorf("method %s: %w", method.Name, err)
+		}
+		if method.IsScheduled() && method.Kind.IsRegular() && method.NoUpdateCache {
+			return fmt.Errorf("method %s: refresh schedule conflicts with no_update_cache", method.Name)
+		}
+		if method.TTL < 0 {
+			return fmt.Errorf("method %s: ttl should not be negative", method.Name)
+		}
+		if method.TTL > 0 && method.TTLExpr != "" {
+			return fmt.Errorf("method %s: only one of ttl and ttl_expr should be set", method.Name)
+		}
+	}
+	if err := c.validateParamsTemplates(); err != nil {
+		return err
+	}
+	if err := c.CacheRefresh.Validate(); err != nil {
+		return err
+	}
+	if err := c.Updater.Validate(); err != nil {
+		return err
+	}
+	if err := c.Readiness.Validate(); err != nil {
+		return err
+	}
+	if err := c.TLS.Validate(); err != nil {
+		return err
+	}
+	if err := c.Compression.Validate(); err != nil {
+		return err
+	}
+	if err := c.Upstream.Validate(); err != nil {
+		return err
+	}
+	if err := c.ClientTimeout.Validate(); err != nil {
+		return err
+	}
+	if err := c.Hedging.Validate(); err != nil {
+		return err
+	}
+	if c.Hedging.Enabled && len(c.ProxyMirrorURLs) == 0 {
+		return fmt.Errorf("hedging needs proxy_mirror_urls")
+	}
+	if err := c.MicroBatching.Validate(); err != nil {
+		return err
+	}
+	if c.Upstream.MaxBatchSize > 0 && c.MicroBatching.MaxSize > c.Upstream.MaxBatchSize {
+		return fmt.Errorf("micro_batching max_size should not exceed upstream max_batch_size")
+	}
+	if err := c.Tokens.RevocationStorage.Valid(); err != nil {
+		return fmt.Errorf("tokens.revocation_storage: %w", err)
+	}
+	if c.Tokens.RevocationStorage.IsRedis() && !c.CacheSettings.Storage.IsRedis() {
+		return fmt.Errorf("redis token revocation storage requires redis cache storage")
+	}
+	if c.Tokens.UpstreamTTL < 0 {
+		return fmt.Errorf("tokens.upstream_ttl should not be negative")
+	}
+	if err := c.LeaderElection.Type.Valid(); err != nil {
+		return err
+	}
+	if c.LeaderElection.Type == RedisLeaderElection && c.CacheSettings.Redis.URI == "" {
+		return fmt.Errorf("redis leader election requires cache_settings.redis.uri")
+	}
+	if c.LeaderElection.Type == FileLeaderElection && c.LeaderElection.File == "" {
+		return fmt.Errorf("file leader election requires leader_election.file")
+	}
+	if c.LeaderElection.Lease < 1 {
+		return fmt.Errorf("leader_election.lease should be positive")
 	}
 	if c.ProxyURL == "" {
 		return fmt.Errorf("proxy_url is mandatory parameter")
@@ -263,6 +1085,11 @@ func (c *Config) Validate() error {
 	if _, err := url.Parse(c.ProxyURL); err != nil {
 		return fmt.Errorf("cannot parse proxy_url: %w", err)
 	}
+	for _, mirror := range c.ProxyMirrorURLs {
+		if _, err := url.Parse(mirror); err != nil {
+			return fmt.Errorf("cannot parse proxy_mirror_urls: %w", err)
+		}
+	}
 	if err := c.CacheSettings.Storage.Valid(); err != nil {
 		return err
 	}
@@ -277,9 +1104,124 @@ func (c *Config) Validate() error {
 	if c.JWTSecret == "" && c.JWTSecretBase64 == "" {
 		return fmt.Errorf("jwt secret is mandatory parameter")
 	}
+	if err := c.Firewall.Validate(); err != nil {
+		return err
+	}
+	if err := c.ParamsValidation.Validate(); err != nil {
+		return err
+	}
+	if c.UpstreamToken != "" && c.UpstreamTokenFile != "" {
+		return fmt.Errorf("only one of upstream_token and upstream_token_file should be set")
+	}
+	tiers := map[string]bool{}
+	for _, tier := range c.RateLimitTiers {
+		if tier.Name == "" {
+			return fmt.Errorf("rate limit tier name is mandatory parameter")
+		}
+		if tier.RequestsPerSecond <= 0 {
+			return fmt.Errorf("rate limit tier %s: requests_per_second should be positive", tier.Name)
+		}
+		if tiers[tier.Name] {
+			return fmt.Errorf("duplicated rate limit tier: %s", tier.Name)
+		}
+		tiers[tier.Name] = true
+	}
+	if c.DefaultRateLimitTier != "" && !tiers[c.DefaultRateLimitTier] {
+		return fmt.Errorf("unknown default_rate_limit_tier: %s", c.DefaultRateLimitTier)
+	}
+	for _, method := range c.MethodPermissions {
+		if _, err := path.Match(method.Name, ""); err != nil || method.Name == "" {
+			return fmt.Errorf("invalid method_permissions pattern %q", method.Name)
+		}
+		if !isPermission(method.Permission) {
+			return fmt.Errorf("method_permissions %s: unknown permission %q", method.Name, method.Permission)
+		}
+	}
+	if err := ValidateAPIKeys(c.APIKeys.Keys, c.RateLimitTiers); err != nil {
+		return err
+	}
+	if c.APIKeys.File != "" {
+		keys, err := APIKeysFromFile(c.APIKeys.File, c.JWTPermissions)
+		if err != nil {
+			return fmt.Errorf("cannot load api keys file: %w", err)
+		}
+		if err := ValidateAPIKeys(keys, c.RateLimitTiers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Permissions are lotus API permissions
+var Permissions = []string{"read", "write", "sign", "admin"}
+
+func isPermission(permission string) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// InitAPIKeys sets default permissions for the keys without them
+func InitAPIKeys(keys []APIKey, permissions []string) {
+	for idx := range keys {
+		if len(keys[idx].Permissions) == 0 {
+			keys[idx].Permissions = permissions
+		}
+		keys[idx].Hash = strings.ToLower(keys[idx].Hash)
+	}
+}
+
+// ValidateAPIKeys checks hashes and tiers of the keys
+func ValidateAPIKeys(keys []APIKey, tiers []RateLimitTier) error {
+	names := map[string]bool{}
+	for _, key := range keys {
+		if key.Name == "" {
+			return fmt.Errorf("api key name is mandatory parameter")
+		}
+		if names[key.Name] {
+			return fmt.Errorf("duplicated api key name: %s", key.Name)
+		}
+		names[key.Name] = true
+		if hash, err := hex.DecodeString(key.Hash); err != nil || len(hash) != sha256.Size {
+			return fmt.Errorf("api key %s: hash should be a hex encoded sha256 digest", key.Name)
+		}
+		if key.Tier == "" {
+			continue
+		}
+		found := false
+		for _, tier := range tiers {
+			if tier.Name == key.Tier {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("api key %s: unknown rate limit tier %s", key.Name, key.Tier)
+		}
+	}
 	return nil
 }
 
+// APIKeysFromFile reads api keys from the yaml file with the `keys` root element
+func APIKeysFromFile(filename string, permissions []string) ([]APIKey, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	keysFile := struct {
+		Keys []APIKey `yaml:"keys"`
+	}{}
+	if err := yaml.NewDecoder(file).Decode(&keysFile); err != nil && err != io.EOF {
+		return nil, err
+	}
+	InitAPIKeys(keysFile.Keys, permissions)
+	return keysFile.Keys, nil
+}
+
 func FromFile(filename string, params CmdLineParams) (*Config, error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -86,6 +86,37 @@ cache_methods:
   params_in_cache_by_name:
     - %s
 `, proxyURL, token, methodName, strconv.Itoa(paramInCacheID), paramInCacheName)
+	apiKeyHash    = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	configAPIKeys = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+rate_limit_tiers:
+- name: basic
+  requests_per_second: 5
+  burst: 10
+api_keys:
+  keys:
+  - name: script
+    hash: %s
+    tier: basic
+`, proxyURL, token, apiKeyHash)
+	configAPIKeysUnknownTier = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+api_keys:
+  keys:
+  - name: script
+    hash: %s
+    tier: basic
+`, proxyURL, token, apiKeyHash)
+	configAPIKeysWrongHash = fmt.Sprintf(`
+proxy_url: %s
+jwt_secret: %s
+api_keys:
+  keys:
+  - name: script
+    hash: test
+`, proxyURL, token)
 	configParamsWrongCacheStorage = fmt.Sprintf(`
 proxy_url: %s
 jwt_secret: %s
@@ -146,3 +177,330 @@ func TestNewConfigCacheParamsByIDWrongMethodKind(t *testing.T) {
 	_, err := New(strings.NewReader(configParamsByIDAndNameWrongMethodKind))
 	require.Error(t, err, err)
 }
+
+func TestNewConfigAPIKeys(t *testing.T) {
+	config, err := New(strings.NewReader(configAPIKeys))
+	require.NoError(t, err, err)
+	require.NoError(t, config.Validate())
+	require.True(t, config.APIKeys.Enabled())
+	require.Equal(t, defaultAPIKeyHeader, config.APIKeys.Header)
+	require.Equal(t, defaultAPIKeyQueryParam, config.APIKeys.QueryParam)
+	require.Equal(t, defaultJWTPermissions, config.APIKeys.Keys[0].Permissions)
+	require.Equal(t, "basic", config.APIKeys.Keys[0].Tier)
+}
+
+func TestNewConfigAPIKeysInvalid(t *testing.T) {
+	for _, data := range []string{configAPIKeysUnknownTier, configAPIKeysWrongHash} {
+		config, err := New(strings.NewReader(data))
+		require.NoError(t, err, err)
+		require.Error(t, config.Validate())
+	}
+}
+
+func TestConfigMethodPermissions(t *testing.T) {
+	conf := Config{
+		JWTSecret:         token,
+		ProxyURL:          proxyURL,
+		MethodPermissions: []MethodPermission{{Name: "Filecoin.State*", Permission: "write"}},
+	}
+	conf.Init()
+	require.NoError(t, conf.Validate())
+	conf.MethodPermissions[0].Permission = "root"
+	require.Error(t, conf.Validate())
+	conf.MethodPermissions[0] = MethodPermission{Name: "[", Permission: "read"}
+	require.Error(t, conf.Validate())
+}
+
+func TestConfigMethodPatterns(t *testing.T) {
+	conf := Config{
+		JWTSecret: token,
+		ProxyURL:  proxyURL,
+		CacheMethods: []CacheMethod{
+			{Name: "eth_get.*ByHash", NameMatch: RegexMatch},
+			{Name: "Filecoin.ChainGet*"},
+		},
+	}
+	conf.Init()
+	require.NoError(t, conf.Validate())
+	require.Equal(t, GlobMatch, conf.CacheMethods[1].NameMatch)
+
+	conf.CacheMethods = append(conf.CacheMethods, CacheMethod{Name: "eth_(", NameMatch: RegexMatch})
+	conf.Init()
+	require.Error(t, conf.Validate())
+
+	conf.CacheMethods = []CacheMethod{{Name: "Filecoin.State*", ParamsForRequest: []interface{}{}}}
+	conf.Init()
+	require.Error(t, conf.Validate())
+}
+
+func TestConfigParamsInCacheByID(t *testing.T) {
+	conf := Config{
+		JWTSecret:    token,
+		ProxyURL:     proxyURL,
+		CacheMethods: []CacheMethod{{Name: "Filecoin.StateGetActor", CacheByParams: true, ParamsInCacheByID: []int{-1}}},
+	}
+	conf.Init()
+	require.Error(t, conf.Validate())
+}
+
+func TestConfigDefaultRateLimitTier(t *testing.T) {
+	conf := Config{
+		JWTSecret:            token,
+		ProxyURL:             proxyURL,
+		RateLimitTiers:       []RateLimitTier{{Name: "basic", RequestsPerSecond: 1}},
+		DefaultRateLimitTier: "basic",
+	}
+	conf.Init()
+	require.NoError(t, conf.Validate())
+	conf.DefaultRateLimitTier = "pro"
+	require.Error(t, conf.Validate())
+}
+
+func TestConfigMethodConditions(t *testing.T) {
+	conf := Config{
+		JWTSecret: token,
+		ProxyURL:  proxyURL,
+		CacheMethods: []CacheMethod{{
+			Name:    "Filecoin.StateGetActor",
+			ReadIf:  "len(params) > 1 && len(params[1]) > 0",
+			WriteIf: "size < 1048576",
+			TTLExpr: "height > 100 ? 30 : 0",
+		}},
+	}
+	conf.Init()
+	require.NoError(t, conf.Validate())
+
+	conf.CacheMethods[0].WriteIf = "size"
+	require.Error(t, conf.Validate())
+
+	conf.CacheMethods[0].WriteIf = ""
+	conf.CacheMethods[0].TTL = 10
+	require.Error(t, conf.Validate())
+}
+
+func TestConfigParamsTemplates(t *testing.T) {
+	customKind := CustomMethod
+	conf := Config{
+		JWTSecret: token,
+		ProxyURL:  proxyURL,
+		CacheMethods: []CacheMethod{
+			{
+				Name:             "Filecoin.ChainGetTipSetByHeight",
+				Enabled:          true,
+				Kind:             &customKind,
+				ParamsForRequest: []interface{}{"{{ height -900 }}", []interface{}{}},
+			},
+			{
+				Name:             "Filecoin.StateMinerPower",
+				Enabled:          true,
+				Kind:             &customKind,
+				ParamsForRequest: []interface{}{"f01234", `{{ result "Filecoin.ChainGetTipSetByHeight" "$.Cids" }}`},
+			},
+		},
+	}
+	conf.Init()
+	require.NoError(t, conf.Validate())
+
+	conf.CacheMethods[0].ParamsForRequest = []interface{}{`{{ result "Filecoin.StateMinerPower" "$.MinerPower" }}`}
+	require.Error(t, conf.Validate())
+
+	conf.CacheMethods[0].ParamsForRequest = []interface{}{`{{ result "Filecoin.ChainHead" "$.Cids" }}`}
+	require.Error(t, conf.Validate())
+}
+
+func TestConfigMethodRefreshSchedule(t *testing.T) {
+	conf := Config{
+		JWTSecret: token,
+		ProxyURL:  proxyURL,
+		CacheMethods: []CacheMethod{{
+			Name:          "Filecoin.StateGetActor",
+			RefreshCron:   "*/5 * * * *",
+			RefreshJitter: 30,
+		}},
+	}
+	conf.Init()
+	require.NoError(t, conf.Validate())
+	require.True(t, conf.CacheMethods[0].IsScheduled())
+
+	conf.CacheMethods[0].RefreshPeriod = 60
+	require.Error(t, conf.Validate())
+
+	conf.CacheMethods[0].RefreshCron = "* * *"
+	conf.CacheMethods[0].RefreshPeriod = 0
+	require.Error(t, conf.Validate())
+
+	conf.CacheMethods[0].RefreshCron = "@every 1m"
+	conf.CacheMethods[0].NoUpdateCache = true
+	require.Error(t, conf.Validate())
+}
+
+func TestConfigLeaderElection(t *testing.T) {
+	conf := Config{
+		JWTSecret: token,
+		ProxyURL:  proxyURL,
+	}
+	conf.Init()
+	require.NoError(t, conf.Validate())
+	require.Equal(t, NoLeaderElection, conf.LeaderElection.Type)
+
+	conf.LeaderElection.Type = RedisLeaderElection
+	require.Error(t, conf.Validate())
+	conf.CacheSettings.Redis.URI = "redis://127.0.0.1:6379/0"
+	require.NoError(t, conf.Validate())
+
+	conf.LeaderElection.Type = FileLeaderElection
+	require.Error(t, conf.Validate())
+	conf.LeaderElection.File = "/tmp/proxy.lock"
+	require.NoError(t, conf.Validate())
+
+	conf.LeaderElection.Type = "zookeeper"
+	require.Error(t, conf.Validate())
+}
+
+func TestConfigUpdaterSettings(t *testing.T) {
+	conf := Config{
+		JWTSecret: token,
+		ProxyURL:  proxyURL,
+	}
+	conf.Init()
+	require.NoError(t, conf.Validate())
+	require.Equal(t, defaultUpdaterTimeout, conf.Updater.Timeout)
+	require.Equal(t, defaultUpdaterRetries, conf.Updater.Retries)
+	require.Equal(t, defaultUpdaterMaxFailures, conf.Updater.MaxFailures)
+
+	conf.Updater.Retries = -1
+	conf.Updater.MaxFailures = -1
+	require.NoError(t, conf.Validate())
+
+	conf.Updater.RetryBackoff = conf.Updater.RetryMaxBackoff + 1
+	require.Error(t, conf.Validate())
+}
+
+func TestConfigTokens(t *testing.T) {
+	conf := Config{
+		JWTSecret: token,
+		ProxyURL:  proxyURL,
+	}
+	conf.Init()
+	require.NoError(t, conf.Validate())
+	require.Equal(t, MemoryCacheStorage, conf.Tokens.RevocationStorage)
+	require.Equal(t, defaultUpstreamTokenTTL, conf.Tokens.UpstreamTTL)
+
+	conf.Tokens.RevocationStorage = RedisCacheStorage
+	require.Error(t, conf.Validate())
+	conf.CacheSettings.Redis.URI = redisURI
+	require.Error(t, conf.Validate())
+	conf.CacheSettings.Storage = RedisCacheStorage
+	require.NoError(t, conf.Validate())
+
+	conf.Tokens.RevocationStorage = "file"
+	require.Error(t, conf.Validate())
+}
+
+func TestConfigTLS(t *testing.T) {
+	conf := Config{
+		JWTSecret: token,
+		ProxyURL:  proxyURL,
+		TLS: TLSSettings{
+			CertFile:     "cert.pem",
+			KeyFile:      "key.pem",
+			ClientCAFile: "ca.pem",
+		},
+	}
+	conf.Init()
+	require.NoError(t, conf.Validate())
+	require.Equal(t, RequireClientAuth, conf.TLS.ClientAuth)
+	require.Equal(t, defaultTLSMinVersion, conf.TLS.MinVersion)
+
+	conf.TLS.MinVersion = "1.4"
+	require.Error(t, conf.Validate())
+	conf.TLS.MinVersion = "1.3"
+
+	conf.TLS.ClientAuth = NoClientAuth
+	conf.TLS.ClientCertIdentity = true
+	require.Error(t, conf.Validate())
+
+	conf.TLS = TLSSettings{CertFile: "cert.pem"}
+	conf.Init()
+	require.Error(t, conf.Validate())
+}
+
+func TestConfigCompression(t *testing.T) {
+	conf := Config{
+		JWTSecret:   token,
+		ProxyURL:    proxyURL,
+		Compression: CompressionSettings{Encodings: []string{"zstd", "gzip"}},
+	}
+	conf.Init()
+	require.NoError(t, conf.Validate())
+	require.True(t, conf.Compression.Enabled())
+	require.Equal(t, defaultCompressionMinSize, conf.Compression.MinSize)
+
+	conf.Compression.Encodings = append(conf.Compression.Encodings, "deflate")
+	require.Error(t, conf.Validate())
+}
+
+func TestConfigUpstream(t *testing.T) {
+	conf := Config{
+		JWTSecret: token,
+		ProxyURL:  proxyURL,
+		Upstream: UpstreamSettings{
+			Methods: []UpstreamMethod{{Name: "Filecoin.StateMarketDeals", Timeout: 300}},
+		},
+	}
+	conf.Init()
+	require.NoError(t, conf.Validate())
+	require.Equal(t, defaultUpstreamDialTimeout, conf.Upstream.DialTimeout)
+	require.Equal(t, defaultUpstreamMaxIdleConns, conf.Upstream.MaxIdleConnsPerHost)
+
+	conf.Upstream.CertFile = "cert.pem"
+	require.Error(t, conf.Validate())
+	conf.Upstream.CertFile = ""
+
+	conf.Upstream.Methods[0].Timeout = -1
+	require.Error(t, conf.Validate())
+	conf.Upstream.Methods[0].Timeout = 0
+
+	require.Equal(t, defaultBatchConcurrency, conf.Upstream.BatchConcurrency)
+	conf.Upstream.MaxBatchSize = -1
+	require.Error(t, conf.Validate())
+}
+
+func TestConfigHedging(t *testing.T) {
+	conf := Config{
+		JWTSecret: token,
+		ProxyURL:  proxyURL,
+		Hedging:   HedgingSettings{Enabled: true},
+	}
+	conf.Init()
+	require.Error(t, conf.Validate())
+
+	conf.ProxyMirrorURLs = []string{"http://mirror.com/rpc/v0"}
+	require.NoError(t, conf.Validate())
+	require.Equal(t, defaultHedgingMinDelay, conf.Hedging.MinDelay)
+
+	conf.Hedging.Delay = -1
+	require.Error(t, conf.Validate())
+}
+
+func TestConfigMicroBatching(t *testing.T) {
+	conf := Config{
+		JWTSecret:     token,
+		ProxyURL:      proxyURL,
+		MicroBatching: MicroBatchingSettings{Window: 2},
+	}
+	conf.Init()
+	require.NoError(t, conf.Validate())
+	require.True(t, conf.MicroBatching.Enabled())
+	require.Equal(t, defaultMicroBatchMaxSize, conf.MicroBatching.MaxSize)
+
+	conf.Upstream.MaxBatchSize = 10
+	require.Error(t, conf.Validate())
+	conf.MicroBatching.MaxSize = 0
+	conf.Init()
+	require.NoError(t, conf.Validate())
+	require.Equal(t, 10, conf.MicroBatching.MaxSize)
+
+	conf.MicroBatching.Window = -1
+	require.Error(t, conf.Validate())
+}
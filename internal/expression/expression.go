@@ -0,0 +1,115 @@
+package expression
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/conf"
+	"github.com/antonmedv/expr/vm"
+)
+
+var anyType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// Env is the context expressions are evaluated against
+type Env struct {
+	Method string
+	Params interface{}
+	Result interface{}
+	Size   int
+	Height int64
+	Caller string
+}
+
+func (e Env) vars() map[string]interface{} {
+	return map[string]interface{}{
+		"method": e.Method,
+		"params": params(e.Params),
+		"result": e.Result,
+		"size":   e.Size,
+		"height": e.Height,
+		"caller": e.Caller,
+		"now":    time.Now().Unix(),
+	}
+}
+
+// dynamic declares variables whose type is known only at runtime
+func dynamic(names ...string) expr.Option {
+	return func(c *conf.Config) {
+		for _, name := range names {
+			c.Types[name] = conf.Tag{Type: anyType}
+		}
+	}
+}
+
+// Program is a compiled expression
+type Program struct {
+	source  string
+	program *vm.Program
+}
+
+func compile(source string, ops ...expr.Option) (*Program, error) {
+	if source == "" {
+		return nil, nil
+	}
+	ops = append(ops, expr.Env(Env{}.vars()), dynamic("params", "result"))
+	program, err := expr.Compile(source, ops...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compile expression %q: %w", source, err)
+	}
+	return &Program{source: source, program: program}, nil
+}
+
+// CompileBool compiles boolean expression. Returns nil program for the empty source
+func CompileBool(source string) (*Program, error) {
+	return compile(source, expr.AsBool())
+}
+
+// CompileNumber compiles numeric expression. Returns nil program for the empty source
+func CompileNumber(source string) (*Program, error) {
+	return compile(source)
+}
+
+// String returns expression source
+func (p *Program) String() string {
+	return p.source
+}
+
+// Bool evaluates boolean expression
+func (p *Program) Bool(env Env) (bool, error) {
+	out, err := expr.Run(p.program, env.vars())
+	if err != nil {
+		return false, fmt.Errorf("cannot evaluate expression %q: %w", p.source, err)
+	}
+	res, ok := out.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q returned %T instead of bool", p.source, out)
+	}
+	return res, nil
+}
+
+// Int evaluates numeric expression
+func (p *Program) Int(env Env) (int64, error) {
+	out, err := expr.Run(p.program, env.vars())
+	if err != nil {
+		return 0, fmt.Errorf("cannot evaluate expression %q: %w", p.source, err)
+	}
+	switch v := out.(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case float64:
+		return int64(v), nil
+	default:
+		return 0, fmt.Errorf("expression %q returned %T instead of number", p.source, out)
+	}
+}
+
+func params(p interface{}) interface{} {
+	if p == nil {
+		return []interface{}{}
+	}
+	return p
+}
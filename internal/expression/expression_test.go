@@ -0,0 +1,46 @@
+package expression
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileEmpty(t *testing.T) {
+	program, err := CompileBool("")
+	require.NoError(t, err)
+	require.Nil(t, program)
+}
+
+func TestCompileInvalid(t *testing.T) {
+	_, err := CompileBool("size + 1")
+	require.Error(t, err)
+	_, err = CompileBool("unknown > 1")
+	require.Error(t, err)
+}
+
+func TestBool(t *testing.T) {
+	program, err := CompileBool(`method == "test" && len(params) > 0 && params[0].Key != nil && size < 10`)
+	require.NoError(t, err)
+
+	ok, err := program.Bool(Env{Method: "test", Params: []interface{}{map[string]interface{}{"Key": 1}}})
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = program.Bool(Env{Method: "test"})
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestInt(t *testing.T) {
+	program, err := CompileNumber(`height > 100 ? 30 : 0`)
+	require.NoError(t, err)
+
+	value, err := program.Int(Env{Height: 101})
+	require.NoError(t, err)
+	require.Equal(t, int64(30), value)
+
+	value, err = program.Int(Env{Height: 100})
+	require.NoError(t, err)
+	require.Equal(t, int64(0), value)
+}
@@ -0,0 +1,120 @@
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+)
+
+// Firewall implements method allow/deny lists and request size limits
+type Firewall struct {
+	allow         []string
+	deny          []string
+	maxParamsSize int
+	maxBatchSize  int
+	methods       []config.MethodPolicy
+}
+
+// New initializes firewall from settings
+func New(settings config.FirewallSettings) *Firewall {
+	return &Firewall{
+		allow:         settings.Allow,
+		deny:          settings.Deny,
+		maxParamsSize: settings.MaxParamsSize,
+		maxBatchSize:  settings.MaxBatchSize,
+		methods:       settings.Methods,
+	}
+}
+
+// FromConfig initializes firewall from config
+func FromConfig(c *config.Config) *Firewall {
+	return New(c.Firewall)
+}
+
+func matchAny(patterns []string, method string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAllowed checks method against allow and deny lists. Deny list has precedence
+func (f *Firewall) IsAllowed(method string) bool {
+	if matchAny(f.deny, method) {
+		return false
+	}
+	return len(f.allow) == 0 || matchAny(f.allow, method)
+}
+
+func (f *Firewall) maxParams(method string) int {
+	for _, policy := range f.methods {
+		if ok, _ := path.Match(policy.Name, method); ok && policy.MaxParamsSize > 0 {
+			return policy.MaxParamsSize
+		}
+	}
+	return f.maxParamsSize
+}
+
+// CheckBatch checks the number of batch entries
+func (f *Firewall) CheckBatch(reqs requests.RPCRequests) error {
+	if f.maxBatchSize > 0 && len(reqs) > f.maxBatchSize {
+		return fmt.Errorf("batch size %d exceeds the limit of %d entries", len(reqs), f.maxBatchSize)
+	}
+	return nil
+}
+
+// Check returns an error response for the rejected request
+func (f *Firewall) Check(req requests.RPCRequest) (requests.RPCResponse, bool) {
+	if !f.IsAllowed(req.Method) {
+		return requests.MethodNotAllowedResponse(req.ID, req.Method), false
+	}
+	if limit := f.maxParams(req.Method); limit > 0 && req.Params != nil {
+		params, err := json.Marshal(req.Params)
+		if err != nil {
+			return requests.InvalidParamsResponse(req.ID, err.Error()), false
+		}
+		if len(params) > limit {
+			return requests.InvalidParamsResponse(
+				req.ID,
+				fmt.Sprintf("params size %d exceeds the limit of %d bytes", len(params), limit),
+			), false
+		}
+	}
+	return requests.RPCResponse{}, true
+}
+
+// Filter returns responses with errors on positions of rejected requests and empty responses for allowed ones
+func (f *Firewall) Filter(reqs requests.RPCRequests) (requests.RPCResponses, []int) {
+	results := make(requests.RPCResponses, len(reqs))
+	var rejected []int
+	for idx, req := range reqs {
+		if resp, ok := f.Check(req); !ok {
+			results[idx] = resp
+			rejected = append(rejected, idx)
+		}
+	}
+	return results, rejected
+}
+
+// FilterScope rejects requests with methods outside of the client token scope.
+// Empty scope allows all methods. Requests with prepared responses are skipped.
+// Returns positions of the rejected requests
+func FilterScope(scope []string, reqs requests.RPCRequests, responses requests.RPCResponses) []int {
+	if len(scope) == 0 {
+		return nil
+	}
+	var rejected []int
+	for idx, req := range reqs {
+		if !responses[idx].IsEmpty() || matchAny(scope, req.Method) {
+			continue
+		}
+		responses[idx] = requests.MethodNotAllowedResponse(req.ID, req.Method)
+		rejected = append(rejected, idx)
+	}
+	return rejected
+}
@@ -0,0 +1,63 @@
+package firewall
+
+import (
+	"testing"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFirewallIsAllowed(t *testing.T) {
+	f := New(config.FirewallSettings{
+		Allow: []string{"Filecoin.*"},
+		Deny:  []string{"Filecoin.StateListMessages", "Filecoin.Wallet*"},
+	})
+	require.True(t, f.IsAllowed("Filecoin.ChainHead"))
+	require.False(t, f.IsAllowed("Filecoin.StateListMessages"))
+	require.False(t, f.IsAllowed("Filecoin.WalletSign"))
+	require.False(t, f.IsAllowed("eth_call"))
+	require.True(t, New(config.FirewallSettings{}).IsAllowed("eth_call"))
+}
+
+func TestFirewallFilter(t *testing.T) {
+	f := New(config.FirewallSettings{
+		Deny:          []string{"Filecoin.WalletSign"},
+		MaxParamsSize: 10,
+		MaxBatchSize:  3,
+		Methods:       []config.MethodPolicy{{Name: "Filecoin.StateCall", MaxParamsSize: 100}},
+	})
+	reqs := requests.RPCRequests{
+		{JSONRPC: "2.0", ID: 1, Method: "Filecoin.ChainHead"},
+		{JSONRPC: "2.0", ID: 2, Method: "Filecoin.WalletSign"},
+		{JSONRPC: "2.0", ID: 3, Method: "Filecoin.ChainGetTipSetByHeight", Params: []interface{}{"1234567890", nil}},
+		{JSONRPC: "2.0", ID: 4, Method: "Filecoin.StateCall", Params: []interface{}{"1234567890", nil}},
+	}
+	require.Error(t, f.CheckBatch(reqs))
+	require.NoError(t, f.CheckBatch(reqs[:3]))
+
+	responses, rejected := f.Filter(reqs)
+	require.Equal(t, []int{1, 2}, rejected)
+	require.True(t, responses[0].IsEmpty())
+	require.NotNil(t, responses[1].Error)
+	require.Equal(t, 2, responses[1].ID)
+	require.NotNil(t, responses[2].Error)
+	require.True(t, responses[3].IsEmpty())
+}
+
+func TestFilterScope(t *testing.T) {
+	reqs := requests.RPCRequests{
+		{JSONRPC: "2.0", ID: 1, Method: "Filecoin.ChainHead"},
+		{JSONRPC: "2.0", ID: 2, Method: "Filecoin.StateCall"},
+		{JSONRPC: "2.0", ID: 3, Method: "Filecoin.WalletSign"},
+	}
+	responses := make(requests.RPCResponses, len(reqs))
+	responses[2] = requests.MethodNotAllowedResponse(3, "Filecoin.WalletSign")
+
+	require.Nil(t, FilterScope(nil, reqs, responses))
+	require.Equal(t, []int{1}, FilterScope([]string{"Filecoin.Chain*"}, reqs, responses))
+	require.True(t, responses[0].IsEmpty())
+	require.NotNil(t, responses[1].Error)
+	require.Equal(t, 2, responses[1].ID)
+}
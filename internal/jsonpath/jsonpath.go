@@ -0,0 +1,143 @@
+package jsonpath
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Path is a compiled JSONPath-like selector.
+// Supported syntax: $ root, .name, ["name"], [index], [-index], [*] and .* wildcards.
+type Path struct {
+	expr     string
+	segments []segment
+}
+
+type segment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// Parse compiles the selector expression
+func Parse(expr string) (Path, error) {
+	p := Path{expr: expr}
+	s := strings.TrimSpace(expr)
+	s = strings.TrimPrefix(s, "$")
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			name := s[:end]
+			if name == "" {
+				return Path{}, fmt.Errorf("empty field name in path %q", expr)
+			}
+			if name == "*" {
+				p.segments = append(p.segments, segment{wildcard: true})
+			} else {
+				p.segments = append(p.segments, segment{key: name})
+			}
+			s = s[end:]
+		case '[':
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return Path{}, fmt.Errorf("unclosed bracket in path %q", expr)
+			}
+			inner := strings.TrimSpace(s[1:end])
+			s = s[end+1:]
+			switch {
+			case inner == "*":
+				p.segments = append(p.segments, segment{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0]:
+				p.segments = append(p.segments, segment{key: inner[1 : len(inner)-1]})
+			default:
+				idx, err := strconv.Atoi(inner)
+				if err != nil {
+					return Path{}, fmt.Errorf("invalid index %q in path %q", inner, expr)
+				}
+				p.segments = append(p.segments, segment{index: idx, isIndex: true})
+			}
+		default:
+			return Path{}, fmt.Errorf("unexpected character %q in path %q", s[0], expr)
+		}
+	}
+	return p, nil
+}
+
+// String returns the source expression
+func (p Path) String() string {
+	return p.expr
+}
+
+// Select returns all values matched by the path. Missing fields or indexes produce an error.
+// Wildcards over empty collections produce no values
+func (p Path) Select(v interface{}) ([]interface{}, error) {
+	current := []interface{}{v}
+	for _, seg := range p.segments {
+		var next []interface{}
+		for _, value := range current {
+			values, err := seg.apply(value)
+			if err != nil {
+				return nil, fmt.Errorf("path %q: %w", p.expr, err)
+			}
+			next = append(next, values...)
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// Exists reports whether the path matches at least one value
+func (p Path) Exists(v interface{}) bool {
+	values, err := p.Select(v)
+	return err == nil && len(values) > 0
+}
+
+func (s segment) apply(v interface{}) ([]interface{}, error) {
+	switch value := v.(type) {
+	case []interface{}:
+		if s.wildcard {
+			return value, nil
+		}
+		if !s.isIndex {
+			return nil, fmt.Errorf("cannot select field %q of array", s.key)
+		}
+		idx := s.index
+		if idx < 0 {
+			idx += len(value)
+		}
+		if idx < 0 || idx >= len(value) {
+			return nil, fmt.Errorf("index %d out of range", s.index)
+		}
+		return []interface{}{value[idx]}, nil
+	case map[string]interface{}:
+		if s.wildcard {
+			keys := make([]string, 0, len(value))
+			for key := range value {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			res := make([]interface{}, len(keys))
+			for idx, key := range keys {
+				res[idx] = value[key]
+			}
+			return res, nil
+		}
+		if s.isIndex {
+			return nil, fmt.Errorf("cannot select index %d of object", s.index)
+		}
+		field, ok := value[s.key]
+		if !ok {
+			return nil, fmt.Errorf("field %q not found", s.key)
+		}
+		return []interface{}{field}, nil
+	default:
+		return nil, fmt.Errorf("cannot select from %T", v)
+	}
+}
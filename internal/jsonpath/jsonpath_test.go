@@ -0,0 +1,52 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const document = `[
+	{"To": "f01", "Method": 2, "Cids": [{"/": "a"}, {"/": "b"}]},
+	[]
+]`
+
+func TestSelect(t *testing.T) {
+	var doc interface{}
+	require.NoError(t, json.Unmarshal([]byte(document), &doc))
+
+	cases := []struct {
+		expr   string
+		result []interface{}
+	}{
+		{expr: "$[0].To", result: []interface{}{"f01"}},
+		{expr: `$[0]["Method"]`, result: []interface{}{float64(2)}},
+		{expr: "$[0].Cids[*]./", result: []interface{}{"a", "b"}},
+		{expr: "$[0].Cids[-1]['/']", result: []interface{}{"b"}},
+		{expr: "$[1][*]", result: nil},
+		{expr: "[0].*", result: []interface{}{[]interface{}{map[string]interface{}{"/": "a"}, map[string]interface{}{"/": "b"}}, float64(2), "f01"}},
+	}
+	for _, c := range cases {
+		p, err := Parse(c.expr)
+		require.NoError(t, err, c.expr)
+		values, err := p.Select(doc)
+		require.NoError(t, err, c.expr)
+		require.Equal(t, c.result, values, c.expr)
+	}
+
+	for _, expr := range []string{"$[0].From", "$[2]", "$[0][0]", "$[0].To.x"} {
+		p, err := Parse(expr)
+		require.NoError(t, err, expr)
+		_, err = p.Select(doc)
+		require.Error(t, err, expr)
+		require.False(t, p.Exists(doc), expr)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{"$.", "$[0", "$[a]", "$x"} {
+		_, err := Parse(expr)
+		require.Error(t, err, expr)
+	}
+}
@@ -0,0 +1,52 @@
+//go:build !windows
+// +build !windows
+
+package leader
+
+import (
+	"context"
+	"os"
+	"syscall"
+)
+
+// fileLock is an exclusive lock of a local file. The OS releases it when the process dies
+type fileLock struct {
+	path string
+	file *os.File
+}
+
+func newFileLock(path string) *fileLock {
+	return &fileLock{path: path}
+}
+
+func (l *fileLock) acquire(context.Context) (bool, error) {
+	if l.file != nil {
+		return true, nil
+	}
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return false, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return false, nil
+		}
+		return false, err
+	}
+	l.file = file
+	return true, nil
+}
+
+func (l *fileLock) release(context.Context) error {
+	if l.file == nil {
+		return nil
+	}
+	file := l.file
+	l.file = nil
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_UN); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,23 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+)
+
+// fileLock is not supported on windows
+type fileLock struct {
+	path string
+}
+
+func newFileLock(path string) *fileLock {
+	return &fileLock{path: path}
+}
+
+func (l *fileLock) acquire(context.Context) (bool, error) {
+	return false, fmt.Errorf("file leader election is not supported on windows")
+}
+
+func (l *fileLock) release(context.Context) error {
+	return nil
+}
@@ -0,0 +1,156 @@
+package leader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/metrics"
+)
+
+// Elector decides whether the replica is the leader allowed to run the updater
+type Elector interface {
+	IsLeader() bool
+	// Run campaigns for leadership until the context is done and releases it on exit
+	Run(ctx context.Context)
+}
+
+// Always is an elector for setups without election. The replica is always the leader
+type Always struct{}
+
+// IsLeader ...
+func (Always) IsLeader() bool {
+	return true
+}
+
+// Run ...
+func (Always) Run(ctx context.Context) {
+	metrics.SetUpdaterLeader(true)
+	<-ctx.Done()
+}
+
+// lock is a lease based lock
+type lock interface {
+	// acquire takes or renews the lock. Returns whether the lock is held
+	acquire(ctx context.Context) (bool, error)
+	release(ctx context.Context) error
+}
+
+// campaign keeps trying to hold the lock
+type campaign struct {
+	lock     lock
+	interval time.Duration
+	logger   *logrus.Entry
+	leader   int32
+	// closer releases resources of the lock when the campaign is over
+	closer io.Closer
+}
+
+func newCampaign(l lock, interval time.Duration, logger *logrus.Entry) *campaign {
+	return &campaign{lock: l, interval: interval, logger: logger}
+}
+
+// IsLeader ...
+func (c *campaign) IsLeader() bool {
+	return atomic.LoadInt32(&c.leader) == 1
+}
+
+func (c *campaign) setLeader(leader bool) {
+	var value int32
+	if leader {
+		value = 1
+	}
+	if atomic.SwapInt32(&c.leader, value) != value {
+		if leader {
+			c.logger.Info("Became the updater leader")
+		} else {
+			c.logger.Info("Lost the updater leadership")
+		}
+	}
+	metrics.SetUpdaterLeader(leader)
+}
+
+func (c *campaign) try(ctx context.Context) {
+	held, err := c.lock.acquire(ctx)
+	if err != nil {
+		c.logger.Errorf("Cannot acquire leader lock: %v", err)
+	}
+	c.setLeader(held && err == nil)
+}
+
+// Run ...
+func (c *campaign) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	c.try(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			releaseCtx, cancel := context.WithTimeout(context.Background(), c.interval)
+			defer cancel()
+			if c.IsLeader() {
+				if err := c.lock.release(releaseCtx); err != nil {
+					c.logger.Errorf("Cannot release leader lock: %v", err)
+				}
+			}
+			c.setLeader(false)
+			if c.closer != nil {
+				if err := c.closer.Close(); err != nil {
+					c.logger.Errorf("Cannot close leader lock: %v", err)
+				}
+			}
+			return
+		case <-ticker.C:
+			c.try(ctx)
+		}
+	}
+}
+
+// FromConfig initializes elector from config
+func FromConfig(ctx context.Context, c *config.Config, logger *logrus.Entry) (Elector, error) {
+	lease := time.Duration(c.LeaderElection.Lease) * time.Second
+	interval := lease / 3
+	switch c.LeaderElection.Type {
+	case config.NoLeaderElection:
+		return Always{}, nil
+	case config.RedisLeaderElection:
+		client, err := cache.NewRedisClient(ctx, c.CacheSettings.Redis)
+		if err != nil {
+			return nil, err
+		}
+		id, err := replicaID()
+		if err != nil {
+			_ = client.Close()
+			return nil, err
+		}
+		campaign := newCampaign(newRedisLock(client.Client, c.LeaderElection.Key, id, lease), interval, logger)
+		campaign.closer = client
+		return campaign, nil
+	case config.FileLeaderElection:
+		return newCampaign(newFileLock(c.LeaderElection.File), interval, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown leader election type: %s", c.LeaderElection.Type)
+	}
+}
+
+// replicaID returns unique identifier of the replica holding the lock
+func replicaID() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(suffix)), nil
+}
@@ -0,0 +1,73 @@
+package leader
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+)
+
+func TestMain(m *testing.M) { // nolint
+	logger.InitDefaultLogger()
+	goleak.VerifyTestMain(m)
+}
+
+func TestFileLockTakeover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.lock")
+	first := newCampaign(newFileLock(path), 10*time.Millisecond, logger.Log)
+	second := newCampaign(newFileLock(path), 10*time.Millisecond, logger.Log)
+
+	ctxFirst, cancelFirst := context.WithCancel(context.Background())
+	firstDone := make(chan struct{})
+	go func() {
+		first.Run(ctxFirst)
+		close(firstDone)
+	}()
+	require.Eventually(t, first.IsLeader, time.Second, 10*time.Millisecond)
+
+	ctxSecond, cancelSecond := context.WithCancel(context.Background())
+	defer cancelSecond()
+	secondDone := make(chan struct{})
+	go func() {
+		second.Run(ctxSecond)
+		close(secondDone)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.False(t, second.IsLeader())
+
+	cancelFirst()
+	<-firstDone
+	require.False(t, first.IsLeader())
+	require.Eventually(t, second.IsLeader, time.Second, 10*time.Millisecond)
+
+	cancelSecond()
+	<-secondDone
+}
+
+func TestAlways(t *testing.T) {
+	require.True(t, Always{}.IsLeader())
+}
+
+type closer struct {
+	closed bool
+}
+
+func (c *closer) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestCampaignClosesLock(t *testing.T) {
+	c := newCampaign(newFileLock(filepath.Join(t.TempDir(), "leader.lock")), 10*time.Millisecond, logger.Log)
+	lockCloser := &closer{}
+	c.closer = lockCloser
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Run(ctx)
+	require.True(t, lockCloser.closed)
+}
@@ -0,0 +1,54 @@
+package leader
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// renewScript prolongs the lease if the lock is held by the replica
+	renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	// releaseScript deletes the lock if it is held by the replica
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// redisLock is a lock with the lease stored in redis
+type redisLock struct {
+	client *redis.Client
+	key    string
+	id     string
+	lease  time.Duration
+}
+
+func newRedisLock(client *redis.Client, key, id string, lease time.Duration) *redisLock {
+	return &redisLock{client: client, key: key, id: id, lease: lease}
+}
+
+func (l *redisLock) acquire(ctx context.Context) (bool, error) {
+	ok, err := l.client.SetNX(ctx, l.key, l.id, l.lease).Result()
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return true, nil
+	}
+	renewed, err := renewScript.Run(ctx, l.client, []string{l.key}, l.id, l.lease.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return renewed == 1, nil
+}
+
+func (l *redisLock) release(ctx context.Context) error {
+	return releaseScript.Run(ctx, l.client, []string{l.key}, l.id).Err()
+}
@@ -0,0 +1,84 @@
+package matcher
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/jsonpath"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+)
+
+// admission keeps rules a response should satisfy to be stored in the cache
+type admission struct {
+	skipNull         bool
+	skipEmpty        bool
+	requirePaths     []jsonpath.Path
+	minSize          int
+	maxSize          int
+	requireAgreement bool
+}
+
+func newAdmission(c config.CacheAdmission) admission {
+	a := admission{
+		skipNull:         c.SkipNull,
+		skipEmpty:        c.SkipEmpty,
+		minSize:          c.MinSize,
+		maxSize:          c.MaxSize,
+		requireAgreement: c.RequireAgreement,
+	}
+	for _, expr := range c.RequirePaths {
+		p, err := jsonpath.Parse(expr)
+		if err != nil {
+			logger.Log.Errorf("Cannot parse admission path: %v", err)
+			continue
+		}
+		a.requirePaths = append(a.requirePaths, p)
+	}
+	return a
+}
+
+func isEmptyResult(result interface{}) bool {
+	switch r := result.(type) {
+	case nil:
+		return true
+	case string:
+		return r == ""
+	case []interface{}:
+		return len(r) == 0
+	case map[string]interface{}:
+		return len(r) == 0
+	}
+	return false
+}
+
+func (a admission) admit(resp requests.RPCResponse) error {
+	if resp.Error != nil {
+		return fmt.Errorf("error response")
+	}
+	if a.skipNull && resp.Result == nil {
+		return fmt.Errorf("null result")
+	}
+	if a.skipEmpty && isEmptyResult(resp.Result) {
+		return fmt.Errorf("empty result")
+	}
+	for _, p := range a.requirePaths {
+		if !p.Exists(resp.Result) {
+			return fmt.Errorf("missing path %s", p)
+		}
+	}
+	if a.minSize > 0 || a.maxSize > 0 {
+		data, err := json.Marshal(resp.Result)
+		if err != nil {
+			return err
+		}
+		if len(data) < a.minSize {
+			return fmt.Errorf("result size %d is less than %d", len(data), a.minSize)
+		}
+		if a.maxSize > 0 && len(data) > a.maxSize {
+			return fmt.Errorf("result size %d exceeds %d", len(data), a.maxSize)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package matcher
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/expression"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+)
+
+// conditions keeps compiled expressions deciding whether a response is read from or written to the cache
+type conditions struct {
+	readIf  *expression.Program
+	writeIf *expression.Program
+	ttl     time.Duration
+	ttlExpr *expression.Program
+}
+
+func newConditions(c config.CacheMethod) (conditions, error) {
+	var (
+		cond conditions
+		err  error
+	)
+	if cond.readIf, err = expression.CompileBool(c.ReadIf); err != nil {
+		return cond, err
+	}
+	if cond.writeIf, err = expression.CompileBool(c.WriteIf); err != nil {
+		return cond, err
+	}
+	if cond.ttlExpr, err = expression.CompileNumber(c.TTLExpr); err != nil {
+		return cond, err
+	}
+	cond.ttl = time.Duration(c.TTL) * time.Second
+	return cond, nil
+}
+
+func (c conditions) canRead(env expression.Env) bool {
+	if c.readIf == nil {
+		return true
+	}
+	ok, err := c.readIf.Bool(env)
+	if err != nil {
+		logger.Log.Debugf("Cannot evaluate read condition for method %s: %v", env.Method, err)
+		return false
+	}
+	return ok
+}
+
+// canWrite returns whether the response should be cached and its TTL. Zero TTL means the storage default
+func (c conditions) canWrite(env expression.Env) (bool, time.Duration) {
+	if c.writeIf != nil {
+		ok, err := c.writeIf.Bool(env)
+		if err != nil {
+			logger.Log.Debugf("Cannot evaluate write condition for method %s: %v", env.Method, err)
+			return false, 0
+		}
+		if !ok {
+			return false, 0
+		}
+	}
+	if c.ttlExpr == nil {
+		return true, c.ttl
+	}
+	seconds, err := c.ttlExpr.Int(env)
+	if err != nil {
+		logger.Log.Debugf("Cannot evaluate ttl for method %s: %v", env.Method, err)
+		return false, 0
+	}
+	// a negative ttl disables caching of the response
+	if seconds < 0 {
+		return false, 0
+	}
+	return true, time.Duration(seconds) * time.Second
+}
+
+func (c conditions) needsWriteEnv() bool {
+	return c.writeIf != nil || c.ttlExpr != nil
+}
+
+func resultSize(result interface{}) int {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
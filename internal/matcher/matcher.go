@@ -6,12 +6,28 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/protofire/filecoin-rpc-proxy/internal/expression"
+	"github.com/protofire/filecoin-rpc-proxy/internal/jsonpath"
 	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/templates"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/utils"
 )
 
+// KeyPrefix versions the format of cache keys. It changes whenever keys of the same request change,
+// so entries stored with keys of the previous format can be told apart and purged
+const KeyPrefix = "v2:"
+
+// IsCurrentKey reports whether the cache key has the current format
+func IsCurrentKey(key string) bool {
+	return strings.HasPrefix(key, KeyPrefix)
+}
+
 type cacheMethods []cacheMethod
 type methods map[string]cacheMethods
 
@@ -32,20 +48,26 @@ func (k cacheKeys) sort() {
 	})
 }
 
-type customMethod struct {
+// CustomMethod is a method requested by the updater itself
+type CustomMethod struct {
 	Name   string
 	Params interface{}
+	// Template is set when params contain templates
+	Template *templates.Params
 }
-type customMethods []customMethod
 
-func (m methods) Custom() customMethods {
-	var res customMethods
+// CustomMethods is a list of custom methods
+type CustomMethods []CustomMethod
+
+func (m methods) Custom() CustomMethods {
+	var res CustomMethods
 	for _, cMethods := range m {
 		for _, method := range cMethods {
 			if method.kind.IsCustom() {
-				res = append(res, customMethod{
-					Name:   method.name,
-					Params: method.paramsForRequest,
+				res = append(res, CustomMethod{
+					Name:     method.name,
+					Params:   method.paramsForRequest,
+					Template: method.paramsTemplate,
 				})
 			}
 		}
@@ -55,9 +77,15 @@ func (m methods) Custom() customMethods {
 
 type Matcher interface {
 	Keys(method string, params interface{}) cacheKeys
-	Methods() customMethods
+	Methods() CustomMethods
 	IsUpdatable(method string) bool
 	IsCacheable(method string) bool
+	Admit(method string, resp requests.RPCResponse) error
+	RequiresAgreement(method string) bool
+	CanRead(env expression.Env) bool
+	CanWrite(env expression.Env) (bool, time.Duration)
+	Refreshes() []Refresh
+	RefreshOf(method string) (Refresh, bool)
 }
 
 type cacheMethod struct {
@@ -68,7 +96,12 @@ type cacheMethod struct {
 	noUpdateCache     bool
 	paramsInCacheID   []int
 	paramsInCacheName []string
+	paramsInCachePath []jsonpath.Path
 	paramsForRequest  interface{}
+	paramsTemplate    *templates.Params
+	admission         admission
+	conditions        conditions
+	refresh           *Refresh
 }
 
 func (c cacheMethod) match(params interface{}) ([]interface{}, error) {
@@ -76,6 +109,16 @@ func (c cacheMethod) match(params interface{}) ([]interface{}, error) {
 		return nil, nil
 	}
 	var paramsForCache []interface{}
+	if len(c.paramsInCachePath) > 0 {
+		for _, p := range c.paramsInCachePath {
+			values, err := p.Select(params)
+			if err != nil {
+				return nil, err
+			}
+			paramsForCache = append(paramsForCache, values)
+		}
+		return paramsForCache, nil
+	}
 	if len(c.paramsInCacheID) == 0 && len(c.paramsInCacheName) == 0 {
 		// cache by all Params
 		paramsForCache = append(paramsForCache, params)
@@ -84,8 +127,8 @@ func (c cacheMethod) match(params interface{}) ([]interface{}, error) {
 	if len(c.paramsInCacheID) > 0 {
 		sliceParams, ok := params.([]interface{})
 		if ok {
-			for idx := range c.paramsInCacheID {
-				if idx >= len(sliceParams) {
+			for _, idx := range c.paramsInCacheID {
+				if idx < 0 || idx >= len(sliceParams) {
 					return nil, fmt.Errorf("invalid index %d in slice params: %v", idx, sliceParams)
 				}
 				paramsForCache = append(paramsForCache, sliceParams[idx])
@@ -120,20 +163,65 @@ func (c cacheMethod) toKey(method string, params interface{}) cacheKey {
 	if strKey != "" {
 		keyParams = append(keyParams, strKey)
 	}
-	return cacheKey{Key: strings.Join(keyParams, "_"), cardinality: len(key)}
+	return cacheKey{Key: KeyPrefix + strings.Join(keyParams, "_"), cardinality: len(key)}
+}
+
+// maxResolved limits the number of remembered method name resolutions
+const maxResolved = 10000
+
+// patternRule groups cache rules sharing the same name pattern
+type patternRule struct {
+	pattern  string
+	match    func(string) bool
+	priority int
+	methods  cacheMethods
 }
 
 type match struct {
-	methods methods
+	methods   methods
+	patterns  []*patternRule
+	refreshes []*Refresh
+	lock      sync.RWMutex
+	resolved  map[string]cacheMethods
 }
 
 func newMatcher() *match {
 	userMethods := make(methods)
-	return &match{methods: userMethods}
+	return &match{methods: userMethods, resolved: make(map[string]cacheMethods)}
+}
+
+// lookup returns rules for the method. Exact rules have precedence over pattern rules.
+// Pattern rules are checked by priority and then by their order in the config
+func (m *match) lookup(method string) (cacheMethods, bool) {
+	if methods, ok := m.methods[method]; ok {
+		return methods, true
+	}
+	if len(m.patterns) == 0 {
+		return nil, false
+	}
+	m.lock.RLock()
+	methods, ok := m.resolved[method]
+	m.lock.RUnlock()
+	if ok {
+		return methods, methods != nil
+	}
+	for _, rule := range m.patterns {
+		if rule.match(method) {
+			methods = rule.methods
+			break
+		}
+	}
+	m.lock.Lock()
+	if len(m.resolved) >= maxResolved {
+		m.resolved = make(map[string]cacheMethods)
+	}
+	m.resolved[method] = methods
+	m.lock.Unlock()
+	return methods, methods != nil
 }
 
 func (m *match) IsUpdatable(method string) bool {
-	methods, ok := m.methods[method]
+	methods, ok := m.lookup(method)
 	if !ok {
 		return false
 	}
@@ -146,7 +234,7 @@ func (m *match) IsUpdatable(method string) bool {
 }
 
 func (m *match) IsCacheable(method string) bool {
-	methods, ok := m.methods[method]
+	methods, ok := m.lookup(method)
 	if !ok {
 		return false
 	}
@@ -158,21 +246,134 @@ func (m *match) IsCacheable(method string) bool {
 	return true
 }
 
-func (m match) addMethod(method config.CacheMethod) {
+// Admit checks the response against admission rules of all method rules
+func (m *match) Admit(method string, resp requests.RPCResponse) error {
+	methods, _ := m.lookup(method)
+	for _, cm := range methods {
+		if err := cm.admission.admit(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RequiresAgreement reports whether the response should be confirmed by a mirror node before caching
+func (m *match) RequiresAgreement(method string) bool {
+	methods, _ := m.lookup(method)
+	for _, cm := range methods {
+		if cm.admission.requireAgreement {
+			return true
+		}
+	}
+	return false
+}
+
+// CanRead evaluates read conditions of all method rules
+func (m *match) CanRead(env expression.Env) bool {
+	methods, _ := m.lookup(env.Method)
+	for _, cm := range methods {
+		if !cm.conditions.canRead(env) {
+			return false
+		}
+	}
+	return true
+}
+
+// CanWrite evaluates write conditions of all method rules and returns the shortest TTL among them
+func (m *match) CanWrite(env expression.Env) (bool, time.Duration) {
+	methods, _ := m.lookup(env.Method)
+	var ttl time.Duration
+	for _, cm := range methods {
+		if cm.conditions.needsWriteEnv() && env.Size == 0 {
+			env.Size = resultSize(env.Result)
+		}
+		ok, methodTTL := cm.conditions.canWrite(env)
+		if !ok {
+			return false, 0
+		}
+		if methodTTL > 0 && (ttl == 0 || methodTTL < ttl) {
+			ttl = methodTTL
+		}
+	}
+	return true, ttl
+}
+
+func (m *match) addMethod(method config.CacheMethod) {
 	if !method.Enabled {
 		return
 	}
 	paramsInCacheName := method.ParamsInCacheByName
 	sort.Strings(paramsInCacheName)
-	m.methods[method.Name] = append(m.methods[method.Name], cacheMethod{
+	var paths []jsonpath.Path
+	for _, expr := range method.ParamsInCacheByPath {
+		p, err := jsonpath.Parse(expr)
+		if err != nil {
+			logger.Log.Errorf("Cannot parse cache key path for method %s: %v", method.Name, err)
+			return
+		}
+		paths = append(paths, p)
+	}
+	cond, err := newConditions(method)
+	if err != nil {
+		logger.Log.Errorf("Cannot compile cache conditions for method %s: %v", method.Name, err)
+		return
+	}
+	var paramsTemplate *templates.Params
+	if templates.IsTemplate(method.ParamsForRequest) {
+		if paramsTemplate, err = templates.Compile(method.ParamsForRequest); err != nil {
+			logger.Log.Errorf("Cannot compile params template for method %s: %v", method.Name, err)
+			return
+		}
+	}
+	schedule, err := method.Schedule()
+	if err != nil {
+		logger.Log.Errorf("Cannot parse refresh schedule for method %s: %v", method.Name, err)
+		return
+	}
+	cm := cacheMethod{
 		kind:              *method.Kind,
 		name:              method.Name,
 		cacheByParams:     method.CacheByParams,
 		paramsInCacheID:   method.ParamsInCacheByID,
 		paramsInCacheName: paramsInCacheName,
+		paramsInCachePath: paths,
 		noStoreCache:      method.NoStoreCache,
 		noUpdateCache:     method.NoUpdateCache,
 		paramsForRequest:  method.ParamsForRequest,
+		paramsTemplate:    paramsTemplate,
+		admission:         newAdmission(method.Admission),
+		conditions:        cond,
+	}
+	if schedule != nil {
+		cm.refresh = &Refresh{
+			ID:       len(m.refreshes),
+			Name:     method.Name,
+			Custom:   method.Kind.IsCustom(),
+			Schedule: schedule,
+			Jitter:   time.Duration(method.RefreshJitter) * time.Second,
+		}
+		m.refreshes = append(m.refreshes, cm.refresh)
+	}
+	if method.NameMatch == "" || method.NameMatch.IsExact() {
+		m.methods[method.Name] = append(m.methods[method.Name], cm)
+		return
+	}
+	for _, rule := range m.patterns {
+		if rule.pattern == method.Name {
+			rule.methods = append(rule.methods, cm)
+			return
+		}
+	}
+	matchFunc, err := method.NameMatch.Compile(method.Name)
+	if err != nil {
+		logger.Log.Errorf("Cannot compile method pattern: %v", err)
+		return
+	}
+	m.patterns = append(m.patterns, &patternRule{
+		pattern:  method.Name,
+		match:    matchFunc,
+		priority: method.Priority,
+		methods:  cacheMethods{cm},
 	})
 }
 
@@ -183,11 +384,14 @@ func FromConfig(c *config.Config) *match {
 	for _, method := range c.CacheMethods {
 		matcher.addMethod(method)
 	}
+	sort.SliceStable(matcher.patterns, func(i, j int) bool {
+		return matcher.patterns[i].priority > matcher.patterns[j].priority
+	})
 	return matcher
 }
 
-func (m match) Keys(method string, params interface{}) cacheKeys {
-	cacheMethods, ok := m.methods[method]
+func (m *match) Keys(method string, params interface{}) cacheKeys {
+	cacheMethods, ok := m.lookup(method)
 	if !ok {
 		return nil
 	}
@@ -203,7 +407,7 @@ func (m match) Keys(method string, params interface{}) cacheKeys {
 	return keys
 }
 
-func (m match) Methods() customMethods {
+func (m *match) Methods() CustomMethods {
 	return m.methods.Custom()
 }
 
@@ -213,8 +417,17 @@ func interfaceSliceToString(params []interface{}) string {
 	}
 	hash := sha256.New()
 	for _, ifs := range params {
-		value, _ := json.Marshal(ifs)
-		_, _ = hash.Write(value)
+		_, _ = hash.Write(canonicalJSON(ifs))
 	}
 	return fmt.Sprintf("%x", hash.Sum(nil))
 }
+
+// canonicalJSON encodes the value with sorted object keys.
+// Values decoded from yaml config are normalized to produce the same keys as the values decoded from json
+func canonicalJSON(v interface{}) []byte {
+	value, err := json.Marshal(utils.NormalizeYAML(v))
+	if err != nil {
+		logger.Log.Errorf("Cannot encode cache key params: %v", err)
+	}
+	return value
+}
@@ -4,8 +4,12 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/expression"
 	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
 	"go.uber.org/goleak"
 
 	"github.com/stretchr/testify/require"
@@ -29,7 +33,7 @@ func TestMatcherNoCacheParams(t *testing.T) {
 	params := []interface{}{"1", "2", "3"}
 	keys := matcherImp.Keys(testMethod, params)
 	require.Len(t, keys, 1)
-	require.Equal(t, "test", keys[0].Key)
+	require.Equal(t, KeyPrefix+"test", keys[0].Key)
 }
 
 func TestMatcherCacheParamsByID(t *testing.T) {
@@ -43,7 +47,7 @@ func TestMatcherCacheParamsByID(t *testing.T) {
 	keys := matcherImp.Keys(testMethod, params)
 	require.Len(t, keys, 1)
 	parts := strings.Split(keys[0].Key, "_")
-	require.Equal(t, "test", parts[0])
+	require.Equal(t, KeyPrefix+"test", parts[0])
 	require.Len(t, parts, 2)
 }
 
@@ -58,7 +62,7 @@ func TestMatcherCacheParamsByName(t *testing.T) {
 	keys := matcherImp.Keys(testMethod, params)
 	require.Len(t, keys, 1)
 	parts := strings.Split(keys[0].Key, "_")
-	require.Equal(t, "test", parts[0])
+	require.Equal(t, KeyPrefix+"test", parts[0])
 	require.Len(t, parts, 2)
 }
 
@@ -95,7 +99,7 @@ func TestMatcherCacheParamsByNameAndByID(t *testing.T) {
 	keys := matcherImp.Keys(testMethod, params)
 	require.Len(t, keys, 1)
 	parts := strings.Split(keys[0].Key, "_")
-	require.Equal(t, "test", parts[0])
+	require.Equal(t, KeyPrefix+"test", parts[0])
 	require.Len(t, parts, 2)
 }
 
@@ -106,3 +110,144 @@ func TestKeys(t *testing.T) {
 	require.Equal(t, "2", allKeys[1].Key)
 	require.Equal(t, "1", allKeys[2].Key)
 }
+
+func TestMatcherAdmit(t *testing.T) {
+	matcherImp := newMatcher()
+	matcherImp.methods[testMethod] = append(matcherImp.methods[testMethod], cacheMethod{
+		admission: newAdmission(config.CacheAdmission{
+			SkipNull:     true,
+			SkipEmpty:    true,
+			RequirePaths: []string{"$.Cids[0]"},
+			MaxSize:      100,
+		}),
+	})
+	response := func(result interface{}) requests.RPCResponse {
+		return requests.RPCResponse{JSONRPC: "2.0", ID: 1, Result: result}
+	}
+	require.NoError(t, matcherImp.Admit(testMethod, response(map[string]interface{}{"Cids": []interface{}{"a"}})))
+	require.Error(t, matcherImp.Admit(testMethod, response(nil)))
+	require.Error(t, matcherImp.Admit(testMethod, response(map[string]interface{}{})))
+	require.Error(t, matcherImp.Admit(testMethod, response(map[string]interface{}{"Cids": []interface{}{}})))
+	require.Error(t, matcherImp.Admit(testMethod, response(map[string]interface{}{"Cids": []interface{}{strings.Repeat("a", 100)}})))
+	require.NoError(t, matcherImp.Admit("unknown", response(nil)))
+	require.False(t, matcherImp.RequiresAgreement(testMethod))
+}
+
+func TestMatcherConditions(t *testing.T) {
+	conf := &config.Config{CacheMethods: []config.CacheMethod{{
+		Name:          testMethod,
+		Enabled:       true,
+		CacheByParams: true,
+		ReadIf:        "len(params) > 0 && params[0] != ''",
+		WriteIf:       "size < 20 && caller != 'blocked'",
+		TTLExpr:       "height > 100 ? 30 : -1",
+	}}}
+	conf.Init()
+	matcherImp := FromConfig(conf)
+
+	require.True(t, matcherImp.CanRead(expression.Env{Method: testMethod, Params: []interface{}{"a"}}))
+	require.False(t, matcherImp.CanRead(expression.Env{Method: testMethod, Params: []interface{}{""}}))
+	require.False(t, matcherImp.CanRead(expression.Env{Method: testMethod}))
+	require.True(t, matcherImp.CanRead(expression.Env{Method: "unknown"}))
+
+	ok, ttl := matcherImp.CanWrite(expression.Env{Method: testMethod, Result: "short", Height: 101})
+	require.True(t, ok)
+	require.Equal(t, 30*time.Second, ttl)
+	ok, _ = matcherImp.CanWrite(expression.Env{Method: testMethod, Result: "short", Height: 99})
+	require.False(t, ok)
+	ok, _ = matcherImp.CanWrite(expression.Env{Method: testMethod, Result: strings.Repeat("a", 20), Height: 101})
+	require.False(t, ok)
+	ok, _ = matcherImp.CanWrite(expression.Env{Method: testMethod, Result: "short", Height: 101, Caller: "blocked"})
+	require.False(t, ok)
+}
+
+func TestMatcherCacheParamsByIDPositions(t *testing.T) {
+	matcherImp := newMatcher()
+	matcherImp.methods[testMethod] = append(matcherImp.methods[testMethod], cacheMethod{
+		cacheByParams:   true,
+		paramsInCacheID: []int{2},
+	})
+	keys1 := matcherImp.Keys(testMethod, []interface{}{"1", "2", "3"})
+	keys2 := matcherImp.Keys(testMethod, []interface{}{"1", "2", "4"})
+	keys3 := matcherImp.Keys(testMethod, []interface{}{"5", "6", "3"})
+	require.Len(t, keys1, 1)
+	require.NotEqual(t, keys1[0].Key, keys2[0].Key)
+	require.Equal(t, keys1[0].Key, keys3[0].Key)
+	require.Len(t, matcherImp.Keys(testMethod, []interface{}{"1", "2"}), 0)
+}
+
+func TestMatcherCacheParamsByNegativeID(t *testing.T) {
+	matcherImp := newMatcher()
+	matcherImp.methods[testMethod] = append(matcherImp.methods[testMethod], cacheMethod{
+		cacheByParams:   true,
+		paramsInCacheID: []int{-1},
+	})
+	_, err := matcherImp.methods[testMethod][0].match([]interface{}{"1"})
+	require.Error(t, err)
+	require.Len(t, matcherImp.Keys(testMethod, []interface{}{"1"}), 0)
+}
+
+func TestMatcherCacheParamsByPath(t *testing.T) {
+	conf := &config.Config{CacheMethods: []config.CacheMethod{{
+		Name:                testMethod,
+		Enabled:             true,
+		CacheByParams:       true,
+		ParamsInCacheByPath: []string{"$[0].To", "$[0].Method", "$[1][*]./"},
+	}}}
+	conf.Init()
+	matcherImp := FromConfig(conf)
+	message := func(to string, nonce int) map[string]interface{} {
+		return map[string]interface{}{"To": to, "Method": float64(2), "Nonce": float64(nonce)}
+	}
+	tipset := []interface{}{map[string]interface{}{"/": "a"}}
+
+	keys1 := matcherImp.Keys(testMethod, []interface{}{message("f01", 1), tipset})
+	keys2 := matcherImp.Keys(testMethod, []interface{}{message("f01", 2), tipset})
+	keys3 := matcherImp.Keys(testMethod, []interface{}{message("f02", 1), tipset})
+	keys4 := matcherImp.Keys(testMethod, []interface{}{message("f01", 1), []interface{}{}})
+	require.Len(t, keys1, 1)
+	require.Equal(t, 3, keys1[0].cardinality)
+	require.Equal(t, keys1[0].Key, keys2[0].Key)
+	require.NotEqual(t, keys1[0].Key, keys3[0].Key)
+	require.NotEqual(t, keys1[0].Key, keys4[0].Key)
+	require.Len(t, matcherImp.Keys(testMethod, []interface{}{"f01"}), 0)
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	fromYAML := map[interface{}]interface{}{"b": 1, "a": []interface{}{map[interface{}]interface{}{"/": "x"}}}
+	fromJSON := map[string]interface{}{"a": []interface{}{map[string]interface{}{"/": "x"}}, "b": float64(1)}
+	require.Equal(t, string(canonicalJSON(fromJSON)), string(canonicalJSON(fromYAML)))
+}
+
+func TestMatcherMethodPatterns(t *testing.T) {
+	conf := &config.Config{CacheMethods: []config.CacheMethod{
+		{Name: "Filecoin.ChainGet*", Enabled: true, NoUpdateCache: true},
+		{Name: "Filecoin.ChainGetTipSet*", Enabled: true, Priority: 10, NoStoreCache: true},
+		{Name: "eth_get.*ByHash", NameMatch: config.RegexMatch, Enabled: true},
+		{Name: "Filecoin.ChainGetBlock", Enabled: true},
+	}}
+	conf.Init()
+	require.Equal(t, config.GlobMatch, conf.CacheMethods[0].NameMatch)
+	require.Equal(t, config.ExactMatch, conf.CacheMethods[3].NameMatch)
+	matcherImp := FromConfig(conf)
+
+	// exact rule wins
+	require.True(t, matcherImp.IsCacheable("Filecoin.ChainGetBlock"))
+	require.True(t, matcherImp.IsUpdatable("Filecoin.ChainGetBlock"))
+	// glob rule
+	require.True(t, matcherImp.IsCacheable("Filecoin.ChainGetMessage"))
+	require.False(t, matcherImp.IsUpdatable("Filecoin.ChainGetMessage"))
+	// higher priority glob rule
+	require.False(t, matcherImp.IsCacheable("Filecoin.ChainGetTipSetByHeight"))
+	// regex rule
+	require.True(t, matcherImp.IsCacheable("eth_getBlockByHash"))
+	require.False(t, matcherImp.IsCacheable("eth_getBlockByNumber"))
+	require.False(t, matcherImp.IsCacheable("Filecoin.ChainHead"))
+
+	keys1 := matcherImp.Keys("Filecoin.ChainGetMessage", nil)
+	keys2 := matcherImp.Keys("Filecoin.ChainGetParentMessages", nil)
+	require.Len(t, keys1, 1)
+	require.Len(t, keys2, 1)
+	require.NotEqual(t, keys1[0].Key, keys2[0].Key)
+	require.Len(t, matcherImp.Methods(), 0)
+}
@@ -0,0 +1,37 @@
+package matcher
+
+import (
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Refresh is a refresh schedule of a cache rule
+type Refresh struct {
+	ID int
+	// Name is a method name or a pattern of the rule
+	Name     string
+	Custom   bool
+	Schedule cron.Schedule
+	Jitter   time.Duration
+}
+
+// Refreshes returns schedules of all scheduled rules
+func (m *match) Refreshes() []Refresh {
+	res := make([]Refresh, len(m.refreshes))
+	for idx, r := range m.refreshes {
+		res[idx] = *r
+	}
+	return res
+}
+
+// RefreshOf returns refresh schedule of the method. Methods without schedule are refreshed by the global updaters
+func (m *match) RefreshOf(method string) (Refresh, bool) {
+	methods, _ := m.lookup(method)
+	for _, cm := range methods {
+		if cm.refresh != nil {
+			return *cm.refresh, true
+		}
+	}
+	return Refresh{}, false
+}
@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -11,6 +13,46 @@ var (
 		Name:      "cache_size",
 		Help:      "The proxy cache size",
 	})
+	cacheRejectedByMethod = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "cache_rejected",
+		Help:      "The total number of responses not admitted to the cache by method",
+	}, labels)
+	updaterLeader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "proxy",
+		Name:      "updater_leader",
+		Help:      "Whether the replica is the updater leader",
+	})
+	updaterCycleDuration = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace: "proxy",
+		Name:      "updater_cycle_duration",
+		Help:      "The updater refresh cycle duration in milliseconds by refresh",
+	}, []string{"refresh"})
+	updaterBatchDuration = prometheus.NewSummary(prometheus.SummaryOpts{
+		Namespace: "proxy",
+		Name:      "updater_batch_duration",
+		Help:      "The updater batch request duration in milliseconds including retries",
+	})
+	updaterRefreshedByMethod = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "updater_refreshed",
+		Help:      "The total number of refreshed cache entries by method",
+	}, labels)
+	updaterFailedByMethod = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "updater_failed",
+		Help:      "The total number of failed cache entry refreshes by method",
+	}, labels)
+	updaterLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "proxy",
+		Name:      "updater_last_success",
+		Help:      "The unix time of the last successful update of the custom method",
+	}, labels)
+	updaterQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "proxy",
+		Name:      "updater_queue_depth",
+		Help:      "The number of refresh requests waiting to be sent",
+	})
 	proxyRequestDuration = prometheus.NewSummary(prometheus.SummaryOpts{
 		Namespace: "proxy",
 		Name:      "request_duration",
@@ -36,6 +78,16 @@ var (
 		Name:      "requests_method_cached",
 		Help:      "The total number of cached proxy requests by method",
 	}, labels)
+	rejectedProxyRequestsByMethod = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "requests_method_rejected",
+		Help:      "The total number of proxy requests rejected by the firewall",
+	}, labels)
+	invalidProxyRequestsByMethod = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "requests_method_invalid",
+		Help:      "The total number of proxy requests with invalid params",
+	}, labels)
 	errorProxyRequests = prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace: "proxy",
 		Name:      "requests_error",
@@ -46,6 +98,36 @@ var (
 		Name:      "requests_method_error",
 		Help:      "The total number of failed proxy requests",
 	}, labels)
+	canceledProxyRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "requests_canceled",
+		Help:      "The total number of proxy requests canceled by clients or by their deadline",
+	}, []string{"reason"})
+	coalescedProxyRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "requests_coalesced",
+		Help:      "The total number of proxy requests sharing an upstream request in flight",
+	})
+	hedgeableUpstreamRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "upstream_hedgeable",
+		Help:      "The total number of upstream requests eligible for hedging",
+	})
+	hedgedUpstreamRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "upstream_hedged",
+		Help:      "The total number of hedged duplicates of upstream requests",
+	})
+	hedgeWonUpstreamRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "proxy",
+		Name:      "upstream_hedge_won",
+		Help:      "The total number of hedged duplicates answered before the original requests",
+	})
+	upstreamMicroBatchSize = prometheus.NewSummary(prometheus.SummaryOpts{
+		Namespace: "proxy",
+		Name:      "upstream_micro_batch_size",
+		Help:      "The number of single client requests sent upstream in one batch",
+	})
 )
 
 // SetRequestDuration ...
@@ -58,6 +140,52 @@ func SetCacheSize(n int64) {
 	cacheSize.Set(float64(n))
 }
 
+// SetUpdaterLeader ...
+func SetUpdaterLeader(leader bool) {
+	if leader {
+		updaterLeader.Set(1)
+	} else {
+		updaterLeader.Set(0)
+	}
+}
+
+// SetUpdaterCycleDuration ...
+func SetUpdaterCycleDuration(refresh string, n int64) {
+	updaterCycleDuration.With(prometheus.Labels{"refresh": refresh}).Observe(float64(n))
+}
+
+// SetUpdaterBatchDuration ...
+func SetUpdaterBatchDuration(n int64) {
+	updaterBatchDuration.Observe(float64(n))
+}
+
+// SetUpdaterRefreshedCounterByMethod ...
+func SetUpdaterRefreshedCounterByMethod(method string) {
+	updaterRefreshedByMethod.With(prometheus.Labels{"method": method}).Inc()
+}
+
+// SetUpdaterFailedCounterByMethods ...
+func SetUpdaterFailedCounterByMethods(methods ...string) {
+	for _, method := range methods {
+		updaterFailedByMethod.With(prometheus.Labels{"method": method}).Inc()
+	}
+}
+
+// SetUpdaterLastSuccess ...
+func SetUpdaterLastSuccess(method string, t time.Time) {
+	updaterLastSuccess.With(prometheus.Labels{"method": method}).Set(float64(t.Unix()))
+}
+
+// AddUpdaterQueueDepth ...
+func AddUpdaterQueueDepth(n int) {
+	updaterQueueDepth.Add(float64(n))
+}
+
+// SetCacheRejectedCounterByMethod ...
+func SetCacheRejectedCounterByMethod(method string) {
+	cacheRejectedByMethod.With(prometheus.Labels{"method": method}).Inc()
+}
+
 // SetRequestsCounter ...
 func SetRequestsCounter() {
 	proxyRequests.Inc()
@@ -86,6 +214,20 @@ func SetRequestsErrorCounterByMethods(methods ...string) {
 	errorProxyRequests.Inc()
 }
 
+// SetRequestsRejectedCounterByMethods ...
+func SetRequestsRejectedCounterByMethods(methods ...string) {
+	for _, method := range methods {
+		rejectedProxyRequestsByMethod.With(prometheus.Labels{"method": method}).Inc()
+	}
+}
+
+// SetRequestsInvalidCounterByMethods ...
+func SetRequestsInvalidCounterByMethods(methods ...string) {
+	for _, method := range methods {
+		invalidProxyRequestsByMethod.With(prometheus.Labels{"method": method}).Inc()
+	}
+}
+
 // SetRequestsCachedCounter ...
 func SetRequestsCachedCounter(n int) {
 	cachedProxyRequests.Add(float64(n))
@@ -104,6 +246,36 @@ func SetRequestsCachedCounterByMethods(methods ...string) {
 	}
 }
 
+// SetRequestsCanceledCounter ...
+func SetRequestsCanceledCounter(reason string) {
+	canceledProxyRequests.With(prometheus.Labels{"reason": reason}).Inc()
+}
+
+// SetRequestsCoalescedCounter ...
+func SetRequestsCoalescedCounter() {
+	coalescedProxyRequests.Inc()
+}
+
+// SetUpstreamHedgeableCounter ...
+func SetUpstreamHedgeableCounter() {
+	hedgeableUpstreamRequests.Inc()
+}
+
+// SetUpstreamHedgedCounter ...
+func SetUpstreamHedgedCounter() {
+	hedgedUpstreamRequests.Inc()
+}
+
+// SetUpstreamHedgeWonCounter ...
+func SetUpstreamHedgeWonCounter() {
+	hedgeWonUpstreamRequests.Inc()
+}
+
+// SetUpstreamMicroBatchSize ...
+func SetUpstreamMicroBatchSize(n int) {
+	upstreamMicroBatchSize.Observe(float64(n))
+}
+
 // Register ...
 func Register() {
 	prometheus.MustRegister(proxyRequestDuration)
@@ -113,4 +285,20 @@ func Register() {
 	prometheus.MustRegister(cachedProxyRequestsByMethod)
 	prometheus.MustRegister(proxyRequests)
 	prometheus.MustRegister(proxyRequestsByMethod)
+	prometheus.MustRegister(rejectedProxyRequestsByMethod)
+	prometheus.MustRegister(invalidProxyRequestsByMethod)
+	prometheus.MustRegister(canceledProxyRequests)
+	prometheus.MustRegister(coalescedProxyRequests)
+	prometheus.MustRegister(hedgeableUpstreamRequests)
+	prometheus.MustRegister(hedgedUpstreamRequests)
+	prometheus.MustRegister(hedgeWonUpstreamRequests)
+	prometheus.MustRegister(upstreamMicroBatchSize)
+	prometheus.MustRegister(cacheRejectedByMethod)
+	prometheus.MustRegister(updaterLeader)
+	prometheus.MustRegister(updaterCycleDuration)
+	prometheus.MustRegister(updaterBatchDuration)
+	prometheus.MustRegister(updaterRefreshedByMethod)
+	prometheus.MustRegister(updaterFailedByMethod)
+	prometheus.MustRegister(updaterLastSuccess)
+	prometheus.MustRegister(updaterQueueDepth)
 }
@@ -2,18 +2,24 @@ package proxy
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
 
 	"github.com/stretchr/testify/require"
 )
@@ -44,6 +50,12 @@ func TestServerAuxiliaryFunc(t *testing.T) {
 			require.Equal(t, 200, resp.StatusCode)
 		})
 	}
+
+	// updater status exposes upstream errors to authenticated clients only
+	resp, err := http.Get(fmt.Sprintf("%s/status/updater", s.URL))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 }
 
 func TestServerJWTAuthFunc401(t *testing.T) {
@@ -107,3 +119,227 @@ func TestServerJWTAuthFunc(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 200, resp.StatusCode)
 }
+
+func TestServerJWTAuthExpiration(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfig(backend.URL, testMethod)
+	require.NoError(t, err)
+	server, err := FromConfig(context.Background(), conf)
+	require.NoError(t, err)
+	frontend := httptest.NewServer(PrepareRoutes(conf, logger.Log, server))
+	defer frontend.Close()
+
+	for expiresAt, code := range map[time.Time]int{
+		time.Now().Add(time.Hour):  http.StatusOK,
+		time.Now().Add(-time.Hour): http.StatusUnauthorized,
+	} {
+		jwtToken, err := auth.NewToken(conf.JWT(), conf.JWTAlgorithm, auth.Claims{
+			Allow:     conf.JWTPermissions,
+			ExpiresAt: expiresAt.Unix(),
+		})
+		require.NoError(t, err)
+		req, err := http.NewRequest("GET", frontend.URL+"/test", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", jwtToken))
+		resp, err := (&http.Client{}).Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		require.Equal(t, code, resp.StatusCode)
+	}
+}
+
+func TestServerJWTRevocationAndScope(t *testing.T) {
+	var backendCalls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&backendCalls, 1)
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":"ok"}`)
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfig(backend.URL, testMethod)
+	require.NoError(t, err)
+	conf.Tokens.Revoked = []string{"revoked"}
+	server, err := FromConfig(context.Background(), conf)
+	require.NoError(t, err)
+	frontend := httptest.NewServer(PrepareRoutes(conf, logger.Log, server))
+	defer frontend.Close()
+
+	call := func(claims auth.Claims, method string) (*http.Response, requests.RPCResponse) {
+		jwtToken, err := auth.NewToken(conf.JWT(), conf.JWTAlgorithm, claims)
+		require.NoError(t, err)
+		body := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"%s","params":[]}`, method)
+		req, err := http.NewRequest("POST", frontend.URL, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", jwtToken))
+		resp, err := (&http.Client{}).Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		rpcResponse := requests.RPCResponse{}
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&rpcResponse))
+		}
+		return resp, rpcResponse
+	}
+
+	resp, _ := call(auth.Claims{Allow: conf.JWTPermissions, ID: "revoked"}, "Filecoin.ChainHead")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	scoped := auth.Claims{Allow: conf.JWTPermissions, ID: "scoped", Methods: []string{"Filecoin.Chain*"}}
+	resp, rpcResponse := call(scoped, "Filecoin.StateCall")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotNil(t, rpcResponse.Error)
+	require.Equal(t, int32(0), atomic.LoadInt32(&backendCalls))
+
+	resp, rpcResponse = call(scoped, "Filecoin.ChainHead")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Nil(t, rpcResponse.Error)
+	require.Equal(t, "ok", rpcResponse.Result)
+	require.Equal(t, int32(1), atomic.LoadInt32(&backendCalls))
+}
+
+func TestServerAPIKeyAuthFunc(t *testing.T) {
+	apiKey := "key"
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		assert.Empty(t, r.Header.Get("X-API-Key"))
+		assert.Empty(t, r.URL.Query().Get("token"))
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfig(backend.URL, testMethod)
+	require.NoError(t, err)
+	conf.RateLimitTiers = []config.RateLimitTier{{Name: "basic", RequestsPerSecond: 0.001, Burst: 2}}
+	conf.APIKeys.Keys = []config.APIKey{{Name: "script", Hash: auth.HashAPIKey(apiKey), Tier: "basic"}}
+	require.NoError(t, conf.Validate())
+
+	ctx := context.Background()
+	server, err := FromConfig(ctx, conf)
+	require.NoError(t, err)
+	handler := PrepareRoutes(conf, logger.Log, server)
+	frontend := httptest.NewServer(handler)
+	defer frontend.Close()
+
+	cases := []struct {
+		name   string
+		url    string
+		header string
+		status int
+	}{
+		{name: "header", url: fmt.Sprintf("%s/test", frontend.URL), header: apiKey, status: http.StatusOK},
+		{name: "query", url: fmt.Sprintf("%s/test?token=%s", frontend.URL, apiKey), status: http.StatusOK},
+		{name: "rate_limited", url: fmt.Sprintf("%s/test?token=%s", frontend.URL, apiKey), status: http.StatusTooManyRequests},
+		{name: "wrong_key", url: fmt.Sprintf("%s/test", frontend.URL), header: "wrong", status: http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		req, err := http.NewRequest("GET", c.url, nil)
+		require.NoError(t, err)
+		if c.header != "" {
+			req.Header.Set("X-API-Key", c.header)
+		}
+		resp, err := (&http.Client{}).Do(req)
+		require.NoError(t, err, c.name)
+		_ = resp.Body.Close()
+		require.Equal(t, c.status, resp.StatusCode, c.name)
+	}
+}
+
+func TestServerDefaultRateLimitTier(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfig(backend.URL, testMethod)
+	require.NoError(t, err)
+	conf.RateLimitTiers = []config.RateLimitTier{{Name: "basic", RequestsPerSecond: 0.001, Burst: 1}}
+	conf.DefaultRateLimitTier = "basic"
+	require.NoError(t, conf.Validate())
+	// tokens without sub, jti and tier claims
+	readToken, err := auth.NewJWT(conf.JWT(), conf.JWTAlgorithm, []string{"read"})
+	require.NoError(t, err)
+	writeToken, err := auth.NewJWT(conf.JWT(), conf.JWTAlgorithm, []string{"read", "write"})
+	require.NoError(t, err)
+
+	server, err := FromConfig(context.Background(), conf)
+	require.NoError(t, err)
+	frontend := httptest.NewServer(PrepareRoutes(conf, logger.Log, server))
+	defer frontend.Close()
+
+	for idx, c := range []struct {
+		token  []byte
+		status int
+	}{
+		{token: readToken, status: http.StatusOK},
+		{token: readToken, status: http.StatusTooManyRequests},
+		{token: writeToken, status: http.StatusOK},
+	} {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/test", frontend.URL), nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
+		resp, err := (&http.Client{}).Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		require.Equal(t, c.status, resp.StatusCode, idx)
+	}
+}
+
+func TestServerUpstreamToken(t *testing.T) {
+	upstreamToken := "lotus"
+
+	var upstreamRequests int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&upstreamRequests, 1)
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		assert.Equal(t, fmt.Sprintf("Bearer %s", upstreamToken), r.Header.Get("Authorization"))
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfig(backend.URL, testMethod)
+	require.NoError(t, err)
+	conf.UpstreamToken = upstreamToken
+	jwtToken, err := auth.NewJWT(conf.JWT(), conf.JWTAlgorithm, conf.JWTPermissions)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	server, err := FromConfig(ctx, conf)
+	require.NoError(t, err)
+	handler := PrepareRoutes(conf, logger.Log, server)
+	frontend := httptest.NewServer(handler)
+	defer frontend.Close()
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/%s", frontend.URL, "test"), nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", jwtToken))
+
+	resp, err := (&http.Client{}).Do(req)
+	require.NoError(t, err)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, int32(1), atomic.LoadInt32(&upstreamRequests))
+
+	// read-only client does not get write permission of the upstream token
+	responses, _, err := requests.Request(ctx, frontend.URL, string(jwtToken), logger.Log, false, false, requests.RPCRequests{{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  "Filecoin.MpoolPush",
+	}})
+	require.NoError(t, err)
+	require.Len(t, responses, 1)
+	require.NotNil(t, responses[0].Error)
+	require.Contains(t, responses[0].Error.Error(), "need 'write'")
+	require.Equal(t, int32(1), atomic.LoadInt32(&upstreamRequests))
+}
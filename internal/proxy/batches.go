@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/metrics"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/utils"
+)
+
+// sendBatches splits requests into sub-batches of max batch size sent to upstream concurrently.
+// Returns responses in the order of requests. Requests of failed sub-batches get error responses
+func (t *transport) sendBatches(req *http.Request, reqs requests.RPCRequests) requests.RPCResponses {
+	responses := make(requests.RPCResponses, len(reqs))
+	sem := make(chan struct{}, t.batchConcurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for start := 0; start < len(reqs); start += t.maxBatchSize {
+		select {
+		case sem <- struct{}{}:
+		case <-req.Context().Done():
+			return responses
+		}
+		end := utils.Min(start+t.maxBatchSize, len(reqs))
+		wg.Add(1)
+		go func(batch requests.RPCRequests, responses requests.RPCResponses) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			t.sendBatch(req, batch, responses)
+		}(reqs[start:end], responses[start:end])
+	}
+	return responses
+}
+
+// sendBatch sends the sub-batch and sets responses by positions of the requests
+func (t *transport) sendBatch(req *http.Request, batch requests.RPCRequests, responses requests.RPCResponses) {
+	received, err := t.requestBatch(req, batch)
+	if err != nil {
+		t.logger.Errorf("Cannot send batch of %d requests: %v", len(batch), err)
+		metrics.SetRequestsErrorCounterByMethods(batch.Methods()...)
+	}
+	for _, response := range received {
+		if idx, ok := batch.FindPositionByID(response.ID); ok {
+			responses[idx] = response
+		}
+	}
+	for idx, request := range batch {
+		if !responses[idx].IsEmpty() {
+			continue
+		}
+		msg := "no upstream response"
+		if err != nil {
+			msg = err.Error()
+		}
+		responses[idx] = requests.InternalErrorResponse(request.ID, msg)
+	}
+}
+
+// requestBatch sends the sub-batch with the headers of the client request
+func (t *transport) requestBatch(req *http.Request, batch requests.RPCRequests) (requests.RPCResponses, error) {
+	var payload interface{} = batch
+	if len(batch) == 1 {
+		payload = batch[0]
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	batchReq := req.Clone(req.Context())
+	batchReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+	batchReq.ContentLength = int64(len(body))
+	if t.debugHTTPRequest {
+		requests.DebugRequest(batchReq, t.logger)
+	}
+	var res *http.Response
+	if t.isCacheableRequests(batch) {
+		res, err = t.sendShared(batchReq, body, batch.Methods())
+	} else {
+		res, err = t.upstream.Send(batchReq, batch.Methods()...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.debugHTTPResponse {
+		requests.DebugResponse(res, t.logger)
+	}
+	responses, data, err := requests.ParseResponses(res)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse upstream response. status code: %d: %w", res.StatusCode, err)
+	}
+	if len(responses) == 0 && res.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("upstream status code: %d. response: %s", res.StatusCode, data)
+	}
+	return responses, nil
+}
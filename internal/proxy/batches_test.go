@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportSplitsBatches(t *testing.T) {
+	lock := sync.Mutex{}
+	var sizes []int
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs, err := requests.ParseRequests(r)
+		require.NoError(t, err)
+		lock.Lock()
+		sizes = append(sizes, len(reqs))
+		lock.Unlock()
+		if reqs[0].Method == "fail" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		// responses in the reverse order
+		responses := make(requests.RPCResponses, 0, len(reqs))
+		for idx := len(reqs) - 1; idx >= 0; idx-- {
+			responses = append(responses, requests.RPCResponse{
+				JSONRPC: "2.0",
+				ID:      reqs[idx].ID,
+				Result:  reqs[idx].ID,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if len(responses) == 1 {
+			require.NoError(t, json.NewEncoder(w).Encode(responses[0]))
+			return
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(responses))
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfig(backend.URL)
+	require.NoError(t, err)
+	conf.Upstream.MaxBatchSize = 3
+	conf.Upstream.BatchConcurrency = 2
+	server, err := FromConfig(context.Background(), conf)
+	require.NoError(t, err)
+	frontend := httptest.NewServer(PrepareRoutes(conf, logger.Log, server))
+	defer frontend.Close()
+	token, err := auth.NewJWT(conf.JWT(), conf.JWTAlgorithm, conf.JWTPermissions)
+	require.NoError(t, err)
+
+	reqs := requests.RPCRequests{}
+	for id := 1; id <= 7; id++ {
+		method := "Filecoin.ChainHead"
+		if id == 7 {
+			method = "fail"
+		}
+		reqs = append(reqs, requests.RPCRequest{JSONRPC: "2.0", ID: float64(id), Method: method})
+	}
+	responses, _, err := requests.Request(context.Background(), frontend.URL, string(token), logger.Log, false, false, reqs)
+	require.NoError(t, err)
+	require.Len(t, responses, len(reqs))
+	for idx, response := range responses[:6] {
+		require.Nil(t, response.Error)
+		require.Equal(t, reqs[idx].ID, response.ID)
+		require.Equal(t, reqs[idx].ID, response.Result)
+	}
+	require.Equal(t, reqs[6].ID, responses[6].ID)
+	require.NotNil(t, responses[6].Error)
+
+	sort.Ints(sizes)
+	require.Equal(t, []int{1, 3, 3}, sizes)
+}
@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/compression"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+)
+
+// Compressor compresses responses with the encoding negotiated with Accept-Encoding header.
+// Responses already having Content-Encoding are passed as is
+func Compressor(settings config.CompressionSettings) func(http.Handler) http.Handler {
+	encodings := compression.Encodings(settings.Encodings)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !settings.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			encoding := compression.Negotiate(r.Header.Get("Accept-Encoding"), encodings)
+			w.Header().Add("Vary", "Accept-Encoding")
+			// upstream responses are decompressed by the transport
+			r.Header.Del("Accept-Encoding")
+			if encoding == compression.Identity {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{
+				ResponseWriter: w,
+				encoding:       encoding,
+				minSize:        settings.MinSize,
+			}
+			next.ServeHTTP(cw, r.WithContext(compression.WithEncoding(r.Context(), encoding)))
+			_ = cw.Close()
+		})
+	}
+}
+
+// compressWriter buffers the response until min size is reached and then compresses it
+type compressWriter struct {
+	http.ResponseWriter
+	encoding compression.Encoding
+	minSize  int
+	status   int
+	buf      []byte
+	started  bool
+	writer   io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.started {
+		if w.writer != nil {
+			return w.writer.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < w.minSize {
+		return len(p), nil
+	}
+	if err := w.start(true); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *compressWriter) start(compress bool) error {
+	w.started = true
+	header := w.Header()
+	if compress && header.Get("Content-Encoding") == "" {
+		writer, err := compression.NewWriter(w.encoding, w.ResponseWriter)
+		if err != nil {
+			return err
+		}
+		w.writer = writer
+		header.Set("Content-Encoding", string(w.encoding))
+		header.Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if w.writer != nil {
+		_, err := w.writer.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// Close writes the response below min size uncompressed and finishes the compressed one
+func (w *compressWriter) Close() error {
+	if !w.started {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		return w.start(false)
+	}
+	if w.writer != nil {
+		return w.writer.Close()
+	}
+	return nil
+}
+
+// encodedResponse builds the compressed response reusing results compressed in the cache
+func encodedResponse(encoding compression.Encoding, responses requests.RPCResponses, encodedResults map[int][]byte) (*http.Response, error) {
+	var chunks []compression.Chunk
+	raw := &bytes.Buffer{}
+	if len(responses) > 1 {
+		raw.WriteByte('[')
+	}
+	for idx, response := range responses {
+		if idx > 0 {
+			raw.WriteByte(',')
+		}
+		encoded, ok := encodedResults[idx]
+		if !ok {
+			data, err := json.Marshal(response)
+			if err != nil {
+				return nil, err
+			}
+			raw.Write(data)
+			continue
+		}
+		// the result goes right after the id in the response layout
+		envelope, err := json.Marshal(requests.RPCResponse{JSONRPC: response.JSONRPC, ID: response.ID})
+		if err != nil {
+			return nil, err
+		}
+		raw.Write(envelope[:len(envelope)-1])
+		raw.WriteString(`,"result":`)
+		chunks = append(chunks, compression.Chunk{Raw: raw.Bytes()}, compression.Chunk{Encoded: encoded})
+		raw = bytes.NewBufferString("}")
+	}
+	if len(responses) > 1 {
+		raw.WriteByte(']')
+	}
+	chunks = append(chunks, compression.Chunk{Raw: raw.Bytes()})
+	body, err := compression.Join(encoding, chunks...)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		StatusCode: http.StatusOK,
+		Header: map[string][]string{
+			"Content-Type":     {"application/json"},
+			"Content-Encoding": {string(encoding)},
+		},
+	}, nil
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
+	"github.com/protofire/filecoin-rpc-proxy/internal/compression"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+)
+
+func TestServerCompression(t *testing.T) {
+	result := strings.Repeat("deal", 1000)
+	var backendCalls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&backendCalls, 1)
+		// client encodings are not forwarded, the transport negotiates gzip on its own
+		assert.NotContains(t, r.Header.Get("Accept-Encoding"), "zstd")
+		request := requests.RPCRequest{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		response := requests.RPCResponse{JSONRPC: "2.0", ID: request.ID, Result: result}
+		if request.Method != method {
+			response.Result = "small"
+		}
+		w.Header().Add("Content-Type", "application/json")
+		assert.NoError(t, json.NewEncoder(w).Encode(response))
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfig(backend.URL, method)
+	require.NoError(t, err)
+	conf.Compression.Encodings = []string{"zstd", "br", "gzip"}
+	conf.Compression.CacheCompressed = true
+	conf.Firewall.Deny = []string{"denied"}
+	require.NoError(t, conf.Validate())
+	jwtToken, err := auth.NewJWT(conf.JWT(), conf.JWTAlgorithm, conf.JWTPermissions)
+	require.NoError(t, err)
+
+	server, err := FromConfig(context.Background(), conf)
+	require.NoError(t, err)
+	frontend := httptest.NewServer(PrepareRoutes(conf, logger.Log, server))
+	defer frontend.Close()
+
+	call := func(body, acceptEncoding string) (string, []byte) {
+		req, err := http.NewRequest("POST", frontend.URL, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", jwtToken))
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		resp, err := (&http.Client{}).Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		data, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		encoding := resp.Header.Get("Content-Encoding")
+		if encoding != "" {
+			data, err = compression.Decode(compression.Encoding(encoding), data)
+			require.NoError(t, err)
+		}
+		return encoding, data
+	}
+	request := func(id int, method string) string {
+		return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"%s","params":["1"]}`, id, method)
+	}
+
+	for idx, encoding := range []string{"gzip", "br", "zstd", "gzip"} {
+		responseEncoding, data := call(request(idx+1, method), encoding)
+		require.Equal(t, encoding, responseEncoding)
+		response := requests.RPCResponse{}
+		require.NoError(t, json.Unmarshal(data, &response))
+		require.Equal(t, float64(idx+1), response.ID)
+		require.Equal(t, result, response.Result)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&backendCalls))
+
+	// batch served from the cache joins compressed results with the error response
+	responseEncoding, data := call("["+request(10, method)+","+request(11, "denied")+"]", "zstd")
+	require.Equal(t, "zstd", responseEncoding)
+	responses := requests.RPCResponses{}
+	require.NoError(t, json.Unmarshal(data, &responses))
+	require.Len(t, responses, 2)
+	require.Equal(t, result, responses[0].Result)
+	require.NotNil(t, responses[1].Error)
+	require.Equal(t, int32(1), atomic.LoadInt32(&backendCalls))
+
+	// responses below min size are not compressed
+	responseEncoding, data = call(request(20, "other"), "gzip")
+	require.Empty(t, responseEncoding)
+	require.Contains(t, string(data), "small")
+
+	responseEncoding, _ = call(request(21, method), "deflate")
+	require.Empty(t, responseEncoding)
+}
+
+func TestEncodedResponse(t *testing.T) {
+	part, err := compression.EncodePart(compression.Gzip, []byte(`{"a":1}`))
+	require.NoError(t, err)
+	responses := requests.RPCResponses{{JSONRPC: "2.0", ID: "x", Result: map[string]interface{}{"a": 1}}}
+	resp, err := encodedResponse(compression.Gzip, responses, map[int][]byte{0: part})
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	data, err = compression.Decode(compression.Gzip, data)
+	require.NoError(t, err)
+	expected, err := json.Marshal(responses[0])
+	require.NoError(t, err)
+	require.Equal(t, string(expected), string(data))
+}
@@ -2,8 +2,10 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -11,8 +13,15 @@ import (
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
 
+	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
 	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/compression"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/firewall"
 	"github.com/protofire/filecoin-rpc-proxy/internal/metrics"
+	"github.com/protofire/filecoin-rpc-proxy/internal/upstream"
+	"github.com/protofire/filecoin-rpc-proxy/internal/utils"
+	"github.com/protofire/filecoin-rpc-proxy/internal/validator"
 	"github.com/sirupsen/logrus"
 
 	"github.com/go-chi/chi/middleware"
@@ -22,8 +31,19 @@ type transport struct {
 	logger            *logrus.Entry
 	cacher            ResponseCacher
 	proxyURL          *url.URL
+	upstream          *upstream.Client
+	upstreamToken     auth.TokenSource
+	permissions       *auth.MethodPermissions
+	firewall          *firewall.Firewall
+	validator         *validator.Validator
+	inflight          *inflight
+	hedger            *hedger
+	microBatcher      *microBatcher
 	debugHTTPRequest  bool
 	debugHTTPResponse bool
+	// maxBatchSize of upstream requests. 0 means batches are not split
+	maxBatchSize     int
+	batchConcurrency int
 }
 
 // nolint
@@ -31,11 +51,44 @@ func NewTransport(cacher ResponseCacher, logger *logrus.Entry, debugHTTPRequest,
 	return &transport{
 		logger:            logger,
 		cacher:            cacher,
+		upstream:          upstream.Default(),
+		permissions:       auth.NewMethodPermissions(nil),
+		firewall:          firewall.New(config.FirewallSettings{}),
+		validator:         &validator.Validator{},
+		inflight:          newInflight(),
 		debugHTTPRequest:  debugHTTPRequest,
 		debugHTTPResponse: debugHttpResponse,
 	}
 }
 
+// TransportFromConfig initializes transport from config
+// nolint
+func TransportFromConfig(c *config.Config, cacher ResponseCacher, client *upstream.Client, logger *logrus.Entry) (*transport, error) {
+	t := NewTransport(cacher, logger, c.DebugHTTPRequest, c.DebugHTTPResponse)
+	upstreamToken, err := auth.UpstreamTokenFromConfig(c)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize upstream token: %w", err)
+	}
+	t.upstreamToken = upstreamToken
+	t.permissions = auth.MethodPermissionsFromConfig(c)
+	t.upstream = client
+	t.firewall = firewall.FromConfig(c)
+	paramsValidator, err := validator.FromConfig(c)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize params validation: %w", err)
+	}
+	t.validator = paramsValidator
+	hedger, err := newHedger(c.Hedging, c.ProxyMirrorURLs)
+	if err != nil {
+		return nil, err
+	}
+	t.hedger = hedger
+	t.maxBatchSize = c.Upstream.MaxBatchSize
+	t.batchConcurrency = c.Upstream.BatchConcurrency
+	t.microBatcher = newMicroBatcher(c.MicroBatching, logger, t.requestBatch)
+	return t, nil
+}
+
 func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	metrics.SetRequestsCounter()
 	log := t.logger
@@ -55,23 +108,66 @@ func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
 		}
 		return resp, nil
 	}
+	identity, authenticated := auth.IdentityFromContext(req.Context())
+	if identity.Name != "" {
+		parsedRequests.SetCaller(identity.Name)
+	}
 	methods := parsedRequests.Methods()
 	log = log.WithField("methods", methods)
 	for _, method := range methods {
 		metrics.SetRequestsCounterByMethod(method)
 	}
 
-	preparedResponses, err := t.fromCache(parsedRequests)
+	if err := t.firewall.CheckBatch(parsedRequests); err != nil {
+		log.Errorf("Rejected request: %v", err)
+		metrics.SetRequestsRejectedCounterByMethods(methods...)
+		resp, err := requests.JSONInvalidRequest(err.Error())
+		if err != nil {
+			log.Errorf("Failed to prepare error response: %v", err)
+			return nil, err
+		}
+		return resp, nil
+	}
+
+	preparedResponses, rejectedRequestIdx := t.firewall.Filter(parsedRequests)
+	if len(rejectedRequestIdx) > 0 {
+		rejectedMethods := parsedRequests.FindByPositions(rejectedRequestIdx...).Methods()
+		log.Infof("Rejected methods: %v", rejectedMethods)
+		metrics.SetRequestsRejectedCounterByMethods(rejectedMethods...)
+	}
+	// permissions are checked before the client credentials are replaced by the upstream token
+	if authenticated {
+		if deniedIdx := t.permissions.Filter(identity.Permissions, parsedRequests, preparedResponses); len(deniedIdx) > 0 {
+			deniedMethods := parsedRequests.FindByPositions(deniedIdx...).Methods()
+			log.Infof("Methods not allowed by client permissions: %v", deniedMethods)
+			metrics.SetRequestsRejectedCounterByMethods(deniedMethods...)
+			rejectedRequestIdx = append(rejectedRequestIdx, deniedIdx...)
+		}
+	}
+	if outOfScopeIdx := firewall.FilterScope(identity.Methods, parsedRequests, preparedResponses); len(outOfScopeIdx) > 0 {
+		outOfScopeMethods := parsedRequests.FindByPositions(outOfScopeIdx...).Methods()
+		log.Infof("Methods out of the token scope: %v", outOfScopeMethods)
+		metrics.SetRequestsRejectedCounterByMethods(outOfScopeMethods...)
+		rejectedRequestIdx = append(rejectedRequestIdx, outOfScopeIdx...)
+	}
+	if invalidRequestIdx := t.validator.Filter(parsedRequests, preparedResponses); len(invalidRequestIdx) > 0 {
+		invalidMethods := parsedRequests.FindByPositions(invalidRequestIdx...).Methods()
+		log.Infof("Invalid params for methods: %v", invalidMethods)
+		metrics.SetRequestsInvalidCounterByMethods(invalidMethods...)
+		rejectedRequestIdx = append(rejectedRequestIdx, invalidRequestIdx...)
+	}
+
+	encoding := compression.EncodingFromContext(req.Context())
+	encodedResults, err := t.fromCache(req.Context(), parsedRequests, preparedResponses, encoding)
 	if err != nil {
 		log.Errorf("Cannot build prepared responses: %v", err)
-		preparedResponses = make(requests.RPCResponses, len(parsedRequests))
 	}
 
-	cachedRequestIdx, proxyRequestIdx := preparedResponses.SplitEmptyResponsePositions()
+	preparedRequestIdx, proxyRequestIdx := preparedResponses.SplitEmptyResponsePositions()
 
 	// build requests to proxy
 	proxyRequests := parsedRequests.FindByPositions(proxyRequestIdx...)
-	cachedRequests := parsedRequests.FindByPositions(cachedRequestIdx...)
+	cachedRequests := parsedRequests.FindByPositions(utils.Difference(preparedRequestIdx, rejectedRequestIdx)...)
 	cachedMethods := cachedRequests.Methods()
 
 	if len(cachedRequests) > 0 {
@@ -82,6 +178,13 @@ func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	switch len(proxyRequests) {
 	case 0:
 		log.Debug("returning proxy response...")
+		if len(encodedResults) > 0 {
+			resp, err := encodedResponse(encoding, preparedResponses, encodedResults)
+			if err == nil {
+				return resp, nil
+			}
+			log.Errorf("Cannot prepare compressed response from cached responses: %v", err)
+		}
 		return preparedResponses.Response()
 	case 1:
 		proxyBody, err = json.Marshal(proxyRequests[0])
@@ -95,13 +198,61 @@ func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	req.Body = ioutil.NopCloser(bytes.NewBuffer(proxyBody))
 	req.ContentLength = int64(len(proxyBody))
 	req.Host = t.proxyURL.Host
+	if t.upstreamToken != nil {
+		token, err := t.upstreamToken.Token()
+		if err != nil {
+			log.Errorf("Cannot get upstream token: %v", err)
+			metrics.SetRequestsErrorCounterByMethods(methods...)
+			return nil, err
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	if t.maxBatchSize > 0 && len(proxyRequests) > t.maxBatchSize {
+		log.Debugf("Forwarding %d requests in batches of %d...", len(proxyRequests), t.maxBatchSize)
+		responses := t.sendBatches(req, proxyRequests)
+		metrics.SetRequestDuration(time.Since(start).Milliseconds())
+		if err := canceled(req.Context(), log); err != nil {
+			return nil, err
+		}
+		t.cacheResponses(req.Context(), parsedRequests, responses)
+		for idx, response := range responses {
+			preparedResponses[proxyRequestIdx[idx]] = response
+		}
+		return preparedResponses.Response()
+	}
+	if t.microBatcher != nil && len(proxyRequests) == 1 {
+		log.Debug("Forwarding request in micro-batch...")
+		response, err := t.microBatcher.do(req, proxyRequests[0])
+		metrics.SetRequestDuration(time.Since(start).Milliseconds())
+		if ctxErr := canceled(req.Context(), log); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if err != nil {
+			metrics.SetRequestsErrorCounterByMethods(methods...)
+			return nil, err
+		}
+		t.cacheResponses(req.Context(), parsedRequests, requests.RPCResponses{response})
+		preparedResponses[proxyRequestIdx[0]] = response
+		return preparedResponses.Response()
+	}
 	log.Debug("Forwarding request...")
 	if t.debugHTTPRequest {
 		requests.DebugRequest(req, log)
 	}
-	res, err := http.DefaultTransport.RoundTrip(req)
+	var res *http.Response
+	if t.isCacheableRequests(proxyRequests) {
+		res, err = t.sendShared(req, proxyBody, proxyRequests.Methods())
+	} else {
+		res, err = t.upstream.Send(req, proxyRequests.Methods()...)
+	}
 	elapsed := time.Since(start)
 	metrics.SetRequestDuration(elapsed.Milliseconds())
+	if ctxErr := canceled(req.Context(), log); ctxErr != nil {
+		if err == nil {
+			_ = res.Body.Close()
+		}
+		return nil, ctxErr
+	}
 	if err != nil {
 		metrics.SetRequestsErrorCounterByMethods(methods...)
 		return res, err
@@ -110,7 +261,7 @@ func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
 		requests.DebugResponse(res, log)
 	}
 	// no need cache. Return without parsing response
-	if !t.isCacheableRequests(parsedRequests) && len(cachedMethods) == 0 {
+	if !t.isCacheableRequests(parsedRequests) && len(preparedRequestIdx) == 0 {
 		return res, nil
 	}
 	responses, body, err := requests.ParseResponses(res)
@@ -119,16 +270,8 @@ func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
 		return requests.JSONRPCErrorResponse(res.StatusCode, body)
 	}
 
+	t.cacheResponses(req.Context(), parsedRequests, responses)
 	for idx, response := range responses {
-		if response.Error == nil {
-			if request, ok := parsedRequests.FindByID(response.ID); ok {
-				if t.cacher.Matcher().IsCacheable(request.Method) {
-					if err := t.cacher.SetResponseCache(request, response); err != nil {
-						t.logger.Errorf("Cannot set cached response: %v", err)
-					}
-				}
-			}
-		}
 		preparedResponses[proxyRequestIdx[idx]] = response
 	}
 
@@ -140,6 +283,22 @@ func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	return resp, nil
 }
 
+// cacheResponses stores successful responses of cacheable requests
+func (t *transport) cacheResponses(ctx context.Context, reqs requests.RPCRequests, responses requests.RPCResponses) {
+	for _, response := range responses {
+		if response.Error != nil {
+			continue
+		}
+		if request, ok := reqs.FindByID(response.ID); ok {
+			if t.cacher.Matcher().IsCacheable(request.Method) {
+				if err := t.cacher.SetResponseCache(ctx, request, response); err != nil {
+					t.logger.Errorf("Cannot set cached response: %v", err)
+				}
+			}
+		}
+	}
+}
+
 func (t *transport) isCacheableRequests(reqs requests.RPCRequests) bool {
 	for _, req := range reqs {
 		if !t.cacher.Matcher().IsCacheable(req.Method) {
@@ -149,23 +308,89 @@ func (t *transport) isCacheableRequests(reqs requests.RPCRequests) bool {
 	return true
 }
 
-// fromCache checks presence of messages in the cache
-func (t *transport) fromCache(reqs requests.RPCRequests) (requests.RPCResponses, error) {
-	results := make(requests.RPCResponses, len(reqs))
+// fromCache fills empty responses with messages found in the cache.
+// Returns results compressed by the encoding by the response positions when they are stored
+func (t *transport) fromCache(ctx context.Context, reqs requests.RPCRequests, results requests.RPCResponses, encoding compression.Encoding) (map[int][]byte, error) {
+	if !compression.Partial(encoding) {
+		encoding = compression.Identity
+	}
+	encodedResults := map[int][]byte{}
 	for idx, request := range reqs {
-		response, err := t.cacher.GetResponseCache(request)
+		if !results[idx].IsEmpty() {
+			continue
+		}
+		response, encoded, err := t.cacher.GetEncodedResponseCache(ctx, request, encoding)
 		if err != nil {
 			cacheErr := &cache.Error{}
 			if errors.As(err, cacheErr) {
 				t.logger.Errorf("Cannot get cache value for testMethod %q: %v", request.Method, cacheErr)
 			} else {
-				return results, err
+				return encodedResults, err
 			}
 		}
+		if response.IsEmpty() {
+			continue
+		}
 		response.ID = request.ID
 		results[idx] = response
+		if encoded != nil {
+			encodedResults[idx] = encoded
+		}
+	}
+	return encodedResults, nil
+}
+
+// sharedKey identifies identical requests. Requests sent with different credentials are never shared
+func sharedKey(req *http.Request, body []byte) string {
+	return fmt.Sprintf("%s\n%s\n%s", req.URL.Path, req.Header.Get("Authorization"), body)
+}
+
+// sendShared sends the request along with identical requests of other clients in flight.
+// The upstream request is canceled when all clients waiting for it are gone. Requests are hedged when it is configured
+func (t *transport) sendShared(req *http.Request, body []byte, methods []string) (*http.Response, error) {
+	key := sharedKey(req, body)
+	read := func(r *http.Request) (*sharedResponse, error) {
+		res, err := t.upstream.Send(r, methods...)
+		if err != nil {
+			return nil, err
+		}
+		data, err := utils.Read(res.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &sharedResponse{statusCode: res.StatusCode, header: res.Header, body: data}, nil
+	}
+	shared, coalesced, err := t.inflight.do(req.Context(), key, func(ctx context.Context) (*sharedResponse, error) {
+		if t.hedger != nil {
+			return t.hedger.send(ctx, req, body, read)
+		}
+		return read(req.WithContext(ctx))
+	})
+	if coalesced {
+		metrics.SetRequestsCoalescedCounter()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return shared.response(), nil
+}
+
+// canceled returns the error of the request context and counts canceled requests
+func canceled(ctx context.Context, log *logrus.Entry) error {
+	err := ctx.Err()
+	if err != nil {
+		log.Infof("Request is canceled: %v", err)
+		metrics.SetRequestsCanceledCounter(canceledReason(err))
+	}
+	return err
+}
+
+// canceledReason returns metrics label of the request context error
+func canceledReason(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "deadline"
 	}
-	return results, nil
+	return "client"
 }
 
 func (t *transport) Close() error {
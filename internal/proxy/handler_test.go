@@ -9,8 +9,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/matcher"
 	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
@@ -123,7 +127,7 @@ func TestTransportWithCache(t *testing.T) {
 	require.Equal(t, responses[0].Result, result)
 	require.Equal(t, responses[0].ID, requestID)
 
-	cacheResult, err := server.transport.cacher.GetResponseCache(request)
+	cacheResult, err := server.transport.cacher.GetResponseCache(context.Background(), request)
 	require.NoError(t, err)
 	require.Equal(t, cacheResult.Result, result)
 	require.Equal(t, cacheResult.ID, requestID)
@@ -194,7 +198,7 @@ func TestTransportWithRedisCache(t *testing.T) {
 	require.Equal(t, responses[0].Result, result)
 	require.Equal(t, responses[0].ID, requestID)
 
-	cacheResult, err := server.transport.cacher.GetResponseCache(request)
+	cacheResult, err := server.transport.cacher.GetResponseCache(context.Background(), request)
 	require.NoError(t, err)
 	require.NotNil(t, cacheResult)
 	require.Equal(t, cacheResult.Result, result)
@@ -259,7 +263,7 @@ func TestTransportBulkRequest(t *testing.T) {
 	server, err := FromConfig(ctx, conf)
 	require.NoError(t, err)
 
-	err = server.transport.cacher.SetResponseCache(request1, response1)
+	err = server.transport.cacher.SetResponseCache(context.Background(), request1, response1)
 	require.NoError(t, err)
 
 	frontend := httptest.NewServer(http.HandlerFunc(server.RPCProxy))
@@ -345,8 +349,182 @@ func TestTransportBulkRequestReverseResponses(t *testing.T) {
 	require.Len(t, responses, len(methods))
 
 	for _, req := range reqs {
-		resp, err := server.transport.cacher.GetResponseCache(req)
+		resp, err := server.transport.cacher.GetResponseCache(context.Background(), req)
 		require.NoError(t, err)
 		require.Equal(t, resp.ID, req.ID)
 	}
 }
+
+func TestTransportFirewall(t *testing.T) {
+	allowed := requests.RPCRequest{
+		JSONRPC: "2.0",
+		ID:      "1",
+		Method:  "allowed",
+		Params:  []interface{}{"1"},
+	}
+	denied := requests.RPCRequest{
+		JSONRPC: "2.0",
+		ID:      "2",
+		Method:  "denied",
+		Params:  []interface{}{"1"},
+	}
+	response := requests.RPCResponse{
+		JSONRPC: "2.0",
+		ID:      allowed.ID,
+		Result:  float64(1),
+	}
+	responseJSON, err := json.Marshal(response)
+	require.NoError(t, err)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs, err := requests.ParseRequests(r)
+		require.NoError(t, err)
+		require.Len(t, reqs, 1)
+		require.Equal(t, allowed.Method, reqs[0].Method)
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err = fmt.Fprint(w, string(responseJSON))
+		if err != nil {
+			logger.Log.Error(err)
+		}
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfig(backend.URL)
+	require.NoError(t, err)
+	conf.Firewall.Deny = []string{"den*"}
+	conf.Firewall.MaxBatchSize = 2
+	ctx := context.Background()
+	server, err := FromConfig(ctx, conf)
+	require.NoError(t, err)
+
+	frontend := httptest.NewServer(http.HandlerFunc(server.RPCProxy))
+	defer frontend.Close()
+
+	jsonRequest, err := json.Marshal(requests.RPCRequests{allowed, denied})
+	require.NoError(t, err)
+	resp, err := http.Post(frontend.URL, "application/json", bytes.NewBuffer(jsonRequest))
+	require.NoError(t, err)
+
+	responses, _, err := requests.ParseResponses(resp)
+	require.NoError(t, err)
+	require.Len(t, responses, 2)
+	require.Nil(t, responses[0].Error)
+	require.Equal(t, allowed.ID, responses[0].ID)
+	require.NotNil(t, responses[1].Error)
+	require.Equal(t, denied.ID, responses[1].ID)
+
+	jsonRequest, err = json.Marshal(requests.RPCRequests{allowed, allowed, allowed})
+	require.NoError(t, err)
+	resp, err = http.Post(frontend.URL, "application/json", bytes.NewBuffer(jsonRequest))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestTransportCacheAgreement(t *testing.T) {
+	request := requests.RPCRequest{
+		JSONRPC: "2.0",
+		ID:      "1",
+		Method:  method,
+		Params:  []interface{}{"1"},
+	}
+	newBackend := func(result interface{}) *httptest.Server {
+		responseJSON, err := json.Marshal(requests.RPCResponse{JSONRPC: "2.0", ID: request.ID, Result: result})
+		require.NoError(t, err)
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, err := fmt.Fprint(w, string(responseJSON))
+			if err != nil {
+				logger.Log.Error(err)
+			}
+		}))
+	}
+	backend := newBackend(float64(1))
+	defer backend.Close()
+	agreeingMirror := newBackend(float64(1))
+	defer agreeingMirror.Close()
+	disagreeingMirror := newBackend(float64(2))
+	defer disagreeingMirror.Close()
+
+	for _, c := range []struct {
+		mirror *httptest.Server
+		cached bool
+	}{{mirror: agreeingMirror, cached: true}, {mirror: disagreeingMirror, cached: false}} {
+		conf, err := testhelpers.GetConfig(backend.URL, method)
+		require.NoError(t, err)
+		conf.ProxyMirrorURLs = []string{c.mirror.URL}
+		conf.CacheMethods[0].Admission.RequireAgreement = true
+		require.NoError(t, conf.Validate())
+		server, err := FromConfig(context.Background(), conf)
+		require.NoError(t, err)
+
+		frontend := httptest.NewServer(http.HandlerFunc(server.RPCProxy))
+		jsonRequest, err := json.Marshal(request)
+		require.NoError(t, err)
+		resp, err := http.Post(frontend.URL, "application/json", bytes.NewBuffer(jsonRequest))
+		require.NoError(t, err)
+		responses, _, err := requests.ParseResponses(resp)
+		require.NoError(t, err)
+		require.Len(t, responses, 1)
+		require.Equal(t, float64(1), responses[0].Result)
+		frontend.Close()
+		server.transport.cacher.(*ResponseCache).agreements.Wait()
+
+		cacheResult, err := server.transport.cacher.GetResponseCache(context.Background(), request)
+		require.NoError(t, err)
+		require.Equal(t, c.cached, !cacheResult.IsEmpty())
+	}
+}
+
+// blockingVerifier agrees on responses once released
+type blockingVerifier struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (v *blockingVerifier) Agree(ctx context.Context, _ requests.RPCRequest, _ requests.RPCResponse) bool {
+	atomic.AddInt32(&v.calls, 1)
+	select {
+	case <-v.release:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func TestResponseCacheAgreementWorkers(t *testing.T) {
+	conf, err := testhelpers.GetConfig("http://test.com", method)
+	require.NoError(t, err)
+	conf.CacheMethods[0].Admission.RequireAgreement = true
+	rc := NewResponseCache(cache.NewMemoryCacheDefault(), matcher.FromConfig(conf))
+	verifier := &blockingVerifier{release: make(chan struct{})}
+	rc.SetVerifier(verifier)
+	newRequest := func(param int) requests.RPCRequest {
+		return requests.RPCRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: []interface{}{param}}
+	}
+	response := requests.RPCResponse{JSONRPC: "2.0", ID: 1, Result: "result"}
+	ctx := context.Background()
+
+	// identical responses are checked once and checks are bounded by workers
+	for i := 0; i < 3; i++ {
+		require.NoError(t, rc.SetResponseCache(ctx, newRequest(0), response))
+	}
+	for i := 1; i < 2*agreementWorkers; i++ {
+		require.NoError(t, rc.SetResponseCache(ctx, newRequest(i), response))
+	}
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&verifier.calls) == agreementWorkers
+	}, time.Second, time.Millisecond)
+
+	// shutdown cancels running checks and no checks are started afterwards
+	shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	require.False(t, rc.Shutdown(shutdownCtx))
+	require.NoError(t, rc.SetResponseCache(ctx, newRequest(2*agreementWorkers), response))
+	require.Equal(t, int32(agreementWorkers), atomic.LoadInt32(&verifier.calls))
+	cached, err := rc.GetResponseCache(ctx, newRequest(0))
+	require.NoError(t, err)
+	require.True(t, cached.IsEmpty())
+}
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/metrics"
+)
+
+const (
+	// latencySamples is the number of recent upstream latencies the observed delay is computed from
+	latencySamples = 512
+	// minLatencySamples are required before the observed delay is used
+	minLatencySamples = 20
+	hedgePercentile   = 0.95
+)
+
+// latencies keeps recent upstream request latencies
+type latencies struct {
+	lock    sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (l *latencies) add(latency time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.samples) < latencySamples {
+		l.samples = append(l.samples, latency)
+		return
+	}
+	l.samples[l.next] = latency
+	l.next = (l.next + 1) % latencySamples
+}
+
+// percentile returns the latency not exceeded by the share p of samples. Returns false without enough samples
+func (l *latencies) percentile(p float64) (time.Duration, bool) {
+	l.lock.Lock()
+	if len(l.samples) < minLatencySamples {
+		l.lock.Unlock()
+		return 0, false
+	}
+	sorted := append([]time.Duration(nil), l.samples...)
+	l.lock.Unlock()
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	return sorted[int(p*float64(len(sorted)-1))], true
+}
+
+// hedger sends hedged duplicates of slow upstream requests to mirror nodes
+type hedger struct {
+	targets   []*url.URL
+	delay     time.Duration
+	minDelay  time.Duration
+	latencies *latencies
+	counter   uint32
+}
+
+// newHedger initializes hedger sending duplicates to the mirror urls. Returns nil if hedging is disabled
+func newHedger(settings config.HedgingSettings, urls []string) (*hedger, error) {
+	if !settings.Enabled || len(urls) == 0 {
+		return nil, nil
+	}
+	h := &hedger{
+		delay:     time.Duration(settings.Delay) * time.Millisecond,
+		minDelay:  time.Duration(settings.MinDelay) * time.Millisecond,
+		latencies: &latencies{},
+	}
+	for _, mirrorURL := range urls {
+		target, err := url.Parse(mirrorURL)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse mirror url: %w", err)
+		}
+		h.targets = append(h.targets, target)
+	}
+	return h, nil
+}
+
+// hedgeDelay returns the delay before the hedged request. Returns false while the observed latency is unknown
+func (h *hedger) hedgeDelay() (time.Duration, bool) {
+	if h.delay > 0 {
+		return h.delay, true
+	}
+	delay, ok := h.latencies.percentile(hedgePercentile)
+	if !ok {
+		return 0, false
+	}
+	if delay < h.minDelay {
+		delay = h.minDelay
+	}
+	return delay, true
+}
+
+func (h *hedger) nextTarget() *url.URL {
+	idx := atomic.AddUint32(&h.counter, 1)
+	return h.targets[int(idx)%len(h.targets)]
+}
+
+// hedgedRequest returns the copy of the request sent to the target url as is, like mirror requests
+func hedgedRequest(req *http.Request, target *url.URL, body []byte) *http.Request {
+	hedged := req.Clone(req.Context())
+	hedgedURL := *target
+	hedged.URL = &hedgedURL
+	hedged.Host = target.Host
+	hedged.Body = ioutil.NopCloser(bytes.NewReader(body))
+	hedged.ContentLength = int64(len(body))
+	return hedged
+}
+
+type attempt struct {
+	res    *sharedResponse
+	err    error
+	hedged bool
+}
+
+func (a attempt) ok() bool {
+	return a.err == nil && a.res.statusCode < http.StatusInternalServerError
+}
+
+// send sends the request and its hedged duplicate when there is no answer within the delay.
+// The first successful answer wins and the other request is canceled
+func (h *hedger) send(ctx context.Context, req *http.Request, body []byte, send func(*http.Request) (*sharedResponse, error)) (*sharedResponse, error) {
+	metrics.SetUpstreamHedgeableCounter()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	attempts := make(chan attempt, 2)
+	start := time.Now()
+	go func() {
+		res, err := send(req.WithContext(ctx))
+		if err == nil {
+			h.latencies.add(time.Since(start))
+		}
+		attempts <- attempt{res: res, err: err}
+	}()
+	pending := 1
+	var hedge <-chan time.Time
+	if delay, ok := h.hedgeDelay(); ok {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		hedge = timer.C
+	}
+	for {
+		select {
+		case <-hedge:
+			hedge = nil
+			pending++
+			metrics.SetUpstreamHedgedCounter()
+			hedged := hedgedRequest(req, h.nextTarget(), body).WithContext(ctx)
+			go func() {
+				res, err := send(hedged)
+				attempts <- attempt{res: res, err: err, hedged: true}
+			}()
+		case a := <-attempts:
+			pending--
+			if !a.ok() && pending > 0 {
+				continue
+			}
+			if a.hedged && a.ok() {
+				metrics.SetUpstreamHedgeWonCounter()
+			}
+			return a.res, a.err
+		}
+	}
+}
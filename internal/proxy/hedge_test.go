@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatenciesPercentile(t *testing.T) {
+	l := &latencies{}
+	for i := 1; i < minLatencySamples; i++ {
+		l.add(time.Duration(i) * time.Millisecond)
+	}
+	_, ok := l.percentile(hedgePercentile)
+	require.False(t, ok)
+	for i := minLatencySamples; i <= 100; i++ {
+		l.add(time.Duration(i) * time.Millisecond)
+	}
+	p95, ok := l.percentile(hedgePercentile)
+	require.True(t, ok)
+	require.Equal(t, 95*time.Millisecond, p95)
+}
+
+func TestHedgedRequest(t *testing.T) {
+	method := "Filecoin.ChainHead"
+	canceled := make(chan struct{}, 1)
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+			canceled <- struct{}{}
+		case <-time.After(5 * time.Second):
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer primary.Close()
+	hedge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/mirror/rpc/v0", r.URL.Path)
+		req := requests.RPCRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(requests.RPCResponse{
+			JSONRPC: "2.0",
+			ID:      req.ID,
+			Result:  "hedged",
+		}))
+	}))
+	defer hedge.Close()
+
+	conf, err := testhelpers.GetConfig(primary.URL, method)
+	require.NoError(t, err)
+	conf.ProxyMirrorURLs = []string{hedge.URL + "/mirror/rpc/v0"}
+	conf.Hedging = config.HedgingSettings{Enabled: true, Delay: 20}
+	server, err := FromConfig(context.Background(), conf)
+	require.NoError(t, err)
+	frontend := httptest.NewServer(PrepareRoutes(conf, logger.Log, server))
+	defer frontend.Close()
+	token, err := auth.NewJWT(conf.JWT(), conf.JWTAlgorithm, conf.JWTPermissions)
+	require.NoError(t, err)
+
+	start := time.Now()
+	responses, _, err := requests.Request(context.Background(), frontend.URL, string(token), logger.Log, false, false, requests.RPCRequests{{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  method,
+	}})
+	require.NoError(t, err)
+	require.Len(t, responses, 1)
+	require.Equal(t, "hedged", responses[0].Result)
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("primary request is not canceled")
+	}
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// sharedResponse is an upstream response read in full to be returned to every waiting client
+type sharedResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+func (r *sharedResponse) response() *http.Response {
+	return &http.Response{
+		StatusCode:    r.statusCode,
+		Header:        r.header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(r.body)),
+		ContentLength: int64(len(r.body)),
+	}
+}
+
+// call is an upstream request shared by the clients waiting for it
+type call struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	res     *sharedResponse
+	err     error
+}
+
+// inflight coalesces identical upstream requests of concurrent clients.
+// The upstream request is canceled when all clients waiting for it are gone
+type inflight struct {
+	lock  sync.Mutex
+	calls map[string]*call
+}
+
+func newInflight() *inflight {
+	return &inflight{calls: make(map[string]*call)}
+}
+
+// do waits for the call with the key until the client context is done. The call is started if it is not in flight.
+// send gets the context canceled when all clients are gone. Returns whether the call has been started by another client
+func (g *inflight) do(ctx context.Context, key string, send func(ctx context.Context) (*sharedResponse, error)) (*sharedResponse, bool, error) {
+	g.lock.Lock()
+	c, shared := g.calls[key]
+	if !shared {
+		callCtx, cancel := context.WithCancel(context.Background())
+		c = &call{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		go func() {
+			res, err := send(callCtx)
+			g.lock.Lock()
+			c.res, c.err = res, err
+			g.forget(key, c)
+			g.lock.Unlock()
+			cancel()
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	g.lock.Unlock()
+
+	select {
+	case <-c.done:
+		return c.res, shared, c.err
+	case <-ctx.Done():
+		g.lock.Lock()
+		defer g.lock.Unlock()
+		if c.waiters--; c.waiters == 0 {
+			g.forget(key, c)
+			c.cancel()
+		}
+		return nil, shared, ctx.Err()
+	}
+}
+
+// forget removes the call so next clients start a new one. The lock should be held
+func (g *inflight) forget(key string, c *call) {
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/metrics"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/sirupsen/logrus"
+)
+
+type microBatchResult struct {
+	response requests.RPCResponse
+	err      error
+}
+
+// pendingRequest is a single client request waiting for the micro-batch
+type pendingRequest struct {
+	ctx     context.Context
+	request requests.RPCRequest
+	result  chan microBatchResult
+}
+
+// batchEntry is a request of the micro-batch shared by identical client requests
+type batchEntry struct {
+	request requests.RPCRequest
+	pending []*pendingRequest
+}
+
+// microBatch collects single requests of the same path and credentials
+type microBatch struct {
+	req     *http.Request
+	entries []*batchEntry
+	// byRequest finds the entry of an identical request
+	byRequest map[string]*batchEntry
+}
+
+// entryKey identifies identical requests. Requests with params failing to marshal are never shared
+func entryKey(request requests.RPCRequest) (string, bool) {
+	params, err := json.Marshal(request.Params)
+	if err != nil {
+		return "", false
+	}
+	return request.Method + "\n" + string(params), true
+}
+
+// microBatcher collects single requests arriving within the window and sends them upstream as one batch
+type microBatcher struct {
+	window  time.Duration
+	maxSize int
+	send    func(req *http.Request, batch requests.RPCRequests) (requests.RPCResponses, error)
+	logger  *logrus.Entry
+	lock    sync.Mutex
+	batches map[string]*microBatch
+}
+
+// newMicroBatcher returns nil if micro-batching is disabled
+func newMicroBatcher(
+	settings config.MicroBatchingSettings,
+	logger *logrus.Entry,
+	send func(req *http.Request, batch requests.RPCRequests) (requests.RPCResponses, error),
+) *microBatcher {
+	if !settings.Enabled() {
+		return nil
+	}
+	return &microBatcher{
+		window:  time.Duration(settings.Window) * time.Millisecond,
+		maxSize: settings.MaxSize,
+		send:    send,
+		logger:  logger,
+		batches: make(map[string]*microBatch),
+	}
+}
+
+// do adds the request to the current micro-batch and waits for its response.
+// Requests with different credentials are never sent in one batch. Identical requests share one batch entry
+func (b *microBatcher) do(req *http.Request, request requests.RPCRequest) (requests.RPCResponse, error) {
+	key := req.URL.Path + "\n" + req.Header.Get("Authorization")
+	p := &pendingRequest{
+		ctx:     req.Context(),
+		request: request,
+		result:  make(chan microBatchResult, 1),
+	}
+
+	b.lock.Lock()
+	batch, ok := b.batches[key]
+	if !ok {
+		batch = &microBatch{req: req.Clone(context.Background()), byRequest: make(map[string]*batchEntry)}
+		b.batches[key] = batch
+		time.AfterFunc(b.window, func() { b.flush(key, batch) })
+	}
+	eKey, shared := entryKey(request)
+	entry, ok := batch.byRequest[eKey]
+	if !ok || !shared {
+		entry = &batchEntry{request: request}
+		batch.entries = append(batch.entries, entry)
+		if shared {
+			batch.byRequest[eKey] = entry
+		}
+	}
+	entry.pending = append(entry.pending, p)
+	full := len(batch.entries) >= b.maxSize
+	if full {
+		delete(b.batches, key)
+	}
+	b.lock.Unlock()
+	if full {
+		go b.sendBatch(batch)
+	}
+
+	select {
+	case result := <-p.result:
+		return result.response, result.err
+	case <-req.Context().Done():
+		return requests.RPCResponse{}, req.Context().Err()
+	}
+}
+
+// flush sends the batch once the window passes unless it was already sent being full
+func (b *microBatcher) flush(key string, batch *microBatch) {
+	b.lock.Lock()
+	current, ok := b.batches[key]
+	if !ok || current != batch {
+		b.lock.Unlock()
+		return
+	}
+	delete(b.batches, key)
+	b.lock.Unlock()
+	b.sendBatch(batch)
+}
+
+// sendBatch sends distinct pending requests with rewritten IDs and delivers responses with the original IDs
+// to every client waiting for them. The upstream request is canceled when all waiting clients are gone
+func (b *microBatcher) sendBatch(batch *microBatch) {
+	entries := make([]*batchEntry, 0, len(batch.entries))
+	var pending []*pendingRequest
+	for _, entry := range batch.entries {
+		live := false
+		for _, p := range entry.pending {
+			if p.ctx.Err() == nil {
+				pending = append(pending, p)
+				live = true
+			}
+		}
+		if live {
+			entries = append(entries, entry)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	waiters := int32(len(pending))
+	for _, p := range pending {
+		go func(p *pendingRequest) {
+			select {
+			case <-p.ctx.Done():
+				if atomic.AddInt32(&waiters, -1) == 0 {
+					cancel()
+				}
+			case <-ctx.Done():
+			}
+		}(p)
+	}
+
+	reqs := make(requests.RPCRequests, len(entries))
+	for idx, entry := range entries {
+		reqs[idx] = entry.request
+		reqs[idx].ID = float64(idx + 1)
+	}
+	metrics.SetUpstreamMicroBatchSize(len(reqs))
+	responses, err := b.send(batch.req.WithContext(ctx), reqs)
+	if err != nil {
+		b.logger.Errorf("Cannot send micro-batch of %d requests: %v", len(reqs), err)
+		for _, p := range pending {
+			p.result <- microBatchResult{err: err}
+		}
+		return
+	}
+	delivered := make([]bool, len(entries))
+	for _, response := range responses {
+		idx, ok := reqs.FindPositionByID(response.ID)
+		if !ok || delivered[idx] {
+			continue
+		}
+		delivered[idx] = true
+		for _, p := range entries[idx].pending {
+			response.ID = p.request.ID
+			p.result <- microBatchResult{response: response}
+		}
+	}
+	for idx, entry := range entries {
+		if delivered[idx] {
+			continue
+		}
+		for _, p := range entry.pending {
+			p.result <- microBatchResult{response: requests.InternalErrorResponse(p.request.ID, "no upstream response")}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportMicroBatches(t *testing.T) {
+	clients := 5
+	lock := sync.Mutex{}
+	var sizes []int
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs, err := requests.ParseRequests(r)
+		require.NoError(t, err)
+		lock.Lock()
+		sizes = append(sizes, len(reqs))
+		lock.Unlock()
+		responses := make(requests.RPCResponses, 0, len(reqs))
+		for _, req := range reqs {
+			responses = append(responses, requests.RPCResponse{
+				JSONRPC: "2.0",
+				ID:      req.ID,
+				Result:  req.Params,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if len(responses) == 1 {
+			require.NoError(t, json.NewEncoder(w).Encode(responses[0]))
+			return
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(responses))
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfig(backend.URL)
+	require.NoError(t, err)
+	conf.MicroBatching = config.MicroBatchingSettings{Window: 200, MaxSize: clients}
+	server, err := FromConfig(context.Background(), conf)
+	require.NoError(t, err)
+	frontend := httptest.NewServer(PrepareRoutes(conf, logger.Log, server))
+	defer frontend.Close()
+	token, err := auth.NewJWT(conf.JWT(), conf.JWTAlgorithm, conf.JWTPermissions)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// every client uses the same ID
+			responses, _, err := requests.Request(context.Background(), frontend.URL, string(token), logger.Log, false, false, requests.RPCRequests{{
+				JSONRPC: "2.0",
+				ID:      "client",
+				Method:  "Filecoin.ChainHead",
+				Params:  fmt.Sprintf("client-%d", i),
+			}})
+			require.NoError(t, err)
+			require.Len(t, responses, 1)
+			require.Equal(t, "client", responses[0].ID)
+			require.Equal(t, fmt.Sprintf("client-%d", i), responses[0].Result)
+		}(i)
+	}
+	wg.Wait()
+	require.Equal(t, []int{clients}, sizes)
+}
+
+func TestTransportMicroBatchesShareIdenticalRequests(t *testing.T) {
+	clients := 4
+	lock := sync.Mutex{}
+	var sizes []int
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs, err := requests.ParseRequests(r)
+		require.NoError(t, err)
+		lock.Lock()
+		sizes = append(sizes, len(reqs))
+		lock.Unlock()
+		responses := make(requests.RPCResponses, 0, len(reqs))
+		for _, req := range reqs {
+			responses = append(responses, requests.RPCResponse{
+				JSONRPC: "2.0",
+				ID:      req.ID,
+				Result:  req.Params,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(responses))
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfig(backend.URL)
+	require.NoError(t, err)
+	conf.MicroBatching = config.MicroBatchingSettings{Window: 200, MaxSize: clients}
+	server, err := FromConfig(context.Background(), conf)
+	require.NoError(t, err)
+	frontend := httptest.NewServer(PrepareRoutes(conf, logger.Log, server))
+	defer frontend.Close()
+	token, err := auth.NewJWT(conf.JWT(), conf.JWTAlgorithm, conf.JWTPermissions)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// pairs of clients send identical requests with their own IDs
+			responses, _, err := requests.Request(context.Background(), frontend.URL, string(token), logger.Log, false, false, requests.RPCRequests{{
+				JSONRPC: "2.0",
+				ID:      float64(i),
+				Method:  "Filecoin.ChainHead",
+				Params:  fmt.Sprintf("client-%d", i%2),
+			}})
+			require.NoError(t, err)
+			require.Len(t, responses, 1)
+			require.Equal(t, float64(i), responses[0].ID)
+			require.Equal(t, fmt.Sprintf("client-%d", i%2), responses[0].Result)
+		}(i)
+	}
+	wg.Wait()
+	require.Equal(t, []int{2}, sizes)
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/upstream"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MirrorVerifier compares upstream responses with the responses of mirror nodes
+type MirrorVerifier struct {
+	urls    []string
+	token   auth.TokenSource
+	client  *upstream.Client
+	logger  *logrus.Entry
+	counter uint32
+}
+
+// NewMirrorVerifier initializes verifier
+func NewMirrorVerifier(urls []string, token auth.TokenSource, logger *logrus.Entry) *MirrorVerifier {
+	return &MirrorVerifier{
+		urls:   urls,
+		token:  token,
+		client: upstream.Default(),
+		logger: logger,
+	}
+}
+
+// MirrorVerifierFromConfig initializes verifier from config. Returns nil without mirrors
+func MirrorVerifierFromConfig(c *config.Config, client *upstream.Client, logger *logrus.Entry) (*MirrorVerifier, error) {
+	if len(c.ProxyMirrorURLs) == 0 {
+		return nil, nil
+	}
+	token, err := auth.UpstreamTokenFromConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	v := NewMirrorVerifier(c.ProxyMirrorURLs, token, logger)
+	v.client = client
+	return v, nil
+}
+
+func (v *MirrorVerifier) nextURL() string {
+	idx := atomic.AddUint32(&v.counter, 1)
+	return v.urls[int(idx)%len(v.urls)]
+}
+
+// Agree sends the request to one of the mirrors and compares results
+func (v *MirrorVerifier) Agree(ctx context.Context, req requests.RPCRequest, resp requests.RPCResponse) bool {
+	token := ""
+	if v.token != nil {
+		var err error
+		if token, err = v.token.Token(); err != nil {
+			v.logger.Errorf("Cannot get upstream token: %v", err)
+			return false
+		}
+	}
+	url := v.nextURL()
+	responses, _, err := requests.RequestWithClient(ctx, v.client, url, token, v.logger, false, false, requests.RPCRequests{req})
+	if err != nil {
+		v.logger.Errorf("Cannot get mirror %s response for method %s: %v", url, req.Method, err)
+		return false
+	}
+	if len(responses) != 1 || responses[0].Error != nil {
+		return false
+	}
+	expected, err := json.Marshal(resp.Result)
+	if err != nil {
+		return false
+	}
+	actual, err := json.Marshal(responses[0].Result)
+	if err != nil {
+		return false
+	}
+	if !bytes.Equal(expected, actual) {
+		v.logger.Warnf("Mirror %s disagrees on method %s result", url, req.Method)
+		return false
+	}
+	return true
+}
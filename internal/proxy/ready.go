@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+// ChainHead reports the chain head tracked from upstream
+type ChainHead interface {
+	Height() int64
+	// Timestamp of the head tipset
+	Timestamp() time.Time
+	// Updated is the time of the last successful head request
+	Updated() time.Time
+	// Err is the error of the last head request
+	Err() error
+}
+
+// Check is the status of a readiness component
+type Check struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Height int64  `json:"height,omitempty"`
+	Age    string `json:"age,omitempty"`
+}
+
+// Readiness is the body of the readiness endpoint
+type Readiness struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+func newCheck(err error) Check {
+	if err != nil {
+		return Check{Status: checkFail, Error: err.Error()}
+	}
+	return Check{Status: checkOK}
+}
+
+// SetChainHead sets the chain head checked by the readiness endpoint
+func (p *Server) SetChainHead(head ChainHead) {
+	p.head = head
+}
+
+// readiness checks the cache backend, upstream and chain head state and warming of custom methods
+func (p *Server) readiness(ctx context.Context) Readiness {
+	ready := Readiness{Status: checkOK, Checks: map[string]Check{}}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(p.readinessSettings.Timeout)*time.Second)
+	defer cancel()
+	ready.Checks["cache"] = newCheck(p.cacher.Cacher().Ping(ctx))
+
+	if p.head != nil {
+		ready.Checks["upstream"] = newCheck(p.head.Err())
+		ready.Checks["chain"] = p.chainCheck()
+	}
+
+	if p.readinessSettings.RequireWarm {
+		var err error
+		if p.updater == nil || !p.updater.Warmed() {
+			err = fmt.Errorf("custom methods are not cached yet")
+		}
+		ready.Checks["warm"] = newCheck(err)
+	}
+
+	for _, check := range ready.Checks {
+		if check.Status != checkOK {
+			ready.Status = checkFail
+		}
+	}
+	return ready
+}
+
+func (p *Server) chainCheck() Check {
+	if p.head.Updated().IsZero() {
+		return newCheck(fmt.Errorf("chain head is unknown"))
+	}
+	check := Check{Status: checkOK, Height: p.head.Height()}
+	timestamp := p.head.Timestamp()
+	if timestamp.IsZero() {
+		return check
+	}
+	age := time.Since(timestamp).Truncate(time.Second)
+	check.Age = age.String()
+	maxAge := time.Duration(p.readinessSettings.MaxHeadAge) * time.Second
+	if maxAge > 0 && age > maxAge {
+		check.Status = checkFail
+		check.Error = fmt.Sprintf("chain head is older than %s", maxAge)
+	}
+	return check
+}
+
+// ReadyFunc readiness checking. Responds 503 unless all checks pass
+func (p *Server) ReadyFunc(w http.ResponseWriter, r *http.Request) {
+	ready := p.readiness(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if ready.Status != checkOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(ready); err != nil {
+		p.logger.Errorf("response send error %v", err)
+	}
+}
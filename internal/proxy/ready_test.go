@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+)
+
+type testChainHead struct {
+	timestamp time.Time
+	err       error
+}
+
+func (h testChainHead) Height() int64 {
+	return 100
+}
+
+func (h testChainHead) Timestamp() time.Time {
+	return h.timestamp
+}
+
+func (h testChainHead) Updated() time.Time {
+	return time.Now()
+}
+
+func (h testChainHead) Err() error {
+	return h.err
+}
+
+func TestReadyFunc(t *testing.T) {
+	conf, err := testhelpers.GetConfig("http://test.com", testMethod)
+	require.NoError(t, err)
+	conf.Readiness.MaxHeadAge = 60
+	conf.Readiness.RequireWarm = true
+	server, err := FromConfig(context.Background(), conf)
+	require.NoError(t, err)
+	handler := PrepareRoutes(conf, logger.Log, server)
+
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	ready := func(expectedCode int) Readiness {
+		resp, err := http.Get(s.URL + "/ready")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, expectedCode, resp.StatusCode)
+		readiness := Readiness{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&readiness))
+		return readiness
+	}
+
+	// custom methods are not warmed
+	readiness := ready(http.StatusServiceUnavailable)
+	require.Equal(t, checkOK, readiness.Checks["cache"].Status)
+	require.Equal(t, checkFail, readiness.Checks["warm"].Status)
+
+	server.SetUpdater(testUpdater{{Name: "methods"}})
+	server.SetChainHead(testChainHead{timestamp: time.Now().Add(-time.Minute * 5)})
+	readiness = ready(http.StatusServiceUnavailable)
+	require.Equal(t, checkOK, readiness.Checks["upstream"].Status)
+	require.Equal(t, checkFail, readiness.Checks["chain"].Status)
+	require.Equal(t, int64(100), readiness.Checks["chain"].Height)
+
+	server.SetChainHead(testChainHead{timestamp: time.Now(), err: fmt.Errorf("connection refused")})
+	readiness = ready(http.StatusServiceUnavailable)
+	require.Equal(t, checkFail, readiness.Checks["upstream"].Status)
+	require.Equal(t, "connection refused", readiness.Checks["upstream"].Error)
+
+	server.SetChainHead(testChainHead{timestamp: time.Now()})
+	readiness = ready(http.StatusOK)
+	require.Equal(t, checkOK, readiness.Status)
+	require.Len(t, readiness.Checks, 4)
+}
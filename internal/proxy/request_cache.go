@@ -1,57 +1,282 @@
 package proxy
 
 import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
 	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/compression"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/expression"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
 	"github.com/protofire/filecoin-rpc-proxy/internal/matcher"
+	"github.com/protofire/filecoin-rpc-proxy/internal/metrics"
 	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/upstream"
+)
+
+const (
+	// agreementTimeout limits the background check of a response with the mirrors
+	agreementTimeout = 30 * time.Second
+	// agreementWorkers limits the number of concurrent background checks
+	agreementWorkers = 16
 )
 
+// Verifier confirms upstream responses before they are stored in the cache
+type Verifier interface {
+	Agree(context.Context, requests.RPCRequest, requests.RPCResponse) bool
+}
+
+// HeadSource provides the current chain head height
+type HeadSource interface {
+	Height() int64
+}
+
 // ResponseCache implements ResponseCacher interface
 type ResponseCache struct {
-	cache   cache.Cache
-	matcher matcher.Matcher
+	cache    cache.Cache
+	matcher  matcher.Matcher
+	verifier Verifier
+	head     HeadSource
+	logger   *logrus.Entry
+	// agreements are running background checks of responses requiring agreement.
+	// Checks are bounded by agreementSlots and deduplicated by the cache key
+	agreements        sync.WaitGroup
+	agreementSlots    chan struct{}
+	agreementsLock    sync.Mutex
+	pendingAgreements map[string]bool
+	// agreementsCtx cancels running checks on shutdown. No checks are started once closing
+	agreementsCtx    context.Context
+	cancelAgreements context.CancelFunc
+	closing          bool
+	// encodings of results stored along with cached responses
+	encodings []compression.Encoding
+	// minEncodedSize is the min result size to store compressed
+	minEncodedSize int
 }
 
 // NewResponseCache fabric
 func NewResponseCache(cache cache.Cache, matcher matcher.Matcher) *ResponseCache {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &ResponseCache{
-		cache:   cache,
-		matcher: matcher,
+		cache:             cache,
+		matcher:           matcher,
+		logger:            logger.Log,
+		agreementSlots:    make(chan struct{}, agreementWorkers),
+		pendingAgreements: make(map[string]bool),
+		agreementsCtx:     ctx,
+		cancelAgreements:  cancel,
 	}
 }
 
+// NewResponseCacheFromConfig initializes response cache with the matcher and the mirror verifier from config
+func NewResponseCacheFromConfig(c *config.Config, cache cache.Cache, client *upstream.Client, logger *logrus.Entry) (*ResponseCache, error) {
+	rc := NewResponseCache(cache, matcher.FromConfig(c))
+	rc.logger = logger
+	verifier, err := MirrorVerifierFromConfig(c, client, logger)
+	if err != nil {
+		return nil, err
+	}
+	if verifier != nil {
+		rc.SetVerifier(verifier)
+	}
+	if c.Compression.CacheCompressed {
+		rc.SetEncodings(compression.Encodings(c.Compression.Encodings), c.Compression.MinSize)
+	}
+	return rc, nil
+}
+
+// SetEncodings sets encodings of results stored along with cached responses.
+// Only encodings supporting parts are stored
+func (rc *ResponseCache) SetEncodings(encodings []compression.Encoding, minSize int) {
+	rc.encodings = nil
+	for _, encoding := range encodings {
+		if compression.Partial(encoding) {
+			rc.encodings = append(rc.encodings, encoding)
+		}
+	}
+	rc.minEncodedSize = minSize
+}
+
+// encode compresses the response result by the configured encodings
+func (rc *ResponseCache) encode(resp requests.RPCResponse) (map[string][]byte, error) {
+	if len(rc.encodings) == 0 || resp.Result == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(resp.Result)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < rc.minEncodedSize {
+		return nil, nil
+	}
+	encoded := make(map[string][]byte, len(rc.encodings))
+	for _, encoding := range rc.encodings {
+		part, err := compression.EncodePart(encoding, data)
+		if err != nil {
+			return nil, err
+		}
+		encoded[string(encoding)] = part
+	}
+	return encoded, nil
+}
+
+// SetVerifier sets verifier for responses requiring agreement
+func (rc *ResponseCache) SetVerifier(verifier Verifier) {
+	rc.verifier = verifier
+}
+
+// SetHeadSource sets chain head source used by cache conditions
+func (rc *ResponseCache) SetHeadSource(head HeadSource) {
+	rc.head = head
+}
+
+func (rc *ResponseCache) env(req requests.RPCRequest) expression.Env {
+	env := expression.Env{
+		Method: req.Method,
+		Params: req.Params,
+		Caller: req.Caller(),
+	}
+	if rc.head != nil {
+		env.Height = rc.head.Height()
+	}
+	return env
+}
+
 // ResponseCacher interface
 type ResponseCacher interface {
-	SetResponseCache(requests.RPCRequest, requests.RPCResponse) error
-	GetResponseCache(req requests.RPCRequest) (requests.RPCResponse, error)
+	SetResponseCache(ctx context.Context, req requests.RPCRequest, resp requests.RPCResponse) error
+	GetResponseCache(ctx context.Context, req requests.RPCRequest) (requests.RPCResponse, error)
+	GetEncodedResponseCache(ctx context.Context, req requests.RPCRequest, encoding compression.Encoding) (requests.RPCResponse, []byte, error)
 	Matcher() matcher.Matcher
 	Cacher() cache.Cache
 }
 
-// SetResponseCache sets response cache based on the request
-func (rc *ResponseCache) SetResponseCache(req requests.RPCRequest, resp requests.RPCResponse) error {
+// SetResponseCache sets response cache based on the request.
+// Responses requiring agreement are checked with the mirrors and stored in the background
+func (rc *ResponseCache) SetResponseCache(ctx context.Context, req requests.RPCRequest, resp requests.RPCResponse) error {
 	keys := rc.matcher.Keys(req.Method, req.Params)
 	if len(keys) == 0 {
 		return nil
 	}
+	if err := rc.matcher.Admit(req.Method, resp); err != nil {
+		metrics.SetCacheRejectedCounterByMethod(req.Method)
+		return nil
+	}
+	if rc.matcher.RequiresAgreement(req.Method) {
+		if rc.verifier == nil {
+			metrics.SetCacheRejectedCounterByMethod(req.Method)
+			return nil
+		}
+		rc.startAgreement(keys[0].Key, req, resp)
+		return nil
+	}
+	return rc.store(ctx, req, resp)
+}
+
+// startAgreement checks the response in the background unless the key is already being checked.
+// The response is not cached when all workers are busy or the cache is shutting down
+func (rc *ResponseCache) startAgreement(key string, req requests.RPCRequest, resp requests.RPCResponse) {
+	rc.agreementsLock.Lock()
+	defer rc.agreementsLock.Unlock()
+	if rc.pendingAgreements[key] || rc.closing {
+		return
+	}
+	select {
+	case rc.agreementSlots <- struct{}{}:
+	default:
+		metrics.SetCacheRejectedCounterByMethod(req.Method)
+		return
+	}
+	rc.pendingAgreements[key] = true
+	rc.agreements.Add(1)
+	go rc.agreeAndStore(key, req, resp)
+}
+
+// agreeAndStore stores the response if the mirror agrees on it
+func (rc *ResponseCache) agreeAndStore(key string, req requests.RPCRequest, resp requests.RPCResponse) {
+	defer func() {
+		rc.agreementsLock.Lock()
+		delete(rc.pendingAgreements, key)
+		rc.agreementsLock.Unlock()
+		<-rc.agreementSlots
+		rc.agreements.Done()
+	}()
+	ctx, cancel := context.WithTimeout(rc.agreementsCtx, agreementTimeout)
+	defer cancel()
+	if !rc.verifier.Agree(ctx, req, resp) || ctx.Err() != nil {
+		metrics.SetCacheRejectedCounterByMethod(req.Method)
+		return
+	}
+	if err := rc.store(ctx, req, resp); err != nil {
+		rc.logger.Errorf("Cannot set cached response of method %s: %v", req.Method, err)
+	}
+}
+
+// Shutdown stops background checks of responses. Checks still running when the context is done are canceled.
+// Returns false if the checks have not finished in time
+func (rc *ResponseCache) Shutdown(ctx context.Context) bool {
+	rc.agreementsLock.Lock()
+	rc.closing = true
+	rc.agreementsLock.Unlock()
+	stopped := make(chan struct{})
+	go func() {
+		rc.agreements.Wait()
+		close(stopped)
+	}()
+	defer rc.cancelAgreements()
+	select {
+	case <-stopped:
+		return true
+	case <-ctx.Done():
+		rc.cancelAgreements()
+		<-stopped
+		return false
+	}
+}
+
+// store sets the response by the request keys if the write condition allows it
+func (rc *ResponseCache) store(ctx context.Context, req requests.RPCRequest, resp requests.RPCResponse) error {
+	keys := rc.matcher.Keys(req.Method, req.Params)
+	env := rc.env(req)
+	env.Result = resp.Result
+	ok, ttl := rc.matcher.CanWrite(env)
+	if !ok {
+		metrics.SetCacheRejectedCounterByMethod(req.Method)
+		return nil
+	}
+	encoded, err := rc.encode(resp)
+	if err != nil {
+		return err
+	}
 	mErr := &multierror.Error{}
 	for _, key := range keys {
-		mErr = multierror.Append(mErr, rc.cache.Set(key.Key, req, resp))
+		mErr = multierror.Append(mErr, rc.cache.SetEncoded(ctx, key.Key, req, resp, ttl, encoded))
 	}
 	return mErr.ErrorOrNil()
 }
 
 // GetResponseCache return response from the cache for the request
-func (rc *ResponseCache) GetResponseCache(req requests.RPCRequest) (requests.RPCResponse, error) {
+func (rc *ResponseCache) GetResponseCache(ctx context.Context, req requests.RPCRequest) (requests.RPCResponse, error) {
+	resp, _, err := rc.GetEncodedResponseCache(ctx, req, compression.Identity)
+	return resp, err
+}
+
+// GetEncodedResponseCache return response from the cache for the request along with its result compressed
+// by the encoding if it is stored
+func (rc *ResponseCache) GetEncodedResponseCache(ctx context.Context, req requests.RPCRequest, encoding compression.Encoding) (requests.RPCResponse, []byte, error) {
 	keys := rc.matcher.Keys(req.Method, req.Params)
-	if len(keys) == 0 {
-		return requests.RPCResponse{}, nil
+	if len(keys) == 0 || !rc.matcher.CanRead(rc.env(req)) {
+		return requests.RPCResponse{}, nil, nil
 	}
 	mErr := &multierror.Error{}
 	for _, key := range keys {
-		resp, err := rc.cache.Get(key.Key)
+		resp, encoded, err := rc.cache.GetEncoded(ctx, key.Key, string(encoding))
 		if err != nil {
 			mErr = multierror.Append(mErr, err)
 			continue
@@ -59,9 +284,9 @@ func (rc *ResponseCache) GetResponseCache(req requests.RPCRequest) (requests.RPC
 		if resp.IsEmpty() {
 			continue
 		}
-		return resp, nil
+		return resp, encoded, nil
 	}
-	return requests.RPCResponse{}, nil
+	return requests.RPCResponse{}, nil, nil
 }
 
 // Matcher interface implementation
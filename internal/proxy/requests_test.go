@@ -62,6 +62,7 @@ func TestRequest(t *testing.T) {
 	require.NoError(t, err)
 
 	responses, _, err := requests.Request(
+		ctx,
 		frontend.URL,
 		string(token),
 		logger.Log,
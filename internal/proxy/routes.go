@@ -1,8 +1,12 @@
 package proxy
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -11,6 +15,7 @@ import (
 	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
 	"github.com/protofire/filecoin-rpc-proxy/internal/config"
 	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/ratelimit"
 	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
 	"github.com/sirupsen/logrus"
 )
@@ -27,39 +32,183 @@ func PrepareRoutes(c *config.Config, log *logrus.Entry, server *Server) *chi.Mux
 	r.Mount("/debug", middleware.Profiler())
 	r.Group(func(r chi.Router) {
 		r.Use(jwtauth.Verifier(tokenAuth))
-		r.Use(Authenticator)
+		r.Use(ClientCertVerifier(c.TLS, c.JWTPermissions))
+		r.Use(APIKeyVerifier(server.keys, c.APIKeys.Header, c.APIKeys.QueryParam))
+		r.Use(Authenticator(server.revocations))
+		r.Use(RateLimiter(server.limiter))
+		r.Use(ClientTimeout(c.ClientTimeout))
+		r.Use(Compressor(c.Compression))
+		r.HandleFunc("/status/updater", server.UpdaterStatusFunc)
 		r.HandleFunc("/*", server.RPCProxy)
 	})
 	return r
 }
 
-func Authenticator(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token, _, err := jwtauth.FromContext(r.Context())
+func writeJSONRPCError(w http.ResponseWriter, resp interface{}, code int) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	http.Error(w, string(data), code)
+}
 
-		if err != nil {
-			resp := requests.JSONRPCUnauthenticated()
-			data, err := json.Marshal(resp)
-			if err != nil {
-				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+// ClientCertVerifier rejects requests without a verified client certificate when it is required
+// and authenticates requests having one with the client cert identity enabled.
+// Common name of the certificate is the client name
+func ClientCertVerifier(settings config.TLSSettings, permissions []string) func(http.Handler) http.Handler {
+	required := settings.Enabled() && settings.ClientAuth == config.RequireClientAuth
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			verified := r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0
+			if required && !verified {
+				writeJSONRPCError(w, requests.JSONRPCUnauthenticated(), http.StatusUnauthorized)
+				return
+			}
+			if !settings.ClientCertIdentity || !verified {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cert := r.TLS.VerifiedChains[0][0]
+			ctx := auth.WithIdentity(r.Context(), auth.Identity{
+				Name:        cert.Subject.CommonName,
+				Permissions: permissions,
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// APIKeyVerifier authenticates requests having an API key in the header or in the query parameter.
+// The key is removed from the request so it is never forwarded upstream
+func APIKeyVerifier(keys *auth.KeyStore, header, queryParam string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if keys == nil || !keys.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := r.Header.Get(header)
+			query := r.URL.Query()
+			if key == "" {
+				key = query.Get(queryParam)
+			}
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			apiKey, ok := keys.Lookup(key)
+			if !ok {
+				writeJSONRPCError(w, requests.JSONRPCUnauthenticated(), http.StatusUnauthorized)
+				return
+			}
+			r.Header.Del(header)
+			if query.Get(queryParam) != "" {
+				query.Del(queryParam)
+				r.URL.RawQuery = query.Encode()
+			}
+			ctx := auth.WithIdentity(r.Context(), auth.Identity{
+				Name:        apiKey.Name,
+				Permissions: apiKey.Permissions,
+				Tier:        apiKey.Tier,
+				KeyHash:     apiKey.Hash,
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Authenticator passes requests authenticated either with an API key or with a valid JWT token.
+// Revoked tokens are rejected. Tokens are rejected as well when the revocation list is unavailable
+func Authenticator(revocations auth.Revocations) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := auth.IdentityFromContext(r.Context()); ok {
+				next.ServeHTTP(w, r)
 				return
 			}
-			http.Error(w, string(data), http.StatusUnauthorized)
-			return
-		}
 
-		if token == nil || !token.Valid {
-			resp := requests.JSONRPCUnauthenticated()
-			data, err := json.Marshal(resp)
-			if err != nil {
-				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			token, claims, err := jwtauth.FromContext(r.Context())
+
+			if err != nil || token == nil || !token.Valid {
+				writeJSONRPCError(w, requests.JSONRPCUnauthenticated(), http.StatusUnauthorized)
+				return
+			}
+
+			identity := auth.IdentityFromClaims(claims)
+			identity.KeyHash = auth.HashAPIKey(token.Raw)
+			if identity.TokenID != "" && revocations != nil {
+				if revoked, err := revocations.IsRevoked(identity.TokenID); err != nil || revoked {
+					writeJSONRPCError(w, requests.JSONRPCUnauthenticated(), http.StatusUnauthorized)
+					return
+				}
+			}
+
+			// Token is authenticated, pass it through
+			ctx := auth.WithIdentity(r.Context(), identity)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RateLimiter rejects requests exceeding the rate limit tier of the client
+func RateLimiter(limiter *ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if limiter == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			identity, _ := auth.IdentityFromContext(r.Context())
+			if !limiter.Allow(identity.ClientKey(), identity.Tier) {
+				writeJSONRPCError(w, requests.JSONRPCRateLimited(), http.StatusTooManyRequests)
 				return
 			}
-			http.Error(w, string(data), http.StatusUnauthorized)
-			return
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// parseTimeout parses the timeout in seconds or as a duration
+func parseTimeout(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 {
+			return 0, fmt.Errorf("timeout should be positive: %s", value)
 		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout: %s", value)
+	}
+	return timeout, nil
+}
 
-		// Token is authenticated, pass it through
-		next.ServeHTTP(w, r)
-	})
+// ClientTimeout sets the deadline of the request from the timeout header capped by the max timeout.
+// The deadline applies to upstream and cache calls of the request. The header is not forwarded upstream
+func ClientTimeout(settings config.ClientTimeoutSettings) func(http.Handler) http.Handler {
+	max := time.Duration(settings.Max) * time.Second
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := time.Duration(settings.Default) * time.Second
+			if value := r.Header.Get(settings.Header); value != "" {
+				var err error
+				if timeout, err = parseTimeout(value); err != nil {
+					writeJSONRPCError(w, requests.JSONRPCInvalidRequest(err.Error()), http.StatusBadRequest)
+					return
+				}
+				r.Header.Del(settings.Header)
+			}
+			if max > 0 && timeout > max {
+				timeout = max
+			}
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
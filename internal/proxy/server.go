@@ -2,12 +2,19 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"time"
 
-	"github.com/protofire/filecoin-rpc-proxy/internal/matcher"
+	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
+	"github.com/protofire/filecoin-rpc-proxy/internal/certs"
+	"github.com/protofire/filecoin-rpc-proxy/internal/ratelimit"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/upstream"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
 
@@ -18,11 +25,23 @@ import (
 )
 
 type Server struct {
-	host   string
-	port   int
-	target *url.URL
-	logger *logrus.Entry
-	proxy  *httputil.ReverseProxy
+	host    string
+	port    int
+	target  *url.URL
+	logger  *logrus.Entry
+	proxy   *httputil.ReverseProxy
+	keys    *auth.KeyStore
+	limiter *ratelimit.Limiter
+	// revocations lists revoked client tokens
+	revocations auth.Revocations
+	updater     UpdaterStatus
+	head        ChainHead
+	// readinessSettings configures checks of ReadyFunc
+	readinessSettings config.ReadinessSettings
+	// tlsConfig is nil for plain HTTP listener
+	tlsConfig         *tls.Config
+	certs             *certs.Reloader
+	certsReloadPeriod time.Duration
 	*transport
 }
 
@@ -36,12 +55,23 @@ func FromConfig(ctx context.Context, c *config.Config) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	cacher := NewResponseCache(
-		cacheImpl,
-		matcher.FromConfig(c),
-	)
-	transport := NewTransport(cacher, log, c.DebugHTTPRequest, c.DebugHTTPResponse)
-	return newServer(proxyURL, c.Host, c.Port, log, transport)
+	client, err := upstream.FromConfig(c)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize upstream client: %w", err)
+	}
+	cacher, err := NewResponseCacheFromConfig(c, cacheImpl, client, log)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := TransportFromConfig(c, cacher, client, log)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newServer(proxyURL, c.Host, c.Port, log, transport)
+	if err != nil {
+		return nil, err
+	}
+	return s, s.init(c)
 }
 
 func newServer(proxyURL *url.URL, host string, port int, log *logrus.Entry, transport *transport) (*Server, error) {
@@ -58,15 +88,85 @@ func newServer(proxyURL *url.URL, host string, port int, log *logrus.Entry, tran
 		transport: transport,
 	}
 	s.proxy.Transport = transport
+	s.proxy.ErrorHandler = s.proxyErrorHandler
 	return s, nil
 }
 
+// proxyErrorHandler replies with gateway timeout when the request deadline has passed.
+// Nothing is replied to clients gone before the response
+func (p *Server) proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		p.logger.Warnf("Request deadline exceeded: %v", err)
+		writeJSONRPCError(w, requests.JSONRPCTimeout(), http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled) && r.Context().Err() != nil:
+		p.logger.Debugf("Client is gone: %v", err)
+	default:
+		p.logger.Errorf("Proxy error: %v", err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+}
+
 func FromConfigWithTransport(c *config.Config, log *logrus.Entry, transport *transport) (*Server, error) {
 	proxyURL, err := url.Parse(c.ProxyURL)
 	if err != nil {
 		return nil, err
 	}
-	return newServer(proxyURL, c.Host, c.Port, log, transport)
+	s, err := newServer(proxyURL, c.Host, c.Port, log, transport)
+	if err != nil {
+		return nil, err
+	}
+	return s, s.init(c)
+}
+
+func (p *Server) init(c *config.Config) error {
+	p.readinessSettings = c.Readiness
+	if err := p.initTLS(c); err != nil {
+		return err
+	}
+	return p.initAuth(c)
+}
+
+func (p *Server) initTLS(c *config.Config) error {
+	if !c.TLS.Enabled() {
+		return nil
+	}
+	tlsConfig, reloader, err := certs.ServerConfig(c.TLS, p.logger)
+	if err != nil {
+		return fmt.Errorf("cannot initialize tls: %w", err)
+	}
+	p.tlsConfig = tlsConfig
+	p.certs = reloader
+	p.certsReloadPeriod = time.Duration(c.TLS.ReloadPeriod) * time.Second
+	return nil
+}
+
+// WatchCertificates reloads TLS certificate when it has been changed
+func (p *Server) WatchCertificates(ctx context.Context) {
+	if p.certs == nil {
+		return
+	}
+	p.certs.Watch(ctx, p.certsReloadPeriod)
+}
+
+func (p *Server) initAuth(c *config.Config) error {
+	keys, err := auth.KeyStoreFromConfig(c, p.logger)
+	if err != nil {
+		return fmt.Errorf("cannot initialize api keys: %w", err)
+	}
+	p.keys = keys
+	p.limiter = ratelimit.FromConfig(c)
+	revocations, err := auth.RevocationsFromConfig(c, p.transport.cacher.Cacher())
+	if err != nil {
+		return fmt.Errorf("cannot initialize token revocations: %w", err)
+	}
+	p.revocations = revocations
+	return nil
+}
+
+// KeyStore returns API keys store of the server
+func (p *Server) KeyStore() *auth.KeyStore {
+	return p.keys
 }
 
 func (p *Server) RPCProxy(w http.ResponseWriter, r *http.Request) {
@@ -83,25 +183,24 @@ func (p *Server) HealthFunc(w http.ResponseWriter, _ *http.Request) {
 	}
 }
 
-// ReadyFunc readiness checking
-func (p *Server) ReadyFunc(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	_, err := w.Write([]byte(`{"status": "ok"}`))
-	if err != nil {
-		p.logger.Errorf("response send error %v", err)
-	}
-}
-
-// StartHTTPServer starts http server
+// StartHTTPServer starts http server. TLS is used when it is configured
 func (p *Server) StartHTTPServer(h http.Handler) *http.Server {
 	server := &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", p.host, p.port),
-		Handler: h,
+		Addr:      fmt.Sprintf("%s:%d", p.host, p.port),
+		Handler:   h,
+		TLSConfig: p.tlsConfig,
 	}
 
 	go func() {
-		p.logger.Infof("Listening on %s:%d", p.host, p.port)
-		if err := server.ListenAndServe(); err != nil {
+		var err error
+		if p.tlsConfig != nil {
+			p.logger.Infof("Listening on %s:%d with TLS", p.host, p.port)
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			p.logger.Infof("Listening on %s:%d", p.host, p.port)
+			err = server.ListenAndServe()
+		}
+		if err != nil {
 			p.logger.Infof("Listening status: %v", err)
 		}
 	}()
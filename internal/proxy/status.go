@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// RefreshStatus is the last result of an updater refresh
+type RefreshStatus struct {
+	Name      string    `json:"name"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+	Requests  int64     `json:"requests"`
+	Failed    int64     `json:"failed"`
+	Removed   int64     `json:"removed"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// UpdaterStatus reports results of the updater refreshes
+type UpdaterStatus interface {
+	Status() []RefreshStatus
+	// Warmed reports whether the updater has cached custom methods
+	Warmed() bool
+}
+
+// SetUpdater sets the updater reported by the status endpoint
+func (p *Server) SetUpdater(updater UpdaterStatus) {
+	p.updater = updater
+}
+
+// UpdaterStatusFunc returns the last result of each updater refresh
+func (p *Server) UpdaterStatusFunc(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	status := []RefreshStatus{}
+	if p.updater != nil {
+		status = append(status, p.updater.Status()...)
+	}
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"refreshes": status}); err != nil {
+		p.logger.Errorf("response send error %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+)
+
+type testUpdater []RefreshStatus
+
+func (u testUpdater) Status() []RefreshStatus {
+	return u
+}
+
+func (u testUpdater) Warmed() bool {
+	return len(u) > 0
+}
+
+func TestUpdaterStatusFunc(t *testing.T) {
+	conf, err := testhelpers.GetConfig("http://test.com", testMethod)
+	require.NoError(t, err)
+	server, err := FromConfig(context.Background(), conf)
+	require.NoError(t, err)
+	server.SetUpdater(testUpdater{{Name: "cache", Requests: 10, Failed: 2, Error: "upstream error"}})
+	handler := PrepareRoutes(conf, logger.Log, server)
+
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	token, err := auth.NewJWT(conf.JWT(), conf.JWTAlgorithm, conf.JWTPermissions)
+	require.NoError(t, err)
+	req, err := http.NewRequest("GET", s.URL+"/status/updater", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+string(token))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	status := struct {
+		Refreshes []RefreshStatus `json:"refreshes"`
+	}{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	require.Len(t, status.Refreshes, 1)
+	require.Equal(t, "cache", status.Refreshes[0].Name)
+	require.Equal(t, int64(2), status.Refreshes[0].Failed)
+	require.Equal(t, "upstream error", status.Refreshes[0].Error)
+}
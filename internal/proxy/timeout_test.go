@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientTimeoutHeader(t *testing.T) {
+	canceled := make(chan struct{}, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// closed connection is detected once the body is read
+		_, _ = ioutil.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+			canceled <- struct{}{}
+		case <-time.After(5 * time.Second):
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfig(backend.URL, testMethod)
+	require.NoError(t, err)
+	server, err := FromConfig(context.Background(), conf)
+	require.NoError(t, err)
+	frontend := httptest.NewServer(PrepareRoutes(conf, logger.Log, server))
+	defer frontend.Close()
+	token, err := auth.NewJWT(conf.JWT(), conf.JWTAlgorithm, conf.JWTPermissions)
+	require.NoError(t, err)
+
+	send := func(timeout string) *http.Response {
+		body := `{"jsonrpc": "2.0", "id": 1, "method": "Filecoin.ChainHead", "params": []}`
+		req, err := http.NewRequest("POST", frontend.URL, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		req.Header.Set(conf.ClientTimeout.Header, timeout)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	start := time.Now()
+	resp := send("100ms")
+	require.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("upstream request is not canceled")
+	}
+
+	resp = send("soon")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestParseTimeout(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"2":      2 * time.Second,
+		"1.5":    1500 * time.Millisecond,
+		"1500ms": 1500 * time.Millisecond,
+	} {
+		timeout, err := parseTimeout(value)
+		require.NoError(t, err)
+		require.Equal(t, expected, timeout)
+	}
+	for _, value := range []string{"0", "-1", "-1s", "soon"} {
+		_, err := parseTimeout(value)
+		require.Error(t, err)
+	}
+}
+
+// waitForWaiters waits until n clients are waiting for the call with the key
+func waitForWaiters(t *testing.T, group *inflight, key string, n int) {
+	require.Eventually(t, func() bool {
+		group.lock.Lock()
+		defer group.lock.Unlock()
+		c, ok := group.calls[key]
+		return ok && c.waiters == n
+	}, time.Second, time.Millisecond)
+}
+
+func TestInflightCancelsWhenClientsAreGone(t *testing.T) {
+	group := newInflight()
+	var sent int32
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	send := func(ctx context.Context) (*sharedResponse, error) {
+		atomic.AddInt32(&sent, 1)
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() {
+		_, _, err := group.do(ctx1, "key", send)
+		errs <- err
+	}()
+	<-started
+	go func() {
+		_, _, err := group.do(ctx2, "key", send)
+		errs <- err
+	}()
+	waitForWaiters(t, group, "key", 2)
+
+	cancel1()
+	require.True(t, errors.Is(<-errs, context.Canceled))
+	select {
+	case <-canceled:
+		t.Fatal("upstream request is canceled while a client is waiting")
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel2()
+	require.True(t, errors.Is(<-errs, context.Canceled))
+	<-canceled
+	require.Equal(t, int32(1), atomic.LoadInt32(&sent))
+}
+
+func TestInflightSharesResponse(t *testing.T) {
+	group := newInflight()
+	release := make(chan struct{})
+	var sent int32
+	send := func(ctx context.Context) (*sharedResponse, error) {
+		atomic.AddInt32(&sent, 1)
+		<-release
+		return &sharedResponse{statusCode: http.StatusOK, header: http.Header{}, body: []byte("result")}, nil
+	}
+	results := make(chan *sharedResponse, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			res, _, err := group.do(context.Background(), "key", send)
+			require.NoError(t, err)
+			results <- res
+		}()
+	}
+	waitForWaiters(t, group, "key", 3)
+	close(release)
+	for i := 0; i < 3; i++ {
+		require.Equal(t, []byte("result"), (<-results).body)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&sent))
+}
+
+func TestSharedKeyCredentials(t *testing.T) {
+	body := []byte(`{"jsonrpc":"2.0","id":1,"method":"Filecoin.ChainHead"}`)
+	newRequest := func(token string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/rpc/v0", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
+	}
+	require.Equal(t, sharedKey(newRequest("a"), body), sharedKey(newRequest("a"), body))
+	require.NotEqual(t, sharedKey(newRequest("a"), body), sharedKey(newRequest("b"), body))
+}
@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+)
+
+// serveTLS starts the proxy TLS listener and returns the function requesting the path with the client certificate
+func serveTLS(t *testing.T, conf *config.Config, caPool *x509.CertPool, authorization string) func(*tls.Certificate, string) int {
+	server, err := FromConfig(context.Background(), conf)
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	httpServer := &http.Server{Handler: PrepareRoutes(conf, logger.Log, server)}
+	go func() {
+		_ = httpServer.Serve(tls.NewListener(listener, server.tlsConfig))
+	}()
+	t.Cleanup(func() {
+		_ = httpServer.Close()
+	})
+
+	return func(clientCert *tls.Certificate, path string) int {
+		tlsConfig := &tls.Config{RootCAs: caPool}
+		if clientCert != nil {
+			tlsConfig.Certificates = []tls.Certificate{*clientCert}
+		}
+		transport := &http.Transport{TLSClientConfig: tlsConfig}
+		defer transport.CloseIdleConnections()
+		req, err := http.NewRequest("GET", "https://"+listener.Addr().String()+path, nil)
+		require.NoError(t, err)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+}
+
+func TestServerClientCertAuth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	certs, err := testhelpers.GenerateCertificates(t.TempDir(), "script")
+	require.NoError(t, err)
+	conf, err := testhelpers.GetConfig(backend.URL, testMethod)
+	require.NoError(t, err)
+	conf.TLS = config.TLSSettings{
+		CertFile:           certs.CertFile,
+		KeyFile:            certs.KeyFile,
+		ClientCAFile:       certs.CAFile,
+		ClientAuth:         config.OptionalClientAuth,
+		ClientCertIdentity: true,
+	}
+	conf.Init()
+	require.NoError(t, conf.Validate())
+
+	get := serveTLS(t, conf, certs.CAPool, "")
+
+	// client certificate replaces JWT
+	require.Equal(t, http.StatusOK, get(&certs.Client, "/test"))
+	require.Equal(t, http.StatusUnauthorized, get(nil, "/test"))
+}
+
+func TestServerRequiredClientCert(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	certs, err := testhelpers.GenerateCertificates(t.TempDir(), "script")
+	require.NoError(t, err)
+	conf, err := testhelpers.GetConfig(backend.URL, testMethod)
+	require.NoError(t, err)
+	conf.TLS = config.TLSSettings{
+		CertFile:     certs.CertFile,
+		KeyFile:      certs.KeyFile,
+		ClientCAFile: certs.CAFile,
+		ClientAuth:   config.RequireClientAuth,
+	}
+	conf.Init()
+	require.NoError(t, conf.Validate())
+	token, err := auth.NewJWT(conf.JWT(), conf.JWTAlgorithm, conf.JWTPermissions)
+	require.NoError(t, err)
+
+	get := serveTLS(t, conf, certs.CAPool, "Bearer "+string(token))
+	// probes and metrics do not require client certificates
+	for _, path := range []string{"/healthz", "/ready", "/metrics"} {
+		require.Equal(t, http.StatusOK, get(nil, path), path)
+	}
+	require.Equal(t, http.StatusUnauthorized, get(nil, "/test"))
+	require.Equal(t, http.StatusOK, get(&certs.Client, "/test"))
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"sync"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter limits requests per client according to the client rate limit tier
+type Limiter struct {
+	lock        sync.Mutex
+	tiers       map[string]config.RateLimitTier
+	defaultTier string
+	limiters    map[string]*rate.Limiter
+}
+
+// New initializes limiter with tiers. Clients without a known tier are limited by the default tier
+func New(tiers []config.RateLimitTier, defaultTier string) *Limiter {
+	l := &Limiter{
+		tiers:       make(map[string]config.RateLimitTier, len(tiers)),
+		defaultTier: defaultTier,
+		limiters:    make(map[string]*rate.Limiter),
+	}
+	for _, tier := range tiers {
+		l.tiers[tier.Name] = tier
+	}
+	return l
+}
+
+// FromConfig initializes limiter from config
+func FromConfig(c *config.Config) *Limiter {
+	return New(c.RateLimitTiers, c.DefaultRateLimitTier)
+}
+
+// Allow reports whether the client may send one more request.
+// Clients without a known tier are not limited when the default tier is not set
+func (l *Limiter) Allow(client, tier string) bool {
+	settings, ok := l.tiers[tier]
+	if !ok {
+		if settings, ok = l.tiers[l.defaultTier]; !ok {
+			return true
+		}
+		tier = l.defaultTier
+	}
+	key := tier + "/" + client
+	l.lock.Lock()
+	limiter, ok := l.limiters[key]
+	if !ok {
+		burst := settings.Burst
+		if burst <= 0 {
+			burst = 1
+		}
+		limiter = rate.NewLimiter(rate.Limit(settings.RequestsPerSecond), burst)
+		l.limiters[key] = limiter
+	}
+	l.lock.Unlock()
+	return limiter.Allow()
+}
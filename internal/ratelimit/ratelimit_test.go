@@ -0,0 +1,29 @@
+package ratelimit
+
+import (
+	"testing"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	tiers := []config.RateLimitTier{
+		{Name: "basic", RequestsPerSecond: 0.001, Burst: 1},
+		{Name: "pro", RequestsPerSecond: 0.001, Burst: 2},
+	}
+	l := New(tiers, "basic")
+	require.True(t, l.Allow("name/a", "pro"))
+	require.True(t, l.Allow("name/a", "pro"))
+	require.False(t, l.Allow("name/a", "pro"))
+	// clients without a known tier share the default tier limits but not the buckets
+	require.True(t, l.Allow("name/b", ""))
+	require.False(t, l.Allow("name/b", "unknown"))
+	require.True(t, l.Allow("name/c", ""))
+
+	l = New(tiers, "")
+	for i := 0; i < 3; i++ {
+		require.True(t, l.Allow("name/a", ""))
+	}
+}
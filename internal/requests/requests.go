@@ -2,6 +2,7 @@ package requests
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -17,8 +18,11 @@ import (
 )
 
 const (
-	jsonRPCInvalidParams = -32602
-	jsonRPCInternal      = -32603
+	jsonRPCInvalidRequest = -32600
+	jsonRPCMethodNotFound = -32601
+	jsonRPCInvalidParams  = -32602
+	jsonRPCInternal       = -32603
+	jsonRPCLimitExceeded  = -32005
 )
 
 type RPCResponses []RPCResponse
@@ -33,6 +37,16 @@ func (r RPCRequests) FindByID(id interface{}) (RPCRequest, bool) {
 	return RPCRequest{}, false
 }
 
+// FindPositionByID returns the position of the request with the id
+func (r RPCRequests) FindPositionByID(id interface{}) (int, bool) {
+	for idx, req := range r {
+		if utils.Equal(req.ID, id) {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
 func (r RPCRequests) FindByPositions(ids ...int) RPCRequests {
 	var res RPCRequests
 	for _, idx := range ids {
@@ -43,6 +57,13 @@ func (r RPCRequests) FindByPositions(ids ...int) RPCRequests {
 	return res
 }
 
+// SetCaller sets the authenticated caller name for all requests
+func (r RPCRequests) SetCaller(caller string) {
+	for idx := range r {
+		r[idx].caller = caller
+	}
+}
+
 func (r RPCRequests) IsEmpty() bool {
 	return len(r) == 0
 }
@@ -88,12 +109,18 @@ type errResponse struct {
 
 type RPCRequest struct {
 	remoteAddr string
+	caller     string
 	JSONRPC    string      `json:"jsonrpc" bson:"jsonrpc"`
 	ID         interface{} `json:"id,omitempty" bson:"id,omitempty"`
 	Method     string      `json:"method" bson:"method"`
 	Params     interface{} `json:"params,omitempty" bson:"params,omitempty"`
 }
 
+// Caller returns the authenticated caller name
+func (r RPCRequest) Caller() string {
+	return r.caller
+}
+
 type RPCResponse struct {
 	JSONRPC string      `json:"jsonrpc" bson:"jsonrpc"`
 	ID      interface{} `json:"id,omitempty" bson:"id,omitempty"`
@@ -111,6 +138,11 @@ func (r *rpcError) Error() string {
 	return fmt.Sprintf("RCP error. Code: %d. Message: %s. data: %v", r.Code, r.Message, r.Data)
 }
 
+// IsMethodNotFound reports whether upstream does not provide the requested method
+func (r RPCResponse) IsMethodNotFound() bool {
+	return r.Error != nil && r.Error.Code == jsonRPCMethodNotFound
+}
+
 func (r RPCResponse) IsEmpty() bool {
 	return r.JSONRPC == ""
 }
@@ -251,6 +283,67 @@ func JSONRPCUnauthenticated() interface{} {
 	)
 }
 
+func JSONRPCRateLimited() interface{} {
+	return jsonRPCError(
+		nil,
+		jsonRPCLimitExceeded,
+		"Too Many Requests",
+	)
+}
+
+// JSONRPCInvalidRequest returns error of the request rejected before it is parsed
+func JSONRPCInvalidRequest(msg string) interface{} {
+	return jsonRPCError(
+		nil,
+		jsonRPCInvalidRequest,
+		msg,
+	)
+}
+
+// JSONRPCTimeout returns error of the request which deadline has passed
+func JSONRPCTimeout() interface{} {
+	return jsonRPCError(
+		nil,
+		jsonRPCInternal,
+		"Request Timeout",
+	)
+}
+
+func errorResponse(id interface{}, jsonCode int, msg string) RPCResponse {
+	return RPCResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error: &rpcError{
+			Code:    jsonCode,
+			Message: msg,
+		},
+	}
+}
+
+// MethodNotAllowedResponse returns error response for the rejected method
+func MethodNotAllowedResponse(id interface{}, method string) RPCResponse {
+	return errorResponse(id, jsonRPCMethodNotFound, fmt.Sprintf("method %s is not allowed", method))
+}
+
+// PermissionDeniedResponse returns error response for the method the client has no permission to call
+func PermissionDeniedResponse(id interface{}, method, permission string) RPCResponse {
+	return errorResponse(id, jsonRPCMethodNotFound, fmt.Sprintf("missing permission to invoke '%s' (need '%s')", method, permission))
+}
+
+// InvalidParamsResponse returns error response for the request with invalid params
+func InvalidParamsResponse(id interface{}, msg string) RPCResponse {
+	return errorResponse(id, jsonRPCInvalidParams, msg)
+}
+
+// InternalErrorResponse returns error response for the request failed upstream
+func InternalErrorResponse(id interface{}, msg string) RPCResponse {
+	return errorResponse(id, jsonRPCInternal, msg)
+}
+
+func JSONInvalidRequest(message string) (*http.Response, error) {
+	return JSONRPCResponse(http.StatusBadRequest, jsonRPCError(nil, jsonRPCInvalidRequest, message))
+}
+
 func JSONInvalidResponse(message string) (*http.Response, error) {
 	return JSONRPCResponse(http.StatusBadRequest, jsonRPCError(nil, jsonRPCInvalidParams, message))
 }
@@ -282,6 +375,34 @@ func JSONRPCErrorResponse(httpCode int, data []byte) (*http.Response, error) {
 }
 
 func Request(
+	ctx context.Context,
+	url,
+	token string,
+	log *logrus.Entry,
+	debugHTTPRequest bool,
+	debugHTTPResponse bool,
+	requests RPCRequests,
+) (RPCResponses, []byte, error) {
+	return RequestWithClient(ctx, defaultSender{}, url, token, log, debugHTTPRequest, debugHTTPResponse, requests)
+}
+
+// Sender sends requests with the timeout of the called methods. It is implemented by upstream.Client
+type Sender interface {
+	Send(req *http.Request, methods ...string) (*http.Response, error)
+}
+
+// defaultSender sends requests by the default http transport without timeouts as upstream.Default does
+type defaultSender struct{}
+
+func (defaultSender) Send(req *http.Request, _ ...string) (*http.Response, error) {
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// RequestWithClient sends requests using the upstream client with the timeout of the methods.
+// The request is canceled along with the context
+func RequestWithClient(
+	ctx context.Context,
+	client Sender,
 	url,
 	token string,
 	log *logrus.Entry,
@@ -298,16 +419,18 @@ func Request(
 		return nil, nil, err
 	}
 	body := ioutil.NopCloser(bytes.NewBuffer(jsonBody))
-	req, err := http.NewRequest("POST", url, body)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
 	if err != nil {
 		return nil, nil, err
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
 	req.Header.Set("Content-Type", "application/json")
 	if debugHTTPRequest {
 		DebugRequest(req, log)
 	}
-	resp, err := (&http.Client{}).Do(req)
+	resp, err := client.Send(req, requests.Methods()...)
 	if err != nil {
 		return nil, nil, err
 	}
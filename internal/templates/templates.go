@@ -0,0 +1,288 @@
+package templates
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/jsonpath"
+	"github.com/protofire/filecoin-rpc-proxy/internal/utils"
+)
+
+const resultFunc = "result"
+
+// Context provides values for the template functions
+type Context struct {
+	// Height returns the current chain head height
+	Height func() (int64, error)
+	// Result returns the latest result of the custom method
+	Result func(method string) (interface{}, bool)
+}
+
+// Params keeps request params with compiled template strings.
+// A string containing {{ is a template. Its output is decoded as JSON if possible and used as a string otherwise.
+//
+// Functions:
+//
+//	head                    current chain head height
+//	height <offset>         chain head height plus offset
+//	now                     current unix time in seconds
+//	result <method> <path>  JSON encoded value selected by the path from the custom method result
+type Params struct {
+	value     interface{}
+	templates map[string]*template.Template
+	deps      []string
+}
+
+// IsTemplate reports whether the params contain templates
+func IsTemplate(v interface{}) bool {
+	switch v := utils.NormalizeYAML(v).(type) {
+	case string:
+		return strings.Contains(v, "{{")
+	case map[string]interface{}:
+		for _, value := range v {
+			if IsTemplate(value) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if IsTemplate(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Compile compiles all template strings of the params
+func Compile(v interface{}) (*Params, error) {
+	p := &Params{
+		value:     utils.NormalizeYAML(v),
+		templates: make(map[string]*template.Template),
+	}
+	deps := make(map[string]bool)
+	if err := p.compile(p.value, deps); err != nil {
+		return nil, err
+	}
+	for dep := range deps {
+		p.deps = append(p.deps, dep)
+	}
+	sort.Strings(p.deps)
+	return p, nil
+}
+
+func (p *Params) compile(v interface{}, deps map[string]bool) error {
+	switch v := v.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			return nil
+		}
+		if _, ok := p.templates[v]; ok {
+			return nil
+		}
+		tmpl, err := template.New("params").Option("missingkey=error").Funcs(funcs(Context{})).Parse(v)
+		if err != nil {
+			return fmt.Errorf("cannot parse params template %q: %w", v, err)
+		}
+		if err := dependencies(tmpl.Tree.Root, deps); err != nil {
+			return fmt.Errorf("params template %q: %w", v, err)
+		}
+		p.templates[v] = tmpl
+	case map[string]interface{}:
+		for _, value := range v {
+			if err := p.compile(value, deps); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if err := p.compile(value, deps); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Dependencies returns custom methods whose results are used by the templates
+func (p *Params) Dependencies() []string {
+	return p.deps
+}
+
+// Render executes the templates and returns params for the request
+func (p *Params) Render(ctx Context) (interface{}, error) {
+	return p.render(p.value, funcs(ctx))
+}
+
+func (p *Params) render(v interface{}, fm template.FuncMap) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		tmpl, ok := p.templates[v]
+		if !ok {
+			return v, nil
+		}
+		tmpl, err := tmpl.Clone()
+		if err != nil {
+			return nil, err
+		}
+		buf := &bytes.Buffer{}
+		if err := tmpl.Funcs(fm).Execute(buf, nil); err != nil {
+			return nil, fmt.Errorf("cannot render params template %q: %w", v, err)
+		}
+		var res interface{}
+		if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+			return buf.String(), nil
+		}
+		return res, nil
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			rendered, err := p.render(value, fm)
+			if err != nil {
+				return nil, err
+			}
+			res[key] = rendered
+		}
+		return res, nil
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for idx, value := range v {
+			rendered, err := p.render(value, fm)
+			if err != nil {
+				return nil, err
+			}
+			res[idx] = rendered
+		}
+		return res, nil
+	default:
+		return v, nil
+	}
+}
+
+func funcs(ctx Context) template.FuncMap {
+	height := func() (int64, error) {
+		if ctx.Height == nil {
+			return 0, fmt.Errorf("chain head is unknown")
+		}
+		h, err := ctx.Height()
+		if err != nil {
+			return 0, err
+		}
+		if h == 0 {
+			return 0, fmt.Errorf("chain head is unknown")
+		}
+		return h, nil
+	}
+	return template.FuncMap{
+		"head": height,
+		"height": func(offset int64) (int64, error) {
+			h, err := height()
+			if err != nil {
+				return 0, err
+			}
+			return h + offset, nil
+		},
+		"now": func() int64 {
+			return time.Now().Unix()
+		},
+		resultFunc: func(method, path string) (string, error) {
+			if ctx.Result == nil {
+				return "", fmt.Errorf("no result of method %s", method)
+			}
+			result, ok := ctx.Result(method)
+			if !ok {
+				return "", fmt.Errorf("no result of method %s", method)
+			}
+			p, err := jsonpath.Parse(path)
+			if err != nil {
+				return "", err
+			}
+			values, err := p.Select(result)
+			if err != nil {
+				return "", fmt.Errorf("method %s result: %w", method, err)
+			}
+			var value interface{} = values
+			if len(values) == 1 {
+				value = values[0]
+			}
+			data, err := json.Marshal(value)
+			if err != nil {
+				return "", err
+			}
+			return string(data), nil
+		},
+	}
+}
+
+// dependencies collects method names passed to the result function as string literals
+func dependencies(node parse.Node, deps map[string]bool) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, node := range n.Nodes {
+			if err := dependencies(node, deps); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return dependencies(n.Pipe, deps)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			if err := dependencies(cmd, deps); err != nil {
+				return err
+			}
+		}
+	case *parse.CommandNode:
+		if len(n.Args) > 0 {
+			if ident, ok := n.Args[0].(*parse.IdentifierNode); ok && ident.Ident == resultFunc {
+				if len(n.Args) != 3 {
+					return fmt.Errorf("result function expects method and path arguments")
+				}
+				method, ok := n.Args[1].(*parse.StringNode)
+				if !ok {
+					return fmt.Errorf("result function expects method name as a string literal")
+				}
+				path, ok := n.Args[2].(*parse.StringNode)
+				if !ok {
+					return fmt.Errorf("result function expects path as a string literal")
+				}
+				if _, err := jsonpath.Parse(path.Text); err != nil {
+					return err
+				}
+				deps[method.Text] = true
+			}
+		}
+		for _, arg := range n.Args {
+			if err := dependencies(arg, deps); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return branchDependencies(&n.BranchNode, deps)
+	case *parse.RangeNode:
+		return branchDependencies(&n.BranchNode, deps)
+	case *parse.WithNode:
+		return branchDependencies(&n.BranchNode, deps)
+	}
+	return nil
+}
+
+func branchDependencies(n *parse.BranchNode, deps map[string]bool) error {
+	for _, node := range []parse.Node{n.Pipe, n.List, n.ElseList} {
+		if err := dependencies(node, deps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
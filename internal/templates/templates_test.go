@@ -0,0 +1,63 @@
+package templates
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsTemplate(t *testing.T) {
+	require.False(t, IsTemplate([]interface{}{"f01234", []interface{}{}}))
+	require.True(t, IsTemplate([]interface{}{"f01234", "{{ head }}"}))
+	require.True(t, IsTemplate(map[interface{}]interface{}{"key": []interface{}{"{{ now }}"}}))
+}
+
+func TestRender(t *testing.T) {
+	params, err := Compile([]interface{}{
+		"f01234",
+		"{{ height -900 }}",
+		`{{ result "Filecoin.ChainGetTipSetByHeight" "$.Cids" }}`,
+		"epoch-{{ head }}",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Filecoin.ChainGetTipSetByHeight"}, params.Dependencies())
+
+	cids := []interface{}{map[string]interface{}{"/": "bafy"}}
+	ctx := Context{
+		Height: func() (int64, error) { return 1000, nil },
+		Result: func(method string) (interface{}, bool) {
+			if method != "Filecoin.ChainGetTipSetByHeight" {
+				return nil, false
+			}
+			return map[string]interface{}{"Cids": cids}, true
+		},
+	}
+	rendered, err := params.Render(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"f01234", float64(100), cids, "epoch-1000"}, rendered)
+}
+
+func TestRenderMissing(t *testing.T) {
+	params, err := Compile([]interface{}{"{{ head }}"})
+	require.NoError(t, err)
+	_, err = params.Render(Context{Height: func() (int64, error) { return 0, nil }})
+	require.Error(t, err)
+
+	params, err = Compile([]interface{}{`{{ result "Filecoin.ChainHead" "$.Cids" }}`})
+	require.NoError(t, err)
+	_, err = params.Render(Context{})
+	require.Error(t, err)
+}
+
+func TestCompileInvalid(t *testing.T) {
+	for _, tmpl := range []string{
+		"{{ head ",
+		"{{ unknown }}",
+		`{{ result "Filecoin.ChainHead" }}`,
+		`{{ result .Method "$.Cids" }}`,
+		`{{ result "Filecoin.ChainHead" "Cids" }}`,
+	} {
+		_, err := Compile([]interface{}{tmpl})
+		require.Error(t, err, tmpl)
+	}
+}
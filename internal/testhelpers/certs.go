@@ -0,0 +1,129 @@
+package testhelpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+// Certificates are a test CA, a server certificate for 127.0.0.1 and a client certificate signed by the CA
+type Certificates struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// ClientCertFile and ClientKeyFile keep the client certificate
+	ClientCertFile string
+	ClientKeyFile  string
+	CAPool         *x509.CertPool
+	Client         tls.Certificate
+}
+
+type certificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newCertificate(template *x509.Certificate, parent *certificate) (*certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &certificate{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+// GenerateCertificates writes a test CA, a server and a client certificates to the directory.
+// The client certificate has the client name as the common name
+func GenerateCertificates(dir string, clientName string) (*Certificates, error) {
+	ca, err := newCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	server, err := newCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "proxy"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, ca)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: clientName},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, ca)
+	if err != nil {
+		return nil, err
+	}
+	certs := &Certificates{
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+
+		ClientCertFile: filepath.Join(dir, "client.pem"),
+		ClientKeyFile:  filepath.Join(dir, "client-key.pem"),
+		CAPool:         x509.NewCertPool(),
+	}
+	certs.CAPool.AddCert(ca.cert)
+	for file, data := range map[string][]byte{
+		certs.CAFile:   ca.certPEM,
+		certs.CertFile: server.certPEM,
+		certs.KeyFile:  server.keyPEM,
+
+		certs.ClientCertFile: client.certPEM,
+		certs.ClientKeyFile:  client.keyPEM,
+	} {
+		if err := ioutil.WriteFile(file, data, 0600); err != nil {
+			return nil, err
+		}
+	}
+	certs.Client, err = tls.X509KeyPair(client.certPEM, client.keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
@@ -0,0 +1,78 @@
+package updater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/matcher"
+	"github.com/protofire/filecoin-rpc-proxy/internal/proxy"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+	"github.com/protofire/filecoin-rpc-proxy/internal/upstream"
+	"github.com/stretchr/testify/require"
+)
+
+// entriesCache returns predefined entries and records deleted keys
+type entriesCache struct {
+	*cache.MemoryCache
+	entries []cache.Entry
+	deleted []string
+}
+
+func (c *entriesCache) Entries() ([]cache.Entry, error) {
+	return append([]cache.Entry{}, c.entries...), nil
+}
+
+func (c *entriesCache) Delete(_ context.Context, key string) error {
+	c.deleted = append(c.deleted, key)
+	return nil
+}
+
+func TestCacheRequestsByAccess(t *testing.T) {
+	conf, err := testhelpers.GetConfig("http://test.com", method)
+	require.NoError(t, err)
+	conf.CacheRefresh.AccessWindow = 3600
+	conf.CacheRefresh.EvictAfter = 7200
+	conf.CacheRefresh.MaxEntries = 2
+	require.NoError(t, conf.Validate())
+
+	now := time.Now()
+	entry := func(key string, lastAccess time.Duration, hits int64) cache.Entry {
+		return cache.Entry{
+			Key:        matcher.KeyPrefix + key,
+			Request:    requests.RPCRequest{JSONRPC: "2.0", Method: method, Params: []interface{}{key}},
+			Created:    now.Add(-10 * time.Hour),
+			LastAccess: now.Add(-lastAccess),
+			Hits:       hits,
+		}
+	}
+	cacheImp := &entriesCache{
+		MemoryCache: cache.NewMemoryCacheDefault(),
+		entries: []cache.Entry{
+			entry("warm", time.Minute, 10),
+			entry("stale", 90*time.Minute, 100),
+			entry("cold", 3*time.Hour, 100),
+			entry("hot", time.Minute, 50),
+			{
+				Key:        "legacy",
+				Request:    requests.RPCRequest{JSONRPC: "2.0", Method: method, Params: []interface{}{"legacy"}},
+				LastAccess: now,
+			},
+			entry("rare", time.Minute, 1),
+		},
+	}
+	cacher := proxy.NewResponseCache(cacheImp, matcher.FromConfig(conf))
+	updaterImp, err := FromConfig(conf, cacher, upstream.Default(), logger.Log)
+	require.NoError(t, err)
+
+	reqs := updaterImp.cacheRequests()
+	require.Len(t, reqs, 2)
+	require.Equal(t, []interface{}{"hot"}, reqs[0].Params)
+	require.Equal(t, []interface{}{"warm"}, reqs[1].Params)
+
+	require.NoError(t, updaterImp.evictCold(context.Background()))
+	require.Equal(t, []string{"legacy", matcher.KeyPrefix + "cold"}, cacheImp.deleted)
+}
@@ -0,0 +1,114 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/matcher"
+	"github.com/protofire/filecoin-rpc-proxy/internal/proxy"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+	"github.com/protofire/filecoin-rpc-proxy/internal/upstream"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdaterRetries(t *testing.T) {
+	var requestsCount int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requestsCount, 1) <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(requests.RPCResponse{JSONRPC: "2.0", ID: 1, Result: "result"})
+		require.NoError(t, err)
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfigWithCustomMethods(backend.URL, method)
+	require.NoError(t, err)
+	conf.Updater.RetryBackoff = 1
+	conf.Updater.RetryMaxBackoff = 2
+
+	cacher := proxy.NewResponseCache(
+		cache.NewMemoryCacheFromConfig(conf.CacheSettings.Memory),
+		matcher.FromConfig(conf),
+	)
+	updaterImp, err := FromConfig(conf, cacher, upstream.Default(), logger.Log)
+	require.NoError(t, err)
+
+	require.NoError(t, updaterImp.updateMethods(context.Background()))
+	require.Equal(t, int32(3), atomic.LoadInt32(&requestsCount))
+
+	status := updaterImp.Status()
+	require.Len(t, status, 1)
+	require.Equal(t, "methods", status[0].Name)
+	require.Equal(t, int64(1), status[0].Requests)
+	require.Equal(t, int64(0), status[0].Failed)
+	require.Empty(t, status[0].Error)
+}
+
+func TestUpdaterRemovesFailingEntries(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs, err := requests.ParseRequests(r)
+		require.NoError(t, err)
+		w.Header().Add("Content-Type", "application/json")
+		_, err = w.Write([]byte(`{"jsonrpc": "2.0", "id": ` + string(mustJSON(t, reqs[0].ID)) + `, "error": {"code": 1, "message": "actor not found"}}`))
+		require.NoError(t, err)
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfig(backend.URL, method)
+	require.NoError(t, err)
+	conf.Updater.MaxFailures = 2
+
+	cacher := proxy.NewResponseCache(
+		cache.NewMemoryCacheFromConfig(conf.CacheSettings.Memory),
+		matcher.FromConfig(conf),
+	)
+	updaterImp, err := FromConfig(conf, cacher, upstream.Default(), logger.Log)
+	require.NoError(t, err)
+
+	request := requests.RPCRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: []interface{}{"1"}}
+	response := requests.RPCResponse{JSONRPC: "2.0", ID: 1, Result: "result"}
+	require.NoError(t, cacher.SetResponseCache(context.Background(), request, response))
+
+	require.Error(t, updaterImp.updateCache(context.Background()))
+	require.Len(t, updaterImp.cacheRequests(), 1)
+
+	require.Error(t, updaterImp.updateCache(context.Background()))
+	require.Len(t, updaterImp.cacheRequests(), 0)
+
+	status := updaterImp.Status()
+	require.Len(t, status, 1)
+	require.Equal(t, "cache", status[0].Name)
+	require.Equal(t, int64(1), status[0].Failed)
+	require.Equal(t, int64(1), status[0].Removed)
+	require.NotEmpty(t, status[0].Error)
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return data
+}
+
+func TestFailuresLimit(t *testing.T) {
+	f := newFailures()
+	f.max = 2
+	first := requests.RPCRequest{Method: method, Params: []interface{}{1}}
+	require.Equal(t, 1, f.fail(first))
+	require.Equal(t, 2, f.fail(first))
+	f.reset(first)
+	require.Equal(t, 0, f.len())
+	for i := 0; i < 5; i++ {
+		f.fail(requests.RPCRequest{Method: method, Params: []interface{}{i}})
+	}
+	require.Equal(t, 2, f.len())
+}
@@ -0,0 +1,123 @@
+package updater
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/matcher"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+)
+
+// spreadSlots is the number of batches keys of a rule are spread over within the jitter
+const spreadSlots = 10
+
+// StartScheduledUpdater refreshes methods having their own refresh schedule
+func (u *Updater) StartScheduledUpdater(ctx context.Context) {
+	defer func() {
+		u.logger.Info("Exiting scheduled updater...")
+		atomic.AddInt32(&u.stopped, 1)
+	}()
+	wg := sync.WaitGroup{}
+	for _, refresh := range u.cacher.Matcher().Refreshes() {
+		wg.Add(1)
+		go func(refresh matcher.Refresh) {
+			defer wg.Done()
+			u.schedule(ctx, refresh)
+		}(refresh)
+	}
+	wg.Wait()
+}
+
+// schedule runs refreshes of the rule until the context is done.
+// A refresh is skipped if the previous one is still in flight
+func (u *Updater) schedule(ctx context.Context, refresh matcher.Refresh) {
+	var running int32
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+	for {
+		timer := time.NewTimer(nextRun(refresh, time.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if !u.isLeader() {
+			continue
+		}
+		if !atomic.CompareAndSwapInt32(&running, 0, 1) {
+			u.logger.Warnf("Skipping refresh of %s: previous refresh is still running", refresh.Name)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				atomic.StoreInt32(&running, 0)
+				wg.Done()
+			}()
+			if err := u.refresh(ctx, refresh); err != nil {
+				u.logger.Errorf("Cannot refresh %s: %v", refresh.Name, err)
+			}
+		}()
+	}
+}
+
+// nextRun returns delay before the next refresh. Refreshes of custom methods get random jitter,
+// keys of cached requests are spread within the jitter by the refresh itself
+func nextRun(refresh matcher.Refresh, now time.Time) time.Duration {
+	delay := refresh.Schedule.Next(now).Sub(now)
+	if refresh.Custom && refresh.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(refresh.Jitter))) // nolint
+	}
+	return delay
+}
+
+func (u *Updater) refresh(ctx context.Context, refresh matcher.Refresh) error {
+	scheduled := func(method string) bool {
+		r, ok := u.cacher.Matcher().RefreshOf(method)
+		return ok && r.ID == refresh.ID
+	}
+	return u.record(refresh.Name, func(run *refreshRun) error {
+		if refresh.Custom {
+			return u.updateCustom(ctx, run, scheduled)
+		}
+		reqs := u.cacheRequestsFor(scheduled)
+		if refresh.Jitter > 0 {
+			return u.spread(ctx, run, reqs, refresh.Jitter)
+		}
+		return u.update(ctx, run, reqs, nil)
+	})
+}
+
+// spread refreshes every key at a random offset within the jitter, so keys of one rule
+// are not requested in a single burst
+func (u *Updater) spread(ctx context.Context, run *refreshRun, reqs requests.RPCRequests, jitter time.Duration) error {
+	slots := make([]requests.RPCRequests, spreadSlots)
+	for _, req := range reqs {
+		slot := rand.Intn(spreadSlots) // nolint
+		slots[slot] = append(slots[slot], req)
+	}
+	multiErr := &multierror.Error{}
+	start := time.Now()
+	for idx, slotReqs := range slots {
+		if slotReqs.IsEmpty() {
+			continue
+		}
+		timer := time.NewTimer(time.Until(start.Add(jitter * time.Duration(idx) / spreadSlots)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return multiErr.ErrorOrNil()
+		case <-timer.C:
+		}
+		if err := u.update(ctx, run, slotReqs, nil); err != nil {
+			multiErr = multierror.Append(multiErr, err)
+		}
+	}
+	return multiErr.ErrorOrNil()
+}
@@ -0,0 +1,166 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/cache"
+	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
+	"github.com/protofire/filecoin-rpc-proxy/internal/matcher"
+	"github.com/protofire/filecoin-rpc-proxy/internal/proxy"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+	"github.com/protofire/filecoin-rpc-proxy/internal/upstream"
+	"github.com/stretchr/testify/require"
+)
+
+type everySchedule time.Duration
+
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+func TestNextRunJitter(t *testing.T) {
+	now := time.Now()
+	refresh := matcher.Refresh{Schedule: everySchedule(time.Minute), Jitter: time.Second, Custom: true}
+	for i := 0; i < 10; i++ {
+		delay := nextRun(refresh, now)
+		require.GreaterOrEqual(t, int64(delay), int64(time.Minute))
+		require.Less(t, int64(delay), int64(time.Minute+time.Second))
+	}
+	// keys of cached requests are spread by the refresh instead
+	refresh.Custom = false
+	require.Equal(t, time.Minute, nextRun(refresh, now))
+}
+
+func TestSpreadRefreshesKeysWithinJitter(t *testing.T) {
+	var lock sync.Mutex
+	var received []time.Time
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs, err := requests.ParseRequests(r)
+		require.NoError(t, err)
+		lock.Lock()
+		for range reqs {
+			received = append(received, time.Now())
+		}
+		lock.Unlock()
+		responses := make(requests.RPCResponses, 0, len(reqs))
+		for _, req := range reqs {
+			responses = append(responses, requests.RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: "result"})
+		}
+		w.Header().Add("Content-Type", "application/json")
+		if len(responses) == 1 {
+			require.NoError(t, json.NewEncoder(w).Encode(responses[0]))
+			return
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(responses))
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfig(backend.URL, method)
+	require.NoError(t, err)
+	cacher := proxy.NewResponseCache(
+		cache.NewMemoryCacheFromConfig(conf.CacheSettings.Memory),
+		matcher.FromConfig(conf),
+	)
+	updaterImp, err := FromConfig(conf, cacher, upstream.Default(), logger.Log)
+	require.NoError(t, err)
+
+	reqs := requests.RPCRequests{}
+	for id := 1; id <= 50; id++ {
+		reqs = append(reqs, requests.RPCRequest{JSONRPC: "2.0", ID: float64(id), Method: method, Params: []interface{}{id}})
+	}
+	jitter := 200 * time.Millisecond
+	start := time.Now()
+	require.NoError(t, updaterImp.spread(context.Background(), newRefreshRun("spread"), reqs, jitter))
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.Len(t, received, len(reqs))
+	first, last := received[0], received[0]
+	for _, at := range received {
+		if at.Before(first) {
+			first = at
+		}
+		if at.After(last) {
+			last = at
+		}
+	}
+	require.Less(t, int64(last.Sub(start)), int64(jitter+100*time.Millisecond))
+	require.Greater(t, int64(last.Sub(first)), int64(jitter/spreadSlots))
+}
+
+func TestScheduledUpdaterSkipsOverlapping(t *testing.T) {
+	var requestsCount int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requestsCount, 1)
+		time.Sleep(200 * time.Millisecond)
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		err := json.NewEncoder(w).Encode(requests.RPCResponse{JSONRPC: "2.0", ID: 1, Result: "result"})
+		require.NoError(t, err)
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfigWithCustomMethods(backend.URL, method)
+	require.NoError(t, err)
+	conf.CacheMethods[0].RefreshPeriod = 3600
+	require.NoError(t, conf.Validate())
+
+	cacher := proxy.NewResponseCache(
+		cache.NewMemoryCacheFromConfig(conf.CacheSettings.Memory),
+		matcher.FromConfig(conf),
+	)
+	updaterImp, err := FromConfig(conf, cacher, upstream.Default(), logger.Log)
+	require.NoError(t, err)
+
+	// scheduled methods are not requested by the global methods updater
+	require.NoError(t, updaterImp.updateMethods(context.Background()))
+	require.Equal(t, int32(0), atomic.LoadInt32(&requestsCount))
+
+	refreshes := cacher.Matcher().Refreshes()
+	require.Len(t, refreshes, 1)
+	refresh := refreshes[0]
+	refresh.Schedule = everySchedule(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	updaterImp.schedule(ctx, refresh)
+
+	count := atomic.LoadInt32(&requestsCount)
+	require.GreaterOrEqual(t, count, int32(2))
+	require.LessOrEqual(t, count, int32(3))
+}
+
+type follower struct{}
+
+func (follower) IsLeader() bool { return false }
+
+func (follower) Run(ctx context.Context) { <-ctx.Done() }
+
+func TestUpdaterSkipsWhenNotLeader(t *testing.T) {
+	conf, err := testhelpers.GetConfigWithCustomMethods("http://test.com", method)
+	require.NoError(t, err)
+	cacher := proxy.NewResponseCache(
+		cache.NewMemoryCacheFromConfig(conf.CacheSettings.Memory),
+		matcher.FromConfig(conf),
+	)
+	updaterImp, err := FromConfig(conf, cacher, upstream.Default(), logger.Log)
+	require.NoError(t, err)
+
+	calls := 0
+	update := func(context.Context) error {
+		calls++
+		return nil
+	}
+	require.True(t, updaterImp.run(context.Background(), update))
+	updaterImp.SetElector(follower{})
+	require.False(t, updaterImp.run(context.Background(), update))
+	require.Equal(t, 1, calls)
+}
@@ -0,0 +1,159 @@
+package updater
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/proxy"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/utils"
+)
+
+// refreshRun collects results of a single refresh
+type refreshRun struct {
+	name     string
+	started  time.Time
+	requests int64
+	failed   int64
+	removed  int64
+}
+
+func newRefreshRun(name string) *refreshRun {
+	return &refreshRun{name: name, started: time.Now()}
+}
+
+func (r *refreshRun) status(err error) proxy.RefreshStatus {
+	status := proxy.RefreshStatus{
+		Name:      r.name,
+		StartedAt: r.started,
+		Duration:  time.Since(r.started).String(),
+		Requests:  atomic.LoadInt64(&r.requests),
+		Failed:    atomic.LoadInt64(&r.failed),
+		Removed:   atomic.LoadInt64(&r.removed),
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// statuses keeps the last result of each refresh
+type statuses struct {
+	lock     sync.RWMutex
+	statuses map[string]proxy.RefreshStatus
+}
+
+func newStatuses() *statuses {
+	return &statuses{statuses: make(map[string]proxy.RefreshStatus)}
+}
+
+func (s *statuses) set(status proxy.RefreshStatus) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.statuses[status.Name] = status
+}
+
+func (s *statuses) list() []proxy.RefreshStatus {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	res := make([]proxy.RefreshStatus, 0, len(s.statuses))
+	for _, status := range s.statuses {
+		res = append(res, status)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// maxFailureCounts limits the number of requests with counted failures
+const maxFailureCounts = 10000
+
+// failures counts consecutive error responses by request
+type failures struct {
+	lock   sync.Mutex
+	counts map[string]int
+	max    int
+}
+
+func newFailures() *failures {
+	return &failures{counts: make(map[string]int), max: maxFailureCounts}
+}
+
+func failureKey(req requests.RPCRequest) string {
+	params, _ := json.Marshal(utils.NormalizeYAML(req.Params))
+	return req.Method + string(params)
+}
+
+// fail increments failures of the request and returns the number of consecutive failures
+func (f *failures) fail(req requests.RPCRequest) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	key := failureKey(req)
+	if _, ok := f.counts[key]; !ok && len(f.counts) >= f.max {
+		// drop an arbitrary counter to keep the size limited
+		for dropped := range f.counts {
+			delete(f.counts, dropped)
+			break
+		}
+	}
+	f.counts[key]++
+	return f.counts[key]
+}
+
+func (f *failures) len() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.counts)
+}
+
+func (f *failures) reset(req requests.RPCRequest) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.counts, failureKey(req))
+}
+
+// warmMethods keeps custom methods with responses stored by the updater
+type warmMethods struct {
+	lock    sync.RWMutex
+	methods map[string]bool
+}
+
+func newWarmMethods() *warmMethods {
+	return &warmMethods{methods: make(map[string]bool)}
+}
+
+func (w *warmMethods) set(method string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.methods[method] = true
+}
+
+func (w *warmMethods) has(method string) bool {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.methods[method]
+}
+
+// Status returns the last result of each refresh
+func (u *Updater) Status() []proxy.RefreshStatus {
+	return u.statuses.list()
+}
+
+// Warmed reports whether every custom method without its own refresh schedule has been stored by the updater.
+// Once warmed the updater stays warmed
+func (u *Updater) Warmed() bool {
+	if atomic.LoadInt32(&u.warmed) == 1 {
+		return true
+	}
+	for _, method := range u.cacher.Matcher().Methods() {
+		if _, scheduled := u.cacher.Matcher().RefreshOf(method.Name); !scheduled && !u.warm.has(method.Name) {
+			return false
+		}
+	}
+	atomic.StoreInt32(&u.warmed, 1)
+	return true
+}
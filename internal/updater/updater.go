@@ -2,6 +2,9 @@ package updater
 
 import (
 	"context"
+	"fmt"
+	"math/rand"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,10 +15,15 @@ import (
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/auth"
 	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/leader"
+	"github.com/protofire/filecoin-rpc-proxy/internal/matcher"
+	"github.com/protofire/filecoin-rpc-proxy/internal/metrics"
 
 	"github.com/hashicorp/go-multierror"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/templates"
+	"github.com/protofire/filecoin-rpc-proxy/internal/upstream"
 
 	"github.com/sirupsen/logrus"
 )
@@ -24,10 +32,20 @@ type Updater struct {
 	cacher            proxy.ResponseCacher
 	logger            *logrus.Entry
 	url               string
-	token             string
+	token             auth.TokenSource
 	stopped           int32
+	warmed            int32
+	legacyPurged      int32
 	debugHTTPRequest  bool
 	debugHTTPResponse bool
+	head              proxy.HeadSource
+	refreshSettings   config.CacheRefreshSettings
+	elector           leader.Elector
+	client            *upstream.Client
+	settings          config.UpdaterSettings
+	failures          *failures
+	statuses          *statuses
+	warm              *warmMethods
 	batchSize         int
 	concurrency       int
 }
@@ -35,7 +53,8 @@ type Updater struct {
 func New(
 	cacher proxy.ResponseCacher,
 	logger *logrus.Entry,
-	url, token string,
+	url string,
+	token auth.TokenSource,
 	batchSize int,
 	concurrency int,
 	debugHTTPRequest bool,
@@ -50,48 +69,90 @@ func New(
 		concurrency:       concurrency,
 		debugHTTPRequest:  debugHTTPRequest,
 		debugHTTPResponse: debugHTTPResponse,
+		client:            upstream.Default(),
+		failures:          newFailures(),
+		statuses:          newStatuses(),
+		warm:              newWarmMethods(),
 	}
 	return u
 }
 
-func FromConfig(conf *config.Config, cacher proxy.ResponseCacher, logger *logrus.Entry) (*Updater, error) {
-	token, err := auth.NewJWT(conf.JWT(), conf.JWTAlgorithm, conf.JWTPermissions)
+// FromConfig initializes updater from config. Refresh requests share the upstream client with the proxy
+func FromConfig(conf *config.Config, cacher proxy.ResponseCacher, client *upstream.Client, logger *logrus.Entry) (*Updater, error) {
+	token, err := auth.ProxyTokenFromConfig(conf)
 	if err != nil {
 		return nil, err
 	}
-	logger.Infof("Proxy token: %s", string(token))
-	return New(
+	u := New(
 		cacher,
 		logger,
 		conf.ProxyURL,
-		string(token),
+		token,
 		conf.RequestsBatchSize,
 		conf.RequestsConcurrency,
 		conf.DebugHTTPRequest,
 		conf.DebugHTTPResponse,
-	), nil
+	)
+	u.refreshSettings = conf.CacheRefresh
+	u.settings = conf.Updater
+	u.client = client.WithDefaultTimeout(time.Duration(conf.Updater.Timeout) * time.Second)
+	return u, nil
 }
 
-func (u *Updater) start(ctx context.Context, update func() error, period int) {
+// SetElector sets elector deciding whether the replica runs refreshes
+func (u *Updater) SetElector(elector leader.Elector) {
+	u.elector = elector
+}
+
+func (u *Updater) isLeader() bool {
+	return u.elector == nil || u.elector.IsLeader()
+}
+
+// SetHeadSource sets chain head source used by params templates
+func (u *Updater) SetHeadSource(head proxy.HeadSource) {
+	u.head = head
+}
+
+// leaderCheckPeriod is a period of checks whether a follower became the leader and should run skipped updates
+const leaderCheckPeriod = time.Second
+
+func (u *Updater) start(ctx context.Context, update func(ctx context.Context) error, period int) {
 
 	ticker := time.NewTicker(time.Second * time.Duration(period))
+	leaderTicker := time.NewTicker(leaderCheckPeriod)
+	defer func() {
+		ticker.Stop()
+		leaderTicker.Stop()
+	}()
 
-	if err := update(); err != nil {
-		u.logger.Errorf("cannot update requests: %v", err)
-	}
+	skipped := !u.run(ctx, update)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			if err := update(); err != nil {
-				u.logger.Errorf("cannot update requests: %v", err)
+			skipped = !u.run(ctx, update)
+		case <-leaderTicker.C:
+			if skipped && u.isLeader() {
+				skipped = !u.run(ctx, update)
 			}
 		}
 	}
 }
 
+// run calls update if the replica is the leader. Returns false if the update was skipped
+func (u *Updater) run(ctx context.Context, update func(ctx context.Context) error) bool {
+	if !u.isLeader() {
+		u.logger.Debug("Skipping update: the replica is not the leader")
+		return false
+	}
+	if err := update(ctx); err != nil {
+		u.logger.Errorf("cannot update requests: %v", err)
+	}
+	return true
+}
+
 func (u *Updater) StartMethodUpdater(ctx context.Context, period int) {
 	defer func() {
 		u.logger.Info("Exiting methods updater...")
@@ -122,58 +183,342 @@ func (u *Updater) StopWithTimeout(ctx context.Context, waitFor int) bool {
 	}
 }
 
-func (u *Updater) methodRequests() requests.RPCRequests {
-	reqs := requests.RPCRequests{}
-	counter := float64(1)
-	for _, method := range u.cacher.Matcher().Methods() {
-		reqs = append(reqs, requests.RPCRequest{
-			JSONRPC: "2.0",
-			ID:      counter,
-			Method:  method.Name,
-			Params:  method.Params,
-		})
-		counter++
+// methodResults keeps results of custom methods fetched during the update round
+type methodResults struct {
+	lock    sync.RWMutex
+	results map[string]interface{}
+}
+
+func newMethodResults() *methodResults {
+	return &methodResults{results: make(map[string]interface{})}
+}
+
+func (r *methodResults) set(method string, result interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.results[method] = result
+}
+
+func (r *methodResults) get(method string) (interface{}, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	result, ok := r.results[method]
+	return result, ok
+}
+
+func (r *methodResults) has(methods []string) bool {
+	for _, method := range methods {
+		if _, ok := r.get(method); !ok {
+			return false
+		}
 	}
-	return reqs
+	return true
+}
+
+func (u *Updater) height() (int64, error) {
+	if u.head == nil {
+		return 0, fmt.Errorf("chain head source is not set")
+	}
+	return u.head.Height(), nil
 }
 
+func (u *Updater) renderParams(params interface{}, tmpl *templates.Params, results *methodResults) (interface{}, error) {
+	if tmpl == nil {
+		return params, nil
+	}
+	return tmpl.Render(templates.Context{
+		Height: u.height,
+		Result: results.get,
+	})
+}
+
+// cacheRequests returns cached requests of methods without their own refresh schedule
 func (u *Updater) cacheRequests() requests.RPCRequests {
+	return u.cacheRequestsFor(func(method string) bool {
+		_, scheduled := u.cacher.Matcher().RefreshOf(method)
+		return !scheduled
+	})
+}
+
+// cacheRequestsFor returns cached requests accessed within the refresh window ordered by hit rate
+func (u *Updater) cacheRequestsFor(filter func(method string) bool) requests.RPCRequests {
 	reqs := requests.RPCRequests{}
-	counter := float64(1)
-	cacheReqs, err := u.cacher.Cacher().Requests()
+	entries, err := u.cacher.Cacher().Entries()
 	if err != nil {
 		u.logger.Errorf("Cannot get cache requests: %v", err)
 		return reqs
 	}
-	for _, req := range cacheReqs {
-		if !u.cacher.Matcher().IsUpdatable(req.Method) {
+	now := time.Now()
+	window := time.Duration(u.refreshSettings.AccessWindow) * time.Second
+	hot := entries[:0]
+	for _, entry := range entries {
+		if !u.cacher.Matcher().IsUpdatable(entry.Request.Method) || !filter(entry.Request.Method) {
 			continue
 		}
-		req.ID = counter
+		if window > 0 && now.Sub(entry.LastAccess) > window {
+			continue
+		}
+		hot = append(hot, entry)
+	}
+	sort.SliceStable(hot, func(i, j int) bool {
+		return hot[i].HitRate(now) > hot[j].HitRate(now)
+	})
+	if u.refreshSettings.MaxEntries > 0 && len(hot) > u.refreshSettings.MaxEntries {
+		hot = hot[:u.refreshSettings.MaxEntries]
+	}
+	for idx, entry := range hot {
+		req := entry.Request
+		req.ID = float64(idx + 1)
 		reqs = append(reqs, req)
-		counter++
 	}
 	return reqs
 }
 
-func (u *Updater) updateMethods() error {
-	if reqs := u.methodRequests(); !reqs.IsEmpty() {
-		return u.update(reqs)
+// purgeLegacy deletes entries stored with keys of a previous format once. Such entries are never read
+func (u *Updater) purgeLegacy(ctx context.Context) error {
+	if atomic.LoadInt32(&u.legacyPurged) == 1 {
+		return nil
+	}
+	entries, err := u.cacher.Cacher().Entries()
+	if err != nil {
+		return err
+	}
+	purged := 0
+	for _, entry := range entries {
+		if matcher.IsCurrentKey(entry.Key) {
+			continue
+		}
+		if err := u.cacher.Cacher().Delete(ctx, entry.Key); err != nil {
+			return err
+		}
+		purged++
+	}
+	if purged > 0 {
+		u.logger.Infof("Purged %d cache records with keys of a previous format", purged)
 	}
+	atomic.StoreInt32(&u.legacyPurged, 1)
 	return nil
 }
 
-func (u *Updater) updateCache() error {
-	if reqs := u.cacheRequests(); !reqs.IsEmpty() {
-		return u.update(reqs)
+// evictCold deletes expired entries, entries with keys of a previous format and user requests
+// not accessed for the eviction period
+func (u *Updater) evictCold(ctx context.Context) error {
+	expired, err := u.cacher.Cacher().EvictExpired(ctx)
+	if err != nil {
+		return err
 	}
-	return nil
+	if expired > 0 {
+		u.logger.Infof("Evicted %d expired cache records", expired)
+	}
+	if err := u.purgeLegacy(ctx); err != nil {
+		return err
+	}
+	if u.refreshSettings.EvictAfter <= 0 {
+		return nil
+	}
+	entries, err := u.cacher.Cacher().Entries()
+	if err != nil {
+		return err
+	}
+	custom := make(map[string]bool)
+	for _, method := range u.cacher.Matcher().Methods() {
+		custom[method.Name] = true
+	}
+	evictAfter := time.Duration(u.refreshSettings.EvictAfter) * time.Second
+	multiErr := &multierror.Error{}
+	evicted := 0
+	for _, entry := range entries {
+		if custom[entry.Request.Method] || time.Since(entry.LastAccess) <= evictAfter {
+			continue
+		}
+		if err := u.cacher.Cacher().Delete(ctx, entry.Key); err != nil {
+			multiErr = multierror.Append(multiErr, err)
+			continue
+		}
+		u.failures.reset(entry.Request)
+		evicted++
+	}
+	if evicted > 0 {
+		u.logger.Infof("Evicted %d cold cache records", evicted)
+	}
+	return multiErr.ErrorOrNil()
 }
 
-func (u *Updater) update(reqs requests.RPCRequests) error {
+// updateMethods requests custom methods without their own refresh schedule
+func (u *Updater) updateMethods(ctx context.Context) error {
+	return u.record("methods", func(run *refreshRun) error {
+		return u.updateCustom(ctx, run, func(method string) bool {
+			_, scheduled := u.cacher.Matcher().RefreshOf(method)
+			return !scheduled
+		})
+	})
+}
+
+// record runs the refresh and keeps its result for the status endpoint
+func (u *Updater) record(name string, refresh func(run *refreshRun) error) error {
+	run := newRefreshRun(name)
+	err := refresh(run)
+	metrics.SetUpdaterCycleDuration(name, time.Since(run.started).Milliseconds())
+	u.statuses.set(run.status(err))
+	return err
+}
+
+// withDependencies returns the filtered methods along with all methods their params templates depend on
+func withDependencies(all matcher.CustomMethods, filter func(method string) bool) matcher.CustomMethods {
+	byName := make(map[string]matcher.CustomMethod, len(all))
+	for _, method := range all {
+		byName[method.Name] = method
+	}
+	selected := make(map[string]bool)
+	var visit func(method matcher.CustomMethod)
+	visit = func(method matcher.CustomMethod) {
+		if selected[method.Name] {
+			return
+		}
+		selected[method.Name] = true
+		if method.Template == nil {
+			return
+		}
+		for _, dep := range method.Template.Dependencies() {
+			if depMethod, ok := byName[dep]; ok {
+				visit(depMethod)
+			}
+		}
+	}
+	for _, method := range all {
+		if filter(method.Name) {
+			visit(method)
+		}
+	}
+	var res matcher.CustomMethods
+	for _, method := range all {
+		if selected[method.Name] {
+			res = append(res, method)
+		}
+	}
+	return res
+}
+
+// updateCustom requests the filtered custom methods in stages.
+// Methods with params templates using results of other methods are requested after their dependencies
+func (u *Updater) updateCustom(ctx context.Context, run *refreshRun, filter func(method string) bool) error {
+	multiErr := &multierror.Error{}
+	results := newMethodResults()
+	pending := withDependencies(u.cacher.Matcher().Methods(), filter)
+	for len(pending) > 0 {
+		reqs := requests.RPCRequests{}
+		deferred := pending[:0:0]
+		counter := float64(1)
+		for _, method := range pending {
+			if method.Template != nil && !results.has(method.Template.Dependencies()) {
+				deferred = append(deferred, method)
+				continue
+			}
+			params, err := u.renderParams(method.Params, method.Template, results)
+			if err != nil {
+				multiErr = multierror.Append(multiErr, fmt.Errorf("cannot prepare params for method %s: %w", method.Name, err))
+				continue
+			}
+			reqs = append(reqs, requests.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      counter,
+				Method:  method.Name,
+				Params:  params,
+			})
+			counter++
+		}
+		if reqs.IsEmpty() {
+			for _, method := range deferred {
+				multiErr = multierror.Append(multiErr, fmt.Errorf("method %s: params template dependencies are not available", method.Name))
+			}
+			break
+		}
+		if err := u.update(ctx, run, reqs, results); err != nil {
+			multiErr = multierror.Append(multiErr, err)
+		}
+		pending = deferred
+	}
+	return multiErr.ErrorOrNil()
+}
+
+func (u *Updater) updateCache(ctx context.Context) error {
+	return u.record("cache", func(run *refreshRun) error {
+		if err := u.evictCold(ctx); err != nil {
+			u.logger.Errorf("Cannot evict cold cache records: %v", err)
+		}
+		if reqs := u.cacheRequests(); !reqs.IsEmpty() {
+			return u.update(ctx, run, reqs, nil)
+		}
+		return nil
+	})
+}
+
+// request sends the batch retrying failed attempts with exponential backoff and jitter.
+// Retries stop when the context is done
+func (u *Updater) request(ctx context.Context, reqs requests.RPCRequests) (requests.RPCResponses, error) {
+	backoff := time.Duration(u.settings.RetryBackoff) * time.Millisecond
+	maxBackoff := time.Duration(u.settings.RetryMaxBackoff) * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		token, err := u.token.Token()
+		if err == nil {
+			var responses requests.RPCResponses
+			responses, _, err = requests.RequestWithClient(ctx, u.client, u.url, token, u.logger, u.debugHTTPRequest, u.debugHTTPResponse, reqs)
+			if err == nil {
+				return responses, nil
+			}
+		}
+		if attempt >= u.settings.Retries || ctx.Err() != nil {
+			return nil, err
+		}
+		delay := backoff
+		if delay > 0 {
+			// random delay within [backoff/2, backoff)
+			delay = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)) // nolint
+		}
+		u.logger.Warnf("Cannot update %d cache records, retrying in %s: %v", len(reqs), delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// fail counts the error response of the request and removes the request from the cache
+// after too many consecutive failures. Custom methods are never removed
+func (u *Updater) fail(ctx context.Context, run *refreshRun, req requests.RPCRequest) {
+	atomic.AddInt64(&run.failed, 1)
+	if u.settings.MaxFailures <= 0 || u.failures.fail(req) < u.settings.MaxFailures {
+		return
+	}
+	for _, method := range u.cacher.Matcher().Methods() {
+		if method.Name == req.Method {
+			return
+		}
+	}
+	u.failures.reset(req)
+	for _, key := range u.cacher.Matcher().Keys(req.Method, req.Params) {
+		if err := u.cacher.Cacher().Delete(ctx, key.Key); err != nil {
+			u.logger.Errorf("Cannot remove failing cache record: %v", err)
+			return
+		}
+	}
+	u.logger.Warnf("Removed cache record of method %s after %d consecutive failures", req.Method, u.settings.MaxFailures)
+	atomic.AddInt64(&run.removed, 1)
+}
+
+// update requests upstream and stores responses in the cache. Results are collected if results is not nil
+func (u *Updater) update(ctx context.Context, run *refreshRun, reqs requests.RPCRequests, results *methodResults) error {
 	if reqs.IsEmpty() {
 		return nil
 	}
+	atomic.AddInt64(&run.requests, int64(len(reqs)))
+	metrics.AddUpdaterQueueDepth(len(reqs))
 
 	ch := make(chan struct{}, u.concurrency)
 	errs := make(chan error, u.concurrency)
@@ -197,14 +542,19 @@ func (u *Updater) update(reqs requests.RPCRequests) error {
 			go func(reqs requests.RPCRequests) {
 
 				defer func() {
+					metrics.AddUpdaterQueueDepth(-len(reqs))
 					wg.Done()
 					<-ch
 				}()
 
 				u.logger.Infof("Updating %d cache records...", len(reqs))
-				responses, _, err := requests.Request(u.url, u.token, u.logger, u.debugHTTPRequest, u.debugHTTPResponse, reqs)
+				start := time.Now()
+				responses, err := u.request(ctx, reqs)
+				metrics.SetUpdaterBatchDuration(time.Since(start).Milliseconds())
 				u.logger.Infof("Got %d responses", len(responses))
 				if err != nil {
+					atomic.AddInt64(&run.failed, int64(len(reqs)))
+					metrics.SetUpdaterFailedCounterByMethods(reqs.Methods()...)
 					errs <- err
 					return
 				}
@@ -212,16 +562,30 @@ func (u *Updater) update(reqs requests.RPCRequests) error {
 				multiErr := &multierror.Error{}
 
 				for _, resp := range responses {
+					req, ok := reqs.FindByID(resp.ID)
 					if resp.Error != nil {
-						errs <- resp.Error
+						if ok {
+							metrics.SetUpdaterFailedCounterByMethods(req.Method)
+							u.fail(ctx, run, req)
+						}
+						multiErr = multierror.Append(multiErr, resp.Error)
 						continue
 					}
-					req, ok := reqs.FindByID(resp.ID)
 					u.logger.Infof("Processing response ID %v...", resp.ID)
 					if ok {
+						u.failures.reset(req)
+						if results != nil {
+							results.set(req.Method, resp.Result)
+						}
 						u.logger.Infof("Setting response cache for request: %#v", req)
-						if err := u.cacher.SetResponseCache(req, resp); err != nil {
+						if err := u.cacher.SetResponseCache(ctx, req, resp); err != nil {
 							multiErr = multierror.Append(multiErr, err)
+							continue
+						}
+						metrics.SetUpdaterRefreshedCounterByMethod(req.Method)
+						if results != nil {
+							u.warm.set(req.Method)
+							metrics.SetUpdaterLastSuccess(req.Method, time.Now())
 						}
 					}
 				}
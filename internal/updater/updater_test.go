@@ -22,6 +22,7 @@ import (
 	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+	"github.com/protofire/filecoin-rpc-proxy/internal/upstream"
 
 	"github.com/protofire/filecoin-rpc-proxy/internal/logger"
 	"github.com/stretchr/testify/require"
@@ -73,8 +74,9 @@ func TestMethodsUpdater(t *testing.T) {
 		cache.NewMemoryCacheFromConfig(conf.CacheSettings.Memory),
 		matcher.FromConfig(conf),
 	)
-	updaterImp, err := FromConfig(conf, cacher, logger.Log)
+	updaterImp, err := FromConfig(conf, cacher, upstream.Default(), logger.Log)
 	require.NoError(t, err)
+	require.False(t, updaterImp.Warmed())
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -85,16 +87,22 @@ func TestMethodsUpdater(t *testing.T) {
 	updaterImp.StopWithTimeout(ctxStop, 1)
 	defer cancel()
 
+	err = updaterImp.updateCustom(context.Background(), newRefreshRun("methods"), func(string) bool { return true })
+	require.NoError(t, err)
 	lock.Lock()
 	require.GreaterOrEqual(t, requestsCount, 1)
 	lock.Unlock()
 
-	reqs := updaterImp.methodRequests()
-	require.NotEqual(t, 0, len(reqs))
-	cachedResp, err := updaterImp.cacher.GetResponseCache(reqs[0])
+	cachedResp, err := updaterImp.cacher.GetResponseCache(context.Background(), requests.RPCRequest{
+		JSONRPC: "2.0",
+		ID:      requestID,
+		Method:  method,
+		Params:  params,
+	})
 	require.NoError(t, err)
 	require.False(t, cachedResp.IsEmpty())
 	require.True(t, utils.Equal(cachedResp.ID, response.ID))
+	require.True(t, updaterImp.Warmed())
 
 }
 
@@ -142,12 +150,12 @@ func TestCacheUpdater(t *testing.T) {
 		cache.NewMemoryCacheFromConfig(conf.CacheSettings.Memory),
 		matcher.FromConfig(conf),
 	)
-	updaterImp, err := FromConfig(conf, cacher, logger.Log)
+	updaterImp, err := FromConfig(conf, cacher, upstream.Default(), logger.Log)
 	require.NoError(t, err)
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	err = updaterImp.cacher.SetResponseCache(request, response)
+	err = updaterImp.cacher.SetResponseCache(context.Background(), request, response)
 	require.NoError(t, err)
 
 	go updaterImp.StartCacheUpdater(ctx, 1)
@@ -157,11 +165,13 @@ func TestCacheUpdater(t *testing.T) {
 	updaterImp.StopWithTimeout(ctxStop, 1)
 	defer cancel()
 
+	err = updaterImp.updateCache(context.Background())
+	require.NoError(t, err)
 	lock.Lock()
 	require.GreaterOrEqual(t, requestsCount, 1)
 	lock.Unlock()
 
-	cachedResp, err := updaterImp.cacher.GetResponseCache(request)
+	cachedResp, err := updaterImp.cacher.GetResponseCache(context.Background(), request)
 	require.NoError(t, err)
 	require.False(t, cachedResp.IsEmpty())
 	require.True(t, utils.Equal(cachedResp.ID, response.ID))
@@ -213,10 +223,10 @@ func TestRedisCacheUpdater(t *testing.T) {
 	require.NoError(t, err)
 
 	cacher := proxy.NewResponseCache(cacheImpl, matcher.FromConfig(conf))
-	updaterImp, err := FromConfig(conf, cacher, logger.Log)
+	updaterImp, err := FromConfig(conf, cacher, upstream.Default(), logger.Log)
 	require.NoError(t, err)
 
-	err = updaterImp.cacher.SetResponseCache(request, response)
+	err = updaterImp.cacher.SetResponseCache(context.Background(), request, response)
 	require.NoError(t, err)
 
 	go updaterImp.StartCacheUpdater(ctx, 1)
@@ -241,7 +251,7 @@ func TestRedisCacheUpdater(t *testing.T) {
 	require.GreaterOrEqual(t, requestsCount, 1)
 	lock.Unlock()
 
-	cachedResp, err := updaterImp.cacher.GetResponseCache(request)
+	cachedResp, err := updaterImp.cacher.GetResponseCache(context.Background(), request)
 	require.NoError(t, err)
 	require.False(t, cachedResp.IsEmpty())
 	require.True(t, utils.Equal(cachedResp.ID, response.ID))
@@ -303,7 +313,7 @@ func TestMethodsUpdaterConcurrency(t *testing.T) {
 		cache.NewMemoryCacheFromConfig(conf.CacheSettings.Memory),
 		matcher.FromConfig(conf),
 	)
-	updaterImp, err := FromConfig(conf, cacher, logger.Log)
+	updaterImp, err := FromConfig(conf, cacher, upstream.Default(), logger.Log)
 	require.NoError(t, err)
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -337,3 +347,64 @@ func TestMethodsUpdaterConcurrency(t *testing.T) {
 	lock.Unlock()
 
 }
+
+type testHead int64
+
+func (h testHead) Height() int64 {
+	return int64(h)
+}
+
+func TestMethodsUpdaterTemplates(t *testing.T) {
+
+	tipsetMethod := "Filecoin.ChainGetTipSetByHeight"
+	powerMethod := "Filecoin.StateMinerPower"
+	cids := []interface{}{map[string]interface{}{"/": "bafy"}}
+
+	var received requests.RPCRequests
+	lock := sync.Mutex{}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs, err := requests.ParseRequests(r)
+		require.NoError(t, err)
+		lock.Lock()
+		received = append(received, reqs...)
+		lock.Unlock()
+		var result interface{} = "power"
+		if reqs[0].Method == tipsetMethod {
+			result = map[string]interface{}{"Cids": cids}
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		err = json.NewEncoder(w).Encode(requests.RPCResponse{JSONRPC: "2.0", ID: reqs[0].ID, Result: result})
+		require.NoError(t, err)
+	}))
+	defer backend.Close()
+
+	conf, err := testhelpers.GetConfigWithCustomMethods(backend.URL, powerMethod, tipsetMethod)
+	require.NoError(t, err)
+	conf.CacheMethods[0].ParamsForRequest = []interface{}{"f01234", `{{ result "Filecoin.ChainGetTipSetByHeight" "$.Cids" }}`}
+	conf.CacheMethods[1].ParamsForRequest = []interface{}{"{{ height -900 }}", []interface{}{}}
+	require.NoError(t, conf.Validate())
+
+	cacher := proxy.NewResponseCache(
+		cache.NewMemoryCacheFromConfig(conf.CacheSettings.Memory),
+		matcher.FromConfig(conf),
+	)
+	updaterImp, err := FromConfig(conf, cacher, upstream.Default(), logger.Log)
+	require.NoError(t, err)
+	updaterImp.SetHeadSource(testHead(1000))
+
+	require.NoError(t, updaterImp.updateMethods(context.Background()))
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.Len(t, received, 2)
+	require.Equal(t, tipsetMethod, received[0].Method)
+	require.Equal(t, []interface{}{float64(100), []interface{}{}}, received[0].Params)
+	require.Equal(t, powerMethod, received[1].Method)
+	require.Equal(t, []interface{}{"f01234", cids}, received[1].Params)
+
+	cachedResp, err := cacher.GetResponseCache(context.Background(), received[1])
+	require.NoError(t, err)
+	require.Equal(t, "power", cachedResp.Result)
+}
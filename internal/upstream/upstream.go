@@ -0,0 +1,141 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/certs"
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+)
+
+// tls handshake and 100-continue timeouts as in http.DefaultTransport
+const (
+	tlsHandshakeTimeout   = 10 * time.Second
+	expectContinueTimeout = time.Second
+)
+
+// Client sends requests to upstream nodes. The embedded http client applies the overall timeout
+type Client struct {
+	*http.Client
+	timeout        time.Duration
+	methodTimeouts map[string]time.Duration
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// Default returns the client with default http transport and without timeouts
+func Default() *Client {
+	return &Client{
+		Client: &http.Client{Transport: http.DefaultTransport},
+	}
+}
+
+// New initializes the client with its own transport
+func New(settings config.UpstreamSettings) (*Client, error) {
+	tlsConfig, err := certs.ClientConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   seconds(settings.DialTimeout),
+			KeepAlive: seconds(settings.KeepAlive),
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     !settings.DisableHTTP2,
+		DisableKeepAlives:     settings.DisableKeepAlives,
+		MaxIdleConns:          settings.MaxIdleConns,
+		MaxIdleConnsPerHost:   settings.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       settings.MaxConnsPerHost,
+		IdleConnTimeout:       seconds(settings.IdleConnTimeout),
+		ResponseHeaderTimeout: seconds(settings.ResponseHeaderTimeout),
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ExpectContinueTimeout: expectContinueTimeout,
+	}
+	if settings.DisableHTTP2 {
+		// non-nil empty map disables HTTP/2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	c := &Client{
+		Client: &http.Client{
+			Transport: transport,
+			Timeout:   seconds(settings.Timeout),
+		},
+		timeout:        seconds(settings.Timeout),
+		methodTimeouts: make(map[string]time.Duration, len(settings.Methods)),
+	}
+	for _, method := range settings.Methods {
+		c.methodTimeouts[method.Name] = seconds(method.Timeout)
+	}
+	return c, nil
+}
+
+// FromConfig initializes the client from config
+func FromConfig(c *config.Config) (*Client, error) {
+	return New(c.Upstream)
+}
+
+// WithDefaultTimeout returns the client sharing the transport and method timeouts
+// with the timeout of methods without their own timeout
+func (c *Client) WithDefaultTimeout(timeout time.Duration) *Client {
+	client := *c
+	client.timeout = timeout
+	return &client
+}
+
+// Timeout returns the timeout of the request calling the methods. The longest method timeout wins.
+// Zero means no timeout
+func (c *Client) Timeout(methods ...string) time.Duration {
+	if len(methods) == 0 {
+		return c.timeout
+	}
+	var timeout time.Duration
+	for _, method := range methods {
+		methodTimeout, ok := c.methodTimeouts[method]
+		if !ok {
+			methodTimeout = c.timeout
+		}
+		if methodTimeout == 0 {
+			return 0
+		}
+		if methodTimeout > timeout {
+			timeout = methodTimeout
+		}
+	}
+	return timeout
+}
+
+// Send sends the request with the timeout of the methods. Timeout covers reading of the response body
+func (c *Client) Send(req *http.Request, methods ...string) (*http.Response, error) {
+	timeout := c.Timeout(methods...)
+	if timeout == 0 {
+		return c.Transport.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	res, err := c.Transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// cancelBody releases the request context when the body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
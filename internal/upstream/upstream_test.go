@@ -0,0 +1,108 @@
+package upstream
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/testhelpers"
+)
+
+func TestClientTimeout(t *testing.T) {
+	client, err := New(config.UpstreamSettings{
+		Timeout: 10,
+		Methods: []config.UpstreamMethod{
+			{Name: "Filecoin.StateMarketDeals", Timeout: 120},
+			{Name: "Filecoin.StateListMessages", Timeout: 0},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 10*time.Second, client.Timeout())
+	require.Equal(t, 10*time.Second, client.Timeout("Filecoin.ChainHead"))
+	require.Equal(t, 120*time.Second, client.Timeout("Filecoin.ChainHead", "Filecoin.StateMarketDeals"))
+	require.Equal(t, time.Duration(0), client.Timeout("Filecoin.ChainHead", "Filecoin.StateListMessages"))
+	require.Equal(t, time.Duration(0), Default().Timeout("Filecoin.ChainHead"))
+
+	updater := client.WithDefaultTimeout(60 * time.Second)
+	require.Equal(t, 60*time.Second, updater.Timeout("Filecoin.ChainHead"))
+	require.Equal(t, 120*time.Second, updater.Timeout("Filecoin.StateMarketDeals"))
+	require.Equal(t, client.Transport, updater.Transport)
+}
+
+func TestClientSend(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(1500 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	client, err := New(config.UpstreamSettings{
+		Methods: []config.UpstreamMethod{{Name: "slow", Timeout: 1}},
+	})
+	require.NoError(t, err)
+
+	for path, fails := range map[string]bool{"/fast": false, "/slow": true} {
+		req, err := http.NewRequest("POST", backend.URL+path, nil)
+		require.NoError(t, err)
+		res, err := client.Send(req, "slow")
+		if fails {
+			require.Error(t, err, path)
+			continue
+		}
+		require.NoError(t, err, path)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	dir := t.TempDir()
+	certs, err := testhelpers.GenerateCertificates(dir, "proxy")
+	require.NoError(t, err)
+	serverCert, err := tls.LoadX509KeyPair(certs.CertFile, certs.KeyFile)
+	require.NoError(t, err)
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "proxy", r.TLS.PeerCertificates[0].Subject.CommonName)
+		assert.Equal(t, 2, r.ProtoMajor)
+		w.WriteHeader(http.StatusOK)
+	}))
+	backend.EnableHTTP2 = true
+	backend.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    certs.CAPool,
+	}
+	backend.StartTLS()
+	defer backend.Close()
+
+	settings := config.UpstreamSettings{
+		CAFile:   certs.CAFile,
+		CertFile: certs.ClientCertFile,
+		KeyFile:  certs.ClientKeyFile,
+	}
+	client, err := New(settings)
+	require.NoError(t, err)
+	res, err := client.Get(backend.URL)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	settings.CertFile, settings.KeyFile = "", ""
+	client, err = New(settings)
+	require.NoError(t, err)
+	_, err = client.Get(backend.URL)
+	require.Error(t, err)
+
+	settings.CAFile = certs.KeyFile
+	_, err = New(settings)
+	require.Error(t, err)
+}
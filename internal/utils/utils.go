@@ -60,6 +60,47 @@ func Min(v1 int, v2 ...int) int {
 	return min
 }
 
+// NormalizeYAML converts maps decoded from yaml to the maps with string keys as they are decoded from json
+func NormalizeYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			res[fmt.Sprintf("%v", key)] = NormalizeYAML(value)
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			res[key] = NormalizeYAML(value)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for idx, value := range v {
+			res[idx] = NormalizeYAML(value)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
+// Difference returns elements of the first slice that are absent in the second one
+func Difference(s1, s2 []int) []int {
+	exclude := make(map[int]struct{}, len(s2))
+	for _, v := range s2 {
+		exclude[v] = struct{}{}
+	}
+	var res []int
+	for _, v := range s1 {
+		if _, ok := exclude[v]; !ok {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
 func Read(r io.ReadCloser) ([]byte, error) {
 	if r == nil {
 		return nil, nil
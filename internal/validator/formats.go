@@ -0,0 +1,64 @@
+package validator
+
+import (
+	"regexp"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+var (
+	cidV0Regexp   = regexp.MustCompile(`^Qm[1-9A-HJ-NP-Za-km-z]{44}$`)
+	cidV1Regexp   = regexp.MustCompile(`^(b[a-z2-7]{20,}|z[1-9A-HJ-NP-Za-km-z]{20,})$`)
+	addressRegexp = regexp.MustCompile(`^[ft]([0]\d{1,20}|[12][a-z2-7]{39}|3[a-z2-7]{84}|4\d{1,20}f[a-z2-7]+)$`)
+)
+
+// definitions are added to every method schema and can be referenced as #/definitions/<name>
+var definitions = map[string]interface{}{
+	"cid": map[string]interface{}{
+		"type":                 "object",
+		"required":             []interface{}{"/"},
+		"additionalProperties": false,
+		"properties": map[string]interface{}{
+			"/": map[string]interface{}{"type": "string", "format": "cid"},
+		},
+	},
+	"tipset_key": map[string]interface{}{
+		"type":  []interface{}{"array", "null"},
+		"items": map[string]interface{}{"$ref": "#/definitions/cid"},
+	},
+	"address": map[string]interface{}{
+		"type":   "string",
+		"format": "address",
+	},
+	"epoch": map[string]interface{}{
+		"type":    "integer",
+		"minimum": 0,
+	},
+}
+
+type cidFormat struct{}
+
+// IsFormat checks CID string in the v0 or the base32/base58 v1 encoding
+func (cidFormat) IsFormat(input interface{}) bool {
+	s, ok := input.(string)
+	if !ok {
+		return false
+	}
+	return cidV0Regexp.MatchString(s) || cidV1Regexp.MatchString(s)
+}
+
+type addressFormat struct{}
+
+// IsFormat checks filecoin address string of any protocol
+func (addressFormat) IsFormat(input interface{}) bool {
+	s, ok := input.(string)
+	if !ok {
+		return false
+	}
+	return addressRegexp.MatchString(s)
+}
+
+func init() {
+	gojsonschema.FormatCheckers.Add("cid", cidFormat{})
+	gojsonschema.FormatCheckers.Add("address", addressFormat{})
+}
@@ -0,0 +1,119 @@
+package validator
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+	"github.com/protofire/filecoin-rpc-proxy/internal/utils"
+
+	"github.com/xeipuuv/gojsonschema"
+	"gopkg.in/yaml.v2"
+)
+
+// Validator checks params of the requests against JSON schemas of the methods
+type Validator struct {
+	schemas map[string]*gojsonschema.Schema
+}
+
+// New compiles method schemas
+func New(methods []config.MethodSchema) (*Validator, error) {
+	v := &Validator{schemas: make(map[string]*gojsonschema.Schema, len(methods))}
+	for _, method := range methods {
+		schema := method.Schema
+		if method.SchemaFile != "" {
+			var err error
+			if schema, err = schemaFromFile(method.SchemaFile); err != nil {
+				return nil, fmt.Errorf("cannot read schema for method %s: %w", method.Name, err)
+			}
+		}
+		compiled, err := compile(schema)
+		if err != nil {
+			return nil, fmt.Errorf("cannot compile schema for method %s: %w", method.Name, err)
+		}
+		v.schemas[method.Name] = compiled
+	}
+	return v, nil
+}
+
+// FromConfig initializes validator from config
+func FromConfig(c *config.Config) (*Validator, error) {
+	return New(c.ParamsValidation.Methods)
+}
+
+func schemaFromFile(filename string) (interface{}, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var schema interface{}
+	// yaml decoder also reads json schemas
+	if err := yaml.NewDecoder(file).Decode(&schema); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+func compile(schema interface{}) (*gojsonschema.Schema, error) {
+	root, ok := utils.NormalizeYAML(schema).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema should be an object")
+	}
+	defs, ok := root["definitions"].(map[string]interface{})
+	if !ok {
+		defs = make(map[string]interface{}, len(definitions))
+	}
+	for name, def := range definitions {
+		if _, ok := defs[name]; !ok {
+			defs[name] = def
+		}
+	}
+	root["definitions"] = defs
+	return gojsonschema.NewSchema(gojsonschema.NewGoLoader(root))
+}
+
+// Validate checks params of the request. Requests of methods without schema are valid
+func (v *Validator) Validate(req requests.RPCRequest) error {
+	schema, ok := v.schemas[req.Method]
+	if !ok {
+		return nil
+	}
+	params := req.Params
+	if params == nil {
+		params = []interface{}{}
+	}
+	result, err := schema.Validate(gojsonschema.NewGoLoader(params))
+	if err != nil {
+		return err
+	}
+	if result.Valid() {
+		return nil
+	}
+	errs := make([]string, len(result.Errors()))
+	for idx, resultErr := range result.Errors() {
+		errs[idx] = resultErr.String()
+	}
+	return fmt.Errorf("invalid params: %s", strings.Join(errs, "; "))
+}
+
+// Filter sets invalid params responses on positions of invalid requests having empty responses.
+// Returns positions of invalid requests
+func (v *Validator) Filter(reqs requests.RPCRequests, results requests.RPCResponses) []int {
+	if len(v.schemas) == 0 {
+		return nil
+	}
+	var invalid []int
+	for idx, req := range reqs {
+		if !results[idx].IsEmpty() {
+			continue
+		}
+		if err := v.Validate(req); err != nil {
+			results[idx] = requests.InvalidParamsResponse(req.ID, err.Error())
+			invalid = append(invalid, idx)
+		}
+	}
+	return invalid
+}
@@ -0,0 +1,78 @@
+package validator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/protofire/filecoin-rpc-proxy/internal/config"
+	"github.com/protofire/filecoin-rpc-proxy/internal/requests"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testMethod = "Filecoin.StateGetActor"
+	testCID    = "bafy2bzacedbpaxdcmlwwtq3nd5vn6ezpdvz2j3e2dtgnqxufuvfghzsc4p7ws"
+)
+
+var configValidation = `
+proxy_url: http://test.com
+jwt_secret: token
+params_validation:
+  methods:
+  - name: Filecoin.StateGetActor
+    schema:
+      type: array
+      minItems: 2
+      maxItems: 2
+      items:
+      - $ref: "#/definitions/address"
+      - $ref: "#/definitions/tipset_key"
+`
+
+func TestValidatorFromConfig(t *testing.T) {
+	conf, err := config.New(strings.NewReader(configValidation))
+	require.NoError(t, err)
+	require.NoError(t, conf.Validate())
+	v, err := FromConfig(conf)
+	require.NoError(t, err)
+
+	cases := []struct {
+		params interface{}
+		valid  bool
+	}{
+		{params: []interface{}{"f01234", nil}, valid: true},
+		{params: []interface{}{"f01234", []interface{}{map[string]interface{}{"/": testCID}}}, valid: true},
+		{params: []interface{}{"f01234"}, valid: false},
+		{params: []interface{}{"x01234", nil}, valid: false},
+		{params: []interface{}{"f01234", []interface{}{map[string]interface{}{"/": "cid"}}}, valid: false},
+		{params: []interface{}{"f01234", []interface{}{testCID}}, valid: false},
+		{params: nil, valid: false},
+	}
+	for idx, c := range cases {
+		err := v.Validate(requests.RPCRequest{Method: testMethod, Params: c.params})
+		if c.valid {
+			require.NoError(t, err, idx)
+		} else {
+			require.Error(t, err, idx)
+		}
+	}
+	require.NoError(t, v.Validate(requests.RPCRequest{Method: "Filecoin.ChainHead", Params: []interface{}{1}}))
+}
+
+func TestValidatorFilter(t *testing.T) {
+	v, err := New([]config.MethodSchema{{
+		Name:   testMethod,
+		Schema: map[interface{}]interface{}{"type": "array", "maxItems": 0},
+	}})
+	require.NoError(t, err)
+	reqs := requests.RPCRequests{
+		{JSONRPC: "2.0", ID: 1, Method: testMethod},
+		{JSONRPC: "2.0", ID: 2, Method: testMethod, Params: []interface{}{1}},
+	}
+	results := make(requests.RPCResponses, len(reqs))
+	invalid := v.Filter(reqs, results)
+	require.Equal(t, []int{1}, invalid)
+	require.True(t, results[0].IsEmpty())
+	require.NotNil(t, results[1].Error)
+}